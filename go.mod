@@ -5,6 +5,7 @@ go 1.14
 require (
 	github.com/aws/aws-sdk-go v1.30.22
 	github.com/go-playground/assert/v2 v2.0.1
+	github.com/klauspost/compress v1.10.5
 	github.com/sirupsen/logrus v1.6.0
 	github.com/urfave/cli/v2 v2.2.0
 )
@@ -0,0 +1,339 @@
+package replay
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tarExtensions are the extensions of tar archives, which can be compressed
+// in any of the ways that day files can
+var tarExtensions = []string{".tar", ".tar.gz", ".tgz", ".tar.zst", ".tar.bz2", ".tbz2"}
+
+// isArchive checks if a data source is an archive, from its extension
+func isArchive(dataSource string) bool {
+	return isZip(dataSource) || isTar(dataSource)
+}
+
+func isZip(dataSource string) bool {
+	return strings.HasSuffix(dataSource, ".zip")
+}
+
+func isTar(dataSource string) bool {
+	for _, extension := range tarExtensions {
+		if strings.HasSuffix(dataSource, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveSource reads the files inside of a tar or zip archive, which is
+// itself read from another Source. Paths start with the path of the archive,
+// ex: `/tmp/audit-data.tar.gz/2016/01/01.jsonl.gz`.
+//
+// The archive is indexed the first time that it's read, and every file is
+// read from the index after that. Zip archives can be read in place when the
+// Source returns a local file, everything else is spooled to a temp file
+// first, since tar archives can only be read from start to finish. That file
+// is kept open until Close.
+type archiveSource struct {
+	source      Source
+	archivePath string
+
+	mutex       sync.Mutex
+	members     map[string]archiveMember // keyed by the path inside of the archive, nil until indexed
+	topLevelDir string                   // the dir that every member is in, when there is only one, ex: `audit-data/`
+	file        *os.File                 // the file that the members are read from, nil until indexed
+}
+
+// archiveMember is a file inside of an archive
+type archiveMember struct {
+	size    int64
+	modTime time.Time
+	open    func() (io.ReadCloser, error)
+}
+
+func newArchiveSource(source Source, archivePath string) *archiveSource {
+	return &archiveSource{source: source, archivePath: archivePath}
+}
+
+func (a *archiveSource) Open(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+	member, found, err := a.member(ctx, path)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	output, err = member.open()
+	if err != nil {
+		err = fmt.Errorf("error reading file (%s) in archive: %w", path, err)
+		return nil, false, err
+	}
+	return output, true, nil
+}
+
+func (a *archiveSource) Stat(ctx context.Context, path string) (output FileInfo, found bool, err error) {
+	member, found, err := a.member(ctx, path)
+	if err != nil || !found {
+		return FileInfo{}, found, err
+	}
+	return FileInfo{Size: member.size, ModTime: member.modTime}, true, nil
+}
+
+// Close closes the file that the archive was indexed into. The archive is
+// indexed again if it's read after that.
+func (a *archiveSource) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.members, a.topLevelDir, a.file = nil, "", nil
+	return err
+}
+
+// List lists dir inside of the archive, along with dir inside of its top
+// level dir, since a path can be in either (see member)
+func (a *archiveSource) List(ctx context.Context, dir string) ([]string, error) {
+	members, topLevelDir, err := a.index(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.TrimSuffix(a.relativePath(dir), "/") + "/"
+	if prefix == "/" {
+		prefix = ""
+	}
+	seen := map[string]bool{}
+	output := []string{}
+	for name := range members {
+		for _, trim := range []string{"", topLevelDir} {
+			if !strings.HasPrefix(name, trim+prefix) {
+				continue
+			}
+			relative := name[len(trim):]
+			entry := prefix + strings.SplitAfter(relative[len(prefix):], "/")[0] // <= directories keep their trailing `/`
+			if !seen[entry] {
+				seen[entry] = true
+				output = append(output, a.archivePath+"/"+entry)
+			}
+		}
+	}
+	sort.Strings(output)
+	return output, nil
+}
+
+// relativePath turns a full path into the path inside of the archive
+func (a *archiveSource) relativePath(fullPath string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(fullPath, a.archivePath), "/")
+}
+
+// member finds the file at fullPath in the archive. Archives are often made
+// from a single dir like `audit-data/`, so a path that isn't in the archive
+// is looked for in its top level dir as well. The top level dir can also be
+// the first dir of the layout, like the `2016/` of an archive of a year, so
+// the path is looked for as is first.
+func (a *archiveSource) member(ctx context.Context, fullPath string) (archiveMember, bool, error) {
+	members, topLevelDir, err := a.index(ctx)
+	if err != nil {
+		return archiveMember{}, false, err
+	}
+	name := a.relativePath(fullPath)
+	member, found := members[name]
+	if !found && topLevelDir != "" {
+		member, found = members[topLevelDir+name]
+	}
+	return member, found, nil
+}
+
+// index reads the list of files in the archive the first time it's called,
+// along with their top level dir. An error isn't kept, so the next call tries
+// again.
+func (a *archiveSource) index(ctx context.Context) (map[string]archiveMember, string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.members != nil {
+		return a.members, a.topLevelDir, nil
+	}
+
+	raw, found, err := a.source.Open(ctx, a.archivePath)
+	if err != nil {
+		return nil, "", err
+	}
+	if !found {
+		err = withKind(ErrNotFound, fmt.Errorf("the archive %s was not found", a.archivePath))
+		return nil, "", err
+	}
+
+	start := time.Now()
+	var members map[string]archiveMember
+	var file *os.File
+	if isZip(a.archivePath) {
+		members, file, err = indexZip(raw, a.archivePath)
+	} else {
+		members, file, err = indexTar(raw, a.archivePath)
+	}
+	if err != nil {
+		err = withKind(ErrDataError, fmt.Errorf("error indexing archive (%s): %w", a.archivePath, err))
+		return nil, "", err
+	}
+	logrus.Debugf("indexed %d files in the archive %s in %s", len(members), a.archivePath, time.Since(start))
+
+	a.members, a.topLevelDir, a.file = members, findTopLevelDir(members), file
+	return a.members, a.topLevelDir, nil
+}
+
+// indexZip reads the central directory of a zip archive. The file that the
+// files are read from is returned to be closed by the caller, unless there's
+// an error.
+func indexZip(raw io.ReadCloser, archivePath string) (map[string]archiveMember, *os.File, error) {
+	file, err := localFile(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	zipReader, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	members := map[string]archiveMember{}
+	for _, zipFile := range zipReader.File {
+		if zipFile.FileInfo().IsDir() {
+			continue
+		}
+		zipFile := zipFile
+		members[cleanMemberName(zipFile.Name)] = archiveMember{
+			size:    int64(zipFile.UncompressedSize64),
+			modTime: zipFile.Modified,
+			open: func() (io.ReadCloser, error) {
+				return zipFile.Open()
+			},
+		}
+	}
+	return members, file, nil
+}
+
+// indexTar decompresses a tar archive into a temp file, noting where each
+// file starts in it, so that files can be read without decompressing the
+// whole archive again. raw is closed once it has been read, and the temp file
+// is returned to be closed by the caller, unless there's an error.
+func indexTar(raw io.ReadCloser, archivePath string) (map[string]archiveMember, *os.File, error) {
+	decompressed, err := decompress(raw, archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := spoolToTempFile(decompressed)
+	decompressed.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the tar reader would seek past the files if it could, which would throw
+	// off the count, so it only gets a plain reader
+	counter := &countingReader{reader: io.NewSectionReader(file, 0, math.MaxInt64)}
+	tarReader := tar.NewReader(counter)
+	members := map[string]archiveMember{}
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		// the tar reader doesn't buffer, so everything up to here was headers
+		section := io.NewSectionReader(file, counter.count, header.Size)
+		members[cleanMemberName(header.Name)] = archiveMember{
+			size:    header.Size,
+			modTime: header.ModTime,
+			open: func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(io.NewSectionReader(section, 0, section.Size())), nil
+			},
+		}
+	}
+	return members, file, nil
+}
+
+// countingReader counts the bytes that have been read through it
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+// localFile returns raw as a file that can be read at any offset, spooling it
+// to a temp file if it isn't a local file already
+func localFile(raw io.ReadCloser) (*os.File, error) {
+	if file, ok := raw.(*os.File); ok {
+		return file, nil
+	}
+	defer raw.Close()
+	return spoolToTempFile(raw)
+}
+
+// spoolToTempFile copies source to a temp file. The file is removed right
+// away where the os allows it, so that it's cleaned up once it's closed.
+func spoolToTempFile(source io.Reader) (*os.File, error) {
+	file, err := ioutil.TempFile("", "replay-archive-")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(file.Name())
+
+	_, err = io.Copy(file, source)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// cleanMemberName normalizes the name of a file in an archive, ex:
+// `./audit-data/2016/01/01.jsonl.gz` => `audit-data/2016/01/01.jsonl.gz`
+func cleanMemberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// findTopLevelDir returns the dir that every file is in, with its trailing
+// `/`, or "" when there isn't only one
+func findTopLevelDir(members map[string]archiveMember) string {
+	topLevelDir := ""
+	for name := range members {
+		index := strings.Index(name, "/")
+		if index < 0 {
+			return ""
+		}
+		if topLevelDir != "" && name[:index+1] != topLevelDir {
+			return ""
+		}
+		topLevelDir = name[:index+1]
+	}
+	return topLevelDir
+}
@@ -0,0 +1,261 @@
+package replay
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// archiveTestFiles are the day files in the test archives, in a top level
+// dir like an archive that was made from the dir of a data source
+var archiveTestFiles = map[string]string{
+	"./audit-data/2016/01/01.jsonl.gz": `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`,
+	"./audit-data/2016/01/02.jsonl.gz": `{"changeTime": "2016-01-02T00:30:00.001059", "after": {"ambientTemp": 81.0}, "before": {"ambientTemp": 79.0}}`,
+	"./audit-data/2016/02/01.jsonl":    `{"changeTime": "2016-02-01T00:30:00.001059", "after": {"ambientTemp": 83.0}, "before": {"ambientTemp": 81.0}}`,
+}
+
+// archiveTestFilesAtRoot are the day files of a test archive without a top
+// level dir, like the real archive, where every file is in the dir of its
+// year
+var archiveTestFilesAtRoot = map[string]string{
+	"./2016/01/01.jsonl.gz": `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`,
+	"./2016/01/02.jsonl.gz": `{"changeTime": "2016-01-02T00:30:00.001059", "after": {"ambientTemp": 81.0}, "before": {"ambientTemp": 79.0}}`,
+}
+
+// writeTestTarGz writes the files to a tar.gz archive
+func writeTestTarGz(t *testing.T, path string, files map[string]string) {
+	archive := bytes.Buffer{}
+	gzWriter := gzip.NewWriter(&archive)
+	tarWriter := tar.NewWriter(gzWriter)
+	for name, fileData := range files {
+		data := []byte(fileData)
+		if filepath.Ext(name) == ".gz" {
+			data = gzipBytes(t, fileData)
+		}
+		err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: time.Now(), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tarWriter.Write(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestZip writes the files to a zip archive
+func writeTestZip(t *testing.T, path string, files map[string]string) {
+	archive := bytes.Buffer{}
+	zipWriter := zip.NewWriter(&archive)
+	for name, fileData := range files {
+		data := []byte(fileData)
+		if filepath.Ext(name) == ".gz" {
+			data = gzipBytes(t, fileData)
+		}
+		writer, err := zipWriter.Create(name[2:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = writer.Write(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestTarGz(t, filepath.Join(dir, "audit-data.tar.gz"), archiveTestFiles)
+	writeTestZip(t, filepath.Join(dir, "audit-data.zip"), archiveTestFiles)
+	writeTestTarGz(t, filepath.Join(dir, "year.tar.gz"), archiveTestFilesAtRoot)
+	writeTestZip(t, filepath.Join(dir, "year.zip"), archiveTestFilesAtRoot)
+
+	tdata := []struct {
+		testCase          string
+		dataSource        string
+		at                time.Time
+		expectedOutput    interface{}
+		expectedErrorKind error
+	}{
+		{
+			testCase:       "tar_gz",
+			dataSource:     filepath.Join(dir, "audit-data.tar.gz"),
+			at:             testTime("2016-01-02T03:00"),
+			expectedOutput: 81.0,
+		},
+		{
+			testCase:       "tar_gz_plain_member",
+			dataSource:     filepath.Join(dir, "audit-data.tar.gz"),
+			at:             testTime("2016-02-01T03:00"),
+			expectedOutput: 83.0,
+		},
+		{
+			testCase:       "zip",
+			dataSource:     filepath.Join(dir, "audit-data.zip"),
+			at:             testTime("2016-01-01T03:00"),
+			expectedOutput: 79.0,
+		},
+		{
+			testCase:       "tar_gz_without_a_top_level_dir",
+			dataSource:     filepath.Join(dir, "year.tar.gz"),
+			at:             testTime("2016-01-02T03:00"),
+			expectedOutput: 81.0,
+		},
+		{
+			testCase:       "zip_without_a_top_level_dir",
+			dataSource:     filepath.Join(dir, "year.zip"),
+			at:             testTime("2016-01-01T03:00"),
+			expectedOutput: 79.0,
+		},
+		{
+			testCase:          "missing_day",
+			dataSource:        filepath.Join(dir, "audit-data.zip"),
+			at:                testTime("2017-01-01T03:00"),
+			expectedErrorKind: ErrNotFound,
+		},
+		{
+			testCase:          "missing_archive",
+			dataSource:        filepath.Join(dir, "missing.tar.gz"),
+			at:                testTime("2016-01-01T03:00"),
+			expectedErrorKind: ErrNotFound,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			client, err := NewClient(test.dataSource, WithMaxLookback(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			// logic under test
+			output, err := client.StateAt(context.Background(), test.at, []string{"ambientTemp"})
+
+			// assertions
+			if test.expectedErrorKind != nil {
+				if !errors.Is(err, test.expectedErrorKind) {
+					t.Errorf("expected the error (%v) to be a %v error", err, test.expectedErrorKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if output.Fields["ambientTemp"] != test.expectedOutput {
+				t.Errorf("expected %v to equal %v", output.Fields["ambientTemp"], test.expectedOutput)
+			}
+		})
+	}
+}
+
+func TestArchiveSourceClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"audit-data.zip", "audit-data.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			archivePath := filepath.Join(dir, name)
+			if name == "audit-data.zip" {
+				writeTestZip(t, archivePath, archiveTestFiles)
+			} else {
+				writeTestTarGz(t, archivePath, archiveTestFiles)
+			}
+			client, err := NewClient(archivePath, WithMaxLookback(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = client.StateAt(context.Background(), testTime("2016-01-01T03:00"), []string{"ambientTemp"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// logic under test
+			err = client.Close()
+
+			// assertions
+			if err != nil {
+				t.Fatal(err)
+			}
+			if client.source.(*archiveSource).file != nil {
+				t.Error("expected the archive to be closed")
+			}
+			output, err := client.StateAt(context.Background(), testTime("2016-01-01T03:00"), []string{"ambientTemp"}) // <= indexed again
+			if err != nil {
+				t.Fatal(err)
+			}
+			if output.Fields["ambientTemp"] != 79.0 {
+				t.Errorf("expected %v to equal %v", output.Fields["ambientTemp"], 79.0)
+			}
+			client.Close()
+		})
+	}
+}
+
+func TestArchiveSourceList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archivePath := filepath.Join(dir, "audit-data.tar.gz")
+	writeTestTarGz(t, archivePath, archiveTestFiles)
+	source := newArchiveSource(fileSource{}, archivePath)
+
+	tdata := []struct {
+		dir            string
+		expectedOutput []string
+	}{
+		{
+			dir:            archivePath,
+			expectedOutput: []string{archivePath + "/2016/", archivePath + "/audit-data/"}, // <= either can be the start of a path
+		},
+		{
+			dir:            archivePath + "/2016/01",
+			expectedOutput: []string{archivePath + "/2016/01/01.jsonl.gz", archivePath + "/2016/01/02.jsonl.gz"},
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.dir, func(t *testing.T) {
+			// logic under test
+			output, err := source.List(context.Background(), test.dir)
+
+			// assertions
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(test.expectedOutput, output) {
+				t.Errorf("expected %v to equal %v", test.expectedOutput, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package replay
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The ways that a Client can handle a line of a day file that isn't an event
+// (see WithBadLinePolicy)
+const (
+	BadLineFail = "fail" // stop with an ErrDataError, the default
+	BadLineWarn = "warn" // skip the line with a warning on stderr
+	BadLineSkip = "skip" // skip the line
+)
+
+// badLinePolicies are the valid bad line policies, in the order they're shown
+var badLinePolicies = []string{BadLineFail, BadLineWarn, BadLineSkip}
+
+// The reasons that a line isn't an event
+const (
+	BadLineJSON       = "invalidJSON"       // the line isn't a json object with an after and a before
+	BadLineChangeTime = "invalidChangeTime" // the changeTime is missing, or isn't in any of the formats of ParseTime
+)
+
+// badLineError is a line of a file that isn't an event. The lines after it
+// can still be read.
+type badLineError struct {
+	path       string
+	lineNumber int    // starting from 1
+	reason     string // one of BadLineJSON or BadLineChangeTime
+	err        error
+}
+
+func (e badLineError) Error() string {
+	return fmt.Sprintf("error %s on line number (%d) of file (%s): %s", e.action(), e.lineNumber, e.path, e.err)
+}
+
+func (e badLineError) Unwrap() error {
+	return e.err
+}
+
+// message is the error without where the line is
+func (e badLineError) message() string {
+	return fmt.Sprintf("error %s: %s", e.action(), e.err)
+}
+
+func (e badLineError) action() string {
+	if e.reason == BadLineChangeTime {
+		return "parsing the changeTime"
+	}
+	return "reading json"
+}
+
+// checkBadLinePolicy checks that policy is one of the bad line policies, the
+// empty policy is BadLineFail
+func checkBadLinePolicy(policy string) error {
+	if policy == "" {
+		return nil
+	}
+	for _, valid := range badLinePolicies {
+		if policy == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("the bad line policy (%s) isn't one of %s", policy, strings.Join(badLinePolicies, ", "))
+}
+
+// SkippedLines is the lines of the day files that a query skipped, because
+// they weren't events
+type SkippedLines struct {
+	Total   int            `json:"total"`
+	Reasons map[string]int `json:"reasons"` // keyed by BadLineJSON or BadLineChangeTime
+}
+
+// String is a summary of the skipped lines for a log, ex: `skipped 3 bad lines
+// (invalidChangeTime: 1, invalidJSON: 2)`
+func (s SkippedLines) String() string {
+	reasons := []string{}
+	for reason, count := range s.Reasons {
+		reasons = append(reasons, fmt.Sprintf("%s: %d", reason, count))
+	}
+	sort.Strings(reasons)
+	return fmt.Sprintf("skipped %d bad lines (%s)", s.Total, strings.Join(reasons, ", "))
+}
+
+// badLines is how a single query handles the lines that aren't events, along
+// with the lines it has skipped. A query can read the same file more than
+// once, like range does for the partition of `from`, so each line is only
+// counted once. A nil badLines stops at the first bad line.
+type badLines struct {
+	policy  string
+	skipped map[string]string // the reason for each line, keyed by `{path}:{lineNumber}`
+}
+
+func newBadLines(policy string) *badLines {
+	return &badLines{policy: policy, skipped: map[string]string{}}
+}
+
+// skips checks if bad lines are skipped instead of failing the query
+func (b *badLines) skips() bool {
+	return b != nil && b.policy != BadLineFail && b.policy != ""
+}
+
+// watch sets how the scanner handles bad lines
+func (b *badLines) watch(scanner *lineScanner) {
+	if !b.skips() {
+		return
+	}
+	scanner.onBadLine = func(line badLineError) error {
+		key := fmt.Sprintf("%s:%d", line.path, line.lineNumber)
+		if _, seen := b.skipped[key]; seen {
+			return nil
+		}
+		b.skipped[key] = line.reason
+		if b.policy == BadLineWarn {
+			logrus.Warnf("skipped a bad line: %s", line)
+		}
+		return nil
+	}
+}
+
+// summary returns the lines that were skipped, or nil when there weren't any
+func (b *badLines) summary() *SkippedLines {
+	if b == nil || len(b.skipped) == 0 {
+		return nil
+	}
+	output := &SkippedLines{Reasons: map[string]int{}}
+	for _, reason := range b.skipped {
+		output.Total++
+		output.Reasons[reason]++
+	}
+	return output
+}
@@ -0,0 +1,109 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientBadLinePolicy(t *testing.T) {
+	source := NewMemSource()
+	source.WriteFile("mem://audit-data/2016/01/01.jsonl", []byte(`{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+{"changeTime": "2016-01-01T02:00:00", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 78.0}}
+{"changeTime": "2016-01-01T02:30:00", "after": {"ambientTemp": 80.0}, "bef
+
+{"after": {"ambientTemp": 80.0}, "before": {"ambientTemp": 79.0}}
+{"changeTime": "2016-01-01T04:00:00", "after": {"ambientTemp": 81.0}, "before": {"ambientTemp": 79.0}}
+`))
+	skipped := &SkippedLines{Total: 2, Reasons: map[string]int{BadLineJSON: 1, BadLineChangeTime: 1}}
+
+	tdata := []struct {
+		testCase          string
+		policy            string
+		expectedSkipped   *SkippedLines
+		expectedErrorKind error
+		expectedError     string
+	}{
+		{
+			testCase:          "default",
+			expectedErrorKind: ErrDataError,
+			expectedError:     "error reading json on line number (3) of file (mem://audit-data/2016/01/01.jsonl): unexpected end of JSON input",
+		},
+		{
+			testCase:          "fail",
+			policy:            BadLineFail,
+			expectedErrorKind: ErrDataError,
+			expectedError:     "error reading json on line number (3) of file (mem://audit-data/2016/01/01.jsonl): unexpected end of JSON input",
+		},
+		{
+			testCase:        "warn",
+			policy:          BadLineWarn,
+			expectedSkipped: skipped,
+		},
+		{
+			testCase:        "skip",
+			policy:          BadLineSkip,
+			expectedSkipped: skipped,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			client, err := NewClient("mem://audit-data", WithSource("mem", source), WithBadLinePolicy(test.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// logic under test
+			state, stateErr := client.StateAt(context.Background(), testTime("2016-01-01T03:00"), []string{"ambientTemp"})
+			timeline, rangeErr := client.Range(context.Background(), testTime("2016-01-01T01:30"), testTime("2016-01-01T05:00"), []string{"ambientTemp"})
+			samples, sampleErr := client.Sample(context.Background(), testTime("2016-01-01T01:30"), testTime("2016-01-01T05:00"), time.Hour, []string{"ambientTemp"})
+
+			// assertions
+			for _, err := range []error{stateErr, rangeErr, sampleErr} {
+				if test.expectedErrorKind == nil && err != nil {
+					t.Fatal(err)
+				}
+				if test.expectedErrorKind != nil && !errors.Is(err, test.expectedErrorKind) {
+					t.Errorf("expected the error (%v) to be a %v error", err, test.expectedErrorKind)
+				}
+				if test.expectedError != "" && !strings.HasSuffix(err.Error(), test.expectedError) {
+					t.Errorf("expected the error (%v) to end with %s", err, test.expectedError)
+				}
+			}
+			if test.expectedErrorKind != nil {
+				return
+			}
+			if !reflect.DeepEqual(map[string]interface{}{"ambientTemp": 79.0}, state.Fields) {
+				t.Errorf("expected %v to be the state before the bad lines", state.Fields)
+			}
+			if len(timeline.Changes) != 2 {
+				t.Errorf("expected the bad lines to be left out of %+v", timeline.Changes)
+			}
+			// the range and the sample read the partition of `from` twice, but each
+			// line is only counted once
+			for _, output := range []*SkippedLines{state.Skipped, timeline.Skipped, samples.Skipped} {
+				if !reflect.DeepEqual(test.expectedSkipped, output) {
+					t.Errorf("expected %+v to equal %+v", output, test.expectedSkipped)
+				}
+			}
+		})
+	}
+}
+
+func TestBadLinePolicyIsChecked(t *testing.T) {
+	_, err := NewClient("/tmp/ehub_data", WithBadLinePolicy("ignore"))
+	if !errors.Is(err, ErrBadInput) {
+		t.Errorf("expected the error (%v) to be a %v error", err, ErrBadInput)
+	}
+}
+
+func TestSkippedLinesString(t *testing.T) {
+	skipped := SkippedLines{Total: 3, Reasons: map[string]int{BadLineJSON: 2, BadLineChangeTime: 1}}
+	expectedOutput := "skipped 3 bad lines (invalidChangeTime: 1, invalidJSON: 2)"
+	if skipped.String() != expectedOutput {
+		t.Errorf("expected %s to equal %s", skipped.String(), expectedOutput)
+	}
+}
@@ -0,0 +1,282 @@
+package replay
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultCacheMaxBytes is the default size that a cache directory is evicted
+// down to, once it grows past it
+const DefaultCacheMaxBytes = 1 << 30 // 1 GiB
+
+// cacheLockName is the file that processes lock while changing the cache
+// directory. Entries and temp files are kept next to it.
+const cacheLockName = ".lock"
+
+// cacheTempPrefix is the prefix of files that are still being written
+const cacheTempPrefix = ".tmp-"
+
+// cacheTempMaxAge is how old a temp file can be before it's assumed to be
+// left over from a process that crashed, and is removed during eviction
+const cacheTempMaxAge = time.Hour
+
+// diskCache is a content addressed cache of remote files on the local
+// filesystem, shared by every `replay` process on the machine.
+//
+// Entries are keyed by a hash of where the file came from and its version
+// (ex: the bucket, key and ETag of an s3 object), so an entry never goes
+// stale, it just stops being used. Entries are written to a temp file and
+// renamed into place, so readers never see a partial file. The least
+// recently used entries are evicted once the directory grows past maxBytes,
+// with the last use tracked by each entry's modification time.
+type diskCache struct {
+	dir      string
+	maxBytes int64
+}
+
+// cacheStats describes the contents of a cache directory
+type cacheStats struct {
+	Dir      string `json:"dir"`
+	Entries  int    `json:"entries"`
+	Bytes    int64  `json:"bytes"`
+	MaxBytes int64  `json:"maxBytes"`
+}
+
+// cacheEntry is a file in the cache directory
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func newDiskCache(dir string, maxBytes int64) *diskCache {
+	return &diskCache{dir: dir, maxBytes: maxBytes}
+}
+
+// defaultCacheDir returns the cache directory for the current user, ex:
+// `~/.cache/replay` on linux
+func defaultCacheDir() string {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "replay-cache")
+	}
+	return filepath.Join(userCacheDir, "replay")
+}
+
+// cacheKey hashes the parts that identify a version of a remote file
+func cacheKey(parts ...string) string {
+	hash := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(hash[:])
+}
+
+// open returns the cached file for key, and marks it as recently used
+func (c *diskCache) open(key string) (output *os.File, found bool, err error) {
+	path := filepath.Join(c.dir, key)
+	fileObject, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("error opening cache file (%s): %w", path, err)
+		return nil, false, err
+	}
+
+	// another process could have evicted the entry since it was opened,
+	// which is fine since the open file can still be read
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		logrus.Debugf("error marking cache file (%s) as used: %s", path, err)
+	}
+
+	return fileObject, true, nil
+}
+
+// store copies source into the cache as key, then evicts the least recently
+// used entries if the cache has grown too big. The new entry is never
+// evicted by its own store, even when it is bigger than maxBytes on its own,
+// but the next store can evict it.
+func (c *diskCache) store(key string, source io.Reader) error {
+	err := os.MkdirAll(c.dir, 0755)
+	if err != nil {
+		err = fmt.Errorf("error creating cache dir (%s): %w", c.dir, err)
+		return err
+	}
+
+	tempFile, err := ioutil.TempFile(c.dir, cacheTempPrefix)
+	if err != nil {
+		err = fmt.Errorf("error creating cache file: %w", err)
+		return err
+	}
+	defer os.Remove(tempFile.Name()) // <= a no-op once it has been renamed
+
+	_, err = io.Copy(tempFile, source)
+	closeErr := tempFile.Close()
+	if err != nil {
+		err = fmt.Errorf("error writing cache file (%s): %w", tempFile.Name(), err)
+		return err
+	}
+	if closeErr != nil {
+		err = fmt.Errorf("error writing cache file (%s): %w", tempFile.Name(), closeErr)
+		return err
+	}
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := filepath.Join(c.dir, key)
+	err = os.Rename(tempFile.Name(), path)
+	if err != nil {
+		err = fmt.Errorf("error renaming cache file (%s): %w", path, err)
+		return err
+	}
+
+	return c.evict(key)
+}
+
+// evict removes the least recently used entries other than keep until the
+// cache fits in maxBytes, the cache must be locked by the caller
+func (c *diskCache) evict(keep string) error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+
+	var totalBytes int64
+	for _, entry := range entries {
+		totalBytes += entry.size
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, entry := range entries {
+		if totalBytes <= c.maxBytes {
+			break
+		}
+		if filepath.Base(entry.path) == keep {
+			continue
+		}
+		logrus.Debugf("evicting cache file (%s)", entry.path)
+		if err := os.Remove(entry.path); err != nil {
+			logrus.Debugf("error evicting cache file (%s): %s", entry.path, err)
+			continue
+		}
+		totalBytes -= entry.size
+	}
+
+	// cleanup temp files from processes that didn't finish writing them
+	tempPaths, err := filepath.Glob(filepath.Join(c.dir, cacheTempPrefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, tempPath := range tempPaths {
+		info, err := os.Stat(tempPath)
+		if err == nil && time.Since(info.ModTime()) > cacheTempMaxAge {
+			os.Remove(tempPath)
+		}
+	}
+
+	return nil
+}
+
+// stats counts the entries in the cache
+func (c *diskCache) stats() (cacheStats, error) {
+	output := cacheStats{Dir: c.dir, MaxBytes: c.maxBytes}
+	entries, err := c.entries()
+	if err != nil {
+		return cacheStats{}, err
+	}
+	output.Entries = len(entries)
+	for _, entry := range entries {
+		output.Bytes += entry.size
+	}
+	return output, nil
+}
+
+// clear removes every entry in the cache
+func (c *diskCache) clear() error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err = os.Remove(entry.path)
+		if err != nil && !os.IsNotExist(err) {
+			err = fmt.Errorf("error removing cache file (%s): %w", entry.path, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// entries lists the cached files, skipping the lock and temp files
+func (c *diskCache) entries() ([]cacheEntry, error) {
+	infos, err := ioutil.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("error reading cache dir (%s): %w", c.dir, err)
+		return nil, err
+	}
+
+	output := []cacheEntry{}
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		output = append(output, cacheEntry{
+			path:    filepath.Join(c.dir, info.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	return output, nil
+}
+
+// lock takes an exclusive lock on the cache directory, so that only one
+// process at a time is renaming or removing entries
+func (c *diskCache) lock() (unlock func(), err error) {
+	err = os.MkdirAll(c.dir, 0755)
+	if err != nil {
+		err = fmt.Errorf("error creating cache dir (%s): %w", c.dir, err)
+		return nil, err
+	}
+
+	path := filepath.Join(c.dir, cacheLockName)
+	lockFile, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		err = fmt.Errorf("error opening cache lock (%s): %w", path, err)
+		return nil, err
+	}
+	err = lockFileExclusive(lockFile)
+	if err != nil {
+		lockFile.Close()
+		err = fmt.Errorf("error locking cache lock (%s): %w", path, err)
+		return nil, err
+	}
+
+	return func() {
+		unlockFile(lockFile)
+		lockFile.Close()
+	}, nil
+}
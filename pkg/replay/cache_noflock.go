@@ -0,0 +1,19 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package replay
+
+import (
+	"os"
+)
+
+// lockFileExclusive is a no-op where flock isn't available. Entries are
+// still renamed into place, so concurrent processes can at worst evict an
+// entry more than once.
+func lockFileExclusive(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,168 @@
+package replay
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := newDiskCache(dir, 10)
+
+	// logic under test
+	_, found, err := cache.open(cacheKey("s3", "bucket", "2016/01/01.jsonl.gz", "etag-1"))
+	if err != nil || found {
+		t.Fatalf("expected an empty cache, found: %v, err: %v", found, err)
+	}
+	err = cache.store(cacheKey("s3", "bucket", "2016/01/01.jsonl.gz", "etag-1"), strings.NewReader("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assertions
+	cached, found, err := cache.open(cacheKey("s3", "bucket", "2016/01/01.jsonl.gz", "etag-1"))
+	if err != nil || !found {
+		t.Fatalf("expected a cached file, found: %v, err: %v", found, err)
+	}
+	defer cached.Close()
+	contents, err := ioutil.ReadAll(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "first" {
+		t.Errorf("expected %q to equal %q", contents, "first")
+	}
+	_, found, _ = cache.open(cacheKey("s3", "bucket", "2016/01/01.jsonl.gz", "etag-2"))
+	if found {
+		t.Error("expected a new ETag to miss the cache")
+	}
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := newDiskCache(dir, 10)
+
+	// store two entries, with the first one being the least recently used
+	for index, key := range []string{"older", "newer"} {
+		err = cache.store(key, strings.NewReader("12345"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(time.Duration(index-2) * time.Hour)
+		os.Chtimes(filepath.Join(dir, key), modTime, modTime)
+	}
+
+	// logic under test
+	err = cache.store("newest", strings.NewReader("12345"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assertions
+	for key, expectedFound := range map[string]bool{"older": false, "newer": true, "newest": true} {
+		_, err := os.Stat(filepath.Join(dir, key))
+		if found := err == nil; found != expectedFound {
+			t.Errorf("expected the entry (%s) to be found: %v, but it was found: %v", key, expectedFound, found)
+		}
+	}
+	stats, err := cache.stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 2 || stats.Bytes != 10 {
+		t.Errorf("expected 2 entries and 10 bytes, got %+v", stats)
+	}
+}
+
+func TestDiskCacheEntryLargerThanMax(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := newDiskCache(dir, 10)
+	err = cache.store("older", strings.NewReader("12345"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// logic under test
+	err = cache.store("large", strings.NewReader("larger than the whole cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assertions
+	cached, found, err := cache.open("large")
+	if err != nil || !found {
+		t.Fatalf("expected the large entry to be cached, found: %v, err: %v", found, err)
+	}
+	defer cached.Close()
+	contents, err := ioutil.ReadAll(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "larger than the whole cache" {
+		t.Errorf("expected %q to equal %q", contents, "larger than the whole cache")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "older")); err == nil {
+		t.Error("expected the older entry to be evicted")
+	}
+}
+
+func TestDiskCacheConcurrentStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := newDiskCache(dir, DefaultCacheMaxBytes)
+
+	// logic under test
+	wait := sync.WaitGroup{}
+	for index := 0; index < 10; index++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if err := cache.store("key", strings.NewReader("contents")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wait.Wait()
+
+	// assertions
+	stats, err := cache.stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 1 || stats.Bytes != int64(len("contents")) {
+		t.Errorf("expected 1 complete entry and no temp files, got %+v", stats)
+	}
+	tempPaths, _ := filepath.Glob(filepath.Join(dir, cacheTempPrefix+"*"))
+	if len(tempPaths) != 0 {
+		t.Errorf("expected the temp files to be cleaned up, got %v", tempPaths)
+	}
+
+	err = cache.clear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, _ = cache.stats()
+	if stats.Entries != 0 {
+		t.Errorf("expected an empty cache after clear, got %+v", stats)
+	}
+}
@@ -0,0 +1,435 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// checkpointVersion is bumped whenever the format of checkpoint changes, so
+// that older checkpoints are ignored instead of misread
+const checkpointVersion = 2
+
+// checkpointDir is the dir in the data source that checkpoints are kept in
+const checkpointDir = "_checkpoints"
+
+// checkpoint is a snapshot of the full state of every field at the start of a
+// partition, built from every change before it. A point in time query can
+// start from the nearest checkpoint, instead of walking back through day
+// files until it has seen every field change.
+//
+// Each checkpoint is built on the one before it, its base, so it only keeps
+// the day files that were replayed since its base. A query ignores a
+// checkpoint once any of those has changed, which only takes a stat for each
+// of them. A day file that changed before the base isn't noticed by a query,
+// but Client.Checkpoint checks every base, and writes the checkpoints again
+// from the oldest one that is stale. A day file that is added to a partition
+// that didn't have one isn't noticed by either.
+type checkpoint struct {
+	Version   int                        `json:"version"`
+	Partition time.Time                  `json:"partition"`
+	Fields    map[string]checkpointField `json:"fields"`         // keyed by the top level field
+	Base      string                     `json:"base,omitempty"` // the path of the checkpoint it was built on, if any
+	Files     []checkpointFile           `json:"files"`          // the day files that were replayed since the base
+	path      string                     // where it was read from
+}
+
+// checkpointField is the value of a field at the start of a partition, and the
+// changeTime of the last change to it
+type checkpointField struct {
+	Value      interface{} `json:"value"`
+	ChangeTime time.Time   `json:"changeTime"`
+}
+
+// checkpointFile is a day file as it was when it was replayed into a
+// checkpoint
+type checkpointFile struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// checkpointFunc loads the checkpoint of the partition that starts at
+// partition, found is false when it doesn't have one. It's made for a single
+// query, since the checkpoints that exist are only looked up once.
+type checkpointFunc func(ctx context.Context, partition time.Time) (output checkpoint, found bool, err error)
+
+// checkpointPath returns the path of the checkpoint of a partition, ex:
+// `/tmp/ehub_data/_checkpoints/2016-01-01T00Z.json.gz`. The checkpoints of
+// each device are kept apart. The utc offset keeps the hour that is repeated
+// when the clocks go back apart from the hour before it, ex:
+// `2016-11-06T01-0400` and `2016-11-06T01-0500`.
+func (l layout) checkpointPath(dataSource string, partition time.Time) string {
+	return l.checkpointDir(dataSource) + "/" + partition.Format("2006-01-02T15Z0700") + ".json.gz"
+}
+
+// checkpointDir returns the dir that the checkpoints of the layout are in
+func (l layout) checkpointDir(dataSource string) string {
+	dir := strings.TrimSuffix(dataSource, "/") + "/" + checkpointDir
+	if l.device != "" {
+		dir += "/" + l.device
+	}
+	return dir
+}
+
+// sourceCheckpoints turns a Source into a checkpointFunc. Most partitions
+// don't have a checkpoint, so the checkpoint dir is listed the first time the
+// checkpointFunc is called, instead of checking for each partition's
+// checkpoint. Sources that can't be listed, like a plain http server, are
+// checked for each partition instead.
+func sourceCheckpoints(source Source, dataSource string, layout layout) checkpointFunc {
+	var listed map[string]bool // <= nil until the dir is listed, or when it can't be
+	listable := true
+	return func(ctx context.Context, partition time.Time) (output checkpoint, found bool, err error) {
+		if listable && listed == nil {
+			paths, err := source.List(ctx, layout.checkpointDir(dataSource))
+			if errors.Is(err, ErrListNotSupported) {
+				listable = false
+			} else if err != nil {
+				err = fmt.Errorf("error listing the checkpoints: %w", err)
+				return checkpoint{}, false, err
+			}
+			listed = make(map[string]bool, len(paths))
+			for _, path := range paths {
+				listed[path] = true
+			}
+		}
+
+		path := layout.checkpointPath(dataSource, partition)
+		if listable {
+			found = listed[path]
+		} else {
+			_, found, err = source.Stat(ctx, path)
+		}
+		if err != nil || !found {
+			return checkpoint{}, false, err
+		}
+		output, found, stale, err := readCheckpoint(ctx, source, path)
+		if err != nil || !found || stale {
+			return checkpoint{}, false, err
+		}
+		return output, true, nil
+	}
+}
+
+// readCheckpoint reads the checkpoint at path, found is false when there
+// isn't one or it has an old version. It's stale when any of the day files
+// that were replayed since its base has changed.
+func readCheckpoint(ctx context.Context, source Source, path string) (output checkpoint, found bool, stale bool, err error) {
+	found, err = readGzipJSON(ctx, source, path, &output)
+	if err != nil || !found {
+		return checkpoint{}, false, false, err
+	}
+	if output.Version != checkpointVersion {
+		logrus.Debugf("the checkpoint %s has an old version, so it was ignored", path)
+		return checkpoint{}, false, false, nil
+	}
+	output.path = path
+	for _, file := range output.Files {
+		info, found, err := source.Stat(ctx, file.Path)
+		if err != nil {
+			return checkpoint{}, false, false, err
+		}
+		if !found || file.Size != info.Size || !file.ModTime.Equal(info.ModTime) {
+			logrus.Debugf("the checkpoint %s is stale, since %s has changed", path, file.Path)
+			return output, true, true, nil
+		}
+	}
+	return output, true, false, nil
+}
+
+// oldestStaleBase follows the bases of a checkpoint back to the first one,
+// and returns the oldest one that is stale, found is false when none of
+// them are
+func oldestStaleBase(ctx context.Context, source Source, snapshot checkpoint) (output checkpoint, found bool, err error) {
+	for path := snapshot.Base; path != ""; {
+		base, exists, stale, err := readCheckpoint(ctx, source, path)
+		if err != nil {
+			return checkpoint{}, false, err
+		}
+		if !exists {
+			break // <= the bases before a missing one can't be checked
+		}
+		if stale {
+			output, found = base, true
+		}
+		path = base.Base
+	}
+	return output, found, nil
+}
+
+// applyCheckpoint loads the checkpoint of a partition into the nearest before,
+// found is false when the partition doesn't have one. Changes that have
+// already been read are later than the checkpoint, so they take precedence.
+func applyCheckpoint(ctx context.Context, checkpointFunc checkpointFunc, partition time.Time, fields []fieldPath, inputDateTime time.Time, nearestBefore map[string]fieldData) (found bool, err error) {
+	if checkpointFunc == nil {
+		return false, nil
+	}
+	snapshot, found, err := checkpointFunc(ctx, partition)
+	if err != nil {
+		err = fmt.Errorf("error reading the checkpoint for %s: %w", formatTime(partition, "2006-01-02T15:04:05"), err)
+		return false, err
+	}
+	if !found {
+		return false, nil
+	}
+
+	logrus.Debugf("starting from the checkpoint for %s", formatTime(partition, "2006-01-02T15:04:05"))
+	for field, value := range snapshot.Fields {
+		setNearest(setNearestInput{
+			debugString:   "checkpoint",
+			fieldData:     map[string]interface{}{field: value.Value},
+			inputFields:   fields,
+			firstCompare:  func(inputDateTime time.Time) bool { return !value.ChangeTime.After(inputDateTime) },
+			secondCompare: value.ChangeTime.After,
+			inputDateTime: inputDateTime,
+			changeTime:    value.ChangeTime,
+			path:          snapshot.path,
+			complete:      true,
+			nearest:       nearestBefore,
+		})
+	}
+	return true, nil
+}
+
+type getCheckpointInput struct {
+	dataSource  string
+	layout      layout
+	source      Source
+	readerFunc  readerFunc
+	maxLookback int
+	from        time.Time
+	to          time.Time
+}
+
+// CheckpointResult is the checkpoints that were written by Client.Checkpoint
+type CheckpointResult struct {
+	From        string           `json:"from"`
+	To          string           `json:"to"`
+	Checkpoints []CheckpointFile `json:"checkpoints"`
+}
+
+// CheckpointFile is a checkpoint that was written
+type CheckpointFile struct {
+	Partition string `json:"partition"`
+	Path      string `json:"path"`
+	Fields    int    `json:"fields"`
+}
+
+// getCheckpoint writes a checkpoint for every partition between from and to
+// (inclusive), replacing the checkpoints that are already there.
+//
+// The state is built up by replaying every change in order, starting from the
+// nearest checkpoint before from, or from maxLookback days before from when
+// there isn't one. Fields that last changed before that aren't known, the
+// same as for a query without checkpoints. When any of the bases of that
+// checkpoint is stale, the checkpoints are written again from the oldest one
+// that is stale instead.
+func getCheckpoint(ctx context.Context, input getCheckpointInput) (output CheckpointResult, err error) {
+	err = checkTimeRange(input.from, input.to)
+	if err != nil {
+		return CheckpointResult{}, err
+	}
+	source, ok := input.source.(WritableSource)
+	if !ok {
+		err = withKind(ErrBadInput, fmt.Errorf("checkpoints can't be written to the dataSource (%s), only to local directories", input.dataSource))
+		return CheckpointResult{}, err
+	}
+	checkpoints := sourceCheckpoints(source, input.dataSource, input.layout)
+
+	// find where to start replaying from
+	first := input.layout.partitionStart(input.from)
+	start := input.layout.addPartitions(first, -input.layout.lookbackPartitions(input.maxLookback))
+	var base checkpoint
+	for partition := first; !partition.Before(start); partition = input.layout.addPartitions(partition, -1) {
+		snapshot, found, err := checkpoints(ctx, partition)
+		if err != nil {
+			return CheckpointResult{}, err
+		}
+		if found {
+			base = snapshot
+			break
+		}
+	}
+	stale, found, err := oldestStaleBase(ctx, source, base)
+	if err != nil {
+		return CheckpointResult{}, err
+	}
+	if found {
+		logrus.Debugf("the checkpoint %s is stale, so the checkpoints are written again from it", stale.path)
+		first = input.layout.partitionStart(stale.Partition)
+		start = input.layout.addPartitions(first, -input.layout.lookbackPartitions(input.maxLookback))
+		base = checkpoint{}
+		if stale.Base != "" {
+			// every base before the oldest stale one is fresh
+			base, found, _, err = readCheckpoint(ctx, source, stale.Base)
+			if err != nil {
+				return CheckpointResult{}, err
+			}
+		}
+	}
+	state := map[string]checkpointField{}
+	files := []checkpointFile{}
+	if base.path != "" {
+		start, state = input.layout.partitionStart(base.Partition), base.Fields
+	}
+	// the hour that is repeated when the clocks go back is in the same file as
+	// the hour before it, so it's only replayed once, and doesn't get a
+	// checkpoint of its own
+	previous := ""
+	for partition := start; partition.Before(first); partition = input.layout.addPartitions(partition, 1) {
+		path := input.layout.path(input.dataSource, partition)
+		if path == previous {
+			continue
+		}
+		previous = path
+
+		files, err = replayPartition(ctx, input, partition, state, files)
+		if err != nil {
+			return CheckpointResult{}, err
+		}
+	}
+
+	output = CheckpointResult{
+		From:        formatTime(input.from, "2006-01-02T15:04:05"),
+		To:          formatTime(input.to, "2006-01-02T15:04:05"),
+		Checkpoints: []CheckpointFile{},
+	}
+	for partition := first; !partition.After(input.to); partition = input.layout.addPartitions(partition, 1) {
+		path := input.layout.path(input.dataSource, partition)
+		if path == previous {
+			continue
+		}
+		previous = path
+
+		snapshot := checkpoint{
+			Version:   checkpointVersion,
+			Partition: partition,
+			Fields:    make(map[string]checkpointField, len(state)),
+			Base:      base.path,
+			Files:     files,
+		}
+		for field, value := range state {
+			snapshot.Fields[field] = checkpointField{Value: removeNulls(value.Value), ChangeTime: value.ChangeTime}
+		}
+		snapshotPath := input.layout.checkpointPath(input.dataSource, partition)
+		err = writeGzipJSON(ctx, source, snapshotPath, snapshot)
+		if err != nil {
+			return CheckpointResult{}, err
+		}
+		logrus.Debugf("wrote the checkpoint %s with %d fields", snapshotPath, len(snapshot.Fields))
+		base, files = checkpoint{path: snapshotPath}, nil // <= the next checkpoint is built on this one
+		output.Checkpoints = append(output.Checkpoints, CheckpointFile{
+			Partition: formatTime(partition, "2006-01-02T15:04:05"),
+			Path:      snapshotPath,
+			Fields:    len(snapshot.Fields),
+		})
+
+		files, err = replayPartition(ctx, input, partition, state, files)
+		if err != nil {
+			return CheckpointResult{}, err
+		}
+	}
+
+	return output, nil
+}
+
+// errOutOfOrder stops replayPartition from streaming a day file whose
+// changeTimes go backwards
+var errOutOfOrder = errors.New("the changeTimes of the day file aren't in order")
+
+// replayPartition applies the after of every change in a partition to the
+// state, in changeTime order, and adds the day files it read to files. Day
+// files are written in order, so the changes are applied as they're read.
+// Nothing guarantees it though, so when a changeTime goes backwards the state
+// is put back and the partition is read again, keeping the after of every
+// change to sort them.
+func replayPartition(ctx context.Context, input getCheckpointInput, partition time.Time, state map[string]checkpointField, files []checkpointFile) ([]checkpointFile, error) {
+	path := input.layout.path(input.dataSource, partition)
+	read := map[string]bool{}
+	previous := make(map[string]checkpointField, len(state)) // <= mergePatch doesn't modify the values, so a shallow copy is enough
+	for field, value := range state {
+		previous[field] = value
+	}
+
+	var last time.Time
+	_, err := readDayFile(ctx, path, input.layout.timeZone(), nil, input.readerFunc, func(line dayFileLine) error {
+		if line.changeTime.Before(last) {
+			return errOutOfOrder
+		}
+		last = line.changeTime
+		read[line.path] = true
+		applyAfter(state, line.data.After, line.changeTime)
+		return nil
+	})
+	if err != nil && !errors.Is(err, errOutOfOrder) {
+		return nil, err
+	}
+	if err == nil {
+		return describeFiles(ctx, input.source, read, files)
+	}
+
+	logrus.Debugf("the changeTimes of %s aren't in order, so it's sorted before it's replayed", path)
+	for field := range state {
+		delete(state, field)
+	}
+	for field, value := range previous {
+		state[field] = value
+	}
+	type change struct {
+		changeTime time.Time
+		after      map[string]interface{}
+	}
+	changes := []change{}
+	_, err = readDayFile(ctx, path, input.layout.timeZone(), nil, input.readerFunc, func(line dayFileLine) error {
+		changes = append(changes, change{changeTime: line.changeTime, after: line.data.After})
+		read[line.path] = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].changeTime.Before(changes[j].changeTime)
+	})
+	for _, change := range changes {
+		applyAfter(state, change.after, change.changeTime)
+	}
+	return describeFiles(ctx, input.source, read, files)
+}
+
+// describeFiles adds the size and modTime of every path to files, in the
+// order of the paths
+func describeFiles(ctx context.Context, source Source, paths map[string]bool, files []checkpointFile) ([]checkpointFile, error) {
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+	for _, path := range sorted {
+		info, found, err := source.Stat(ctx, path)
+		if err == nil && !found {
+			err = withKind(ErrNotFound, fmt.Errorf("the day file %s was not found", path))
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, checkpointFile{Path: path, Size: info.Size, ModTime: info.ModTime})
+	}
+	return files, nil
+}
+
+// applyAfter merges the after of a change into the state
+func applyAfter(state map[string]checkpointField, after map[string]interface{}, changeTime time.Time) {
+	for field, value := range after {
+		state[field] = checkpointField{
+			Value:      mergePatch(state[field].Value, value), // <= nulls are kept until the checkpoint is written
+			ChangeTime: changeTime,
+		}
+	}
+}
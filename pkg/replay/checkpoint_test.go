@@ -0,0 +1,244 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestClientCheckpoint(t *testing.T) {
+	dayFiles := map[string]string{
+		"2016/01/01.jsonl": `
+			{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0, "setpoint": {"heatTemp": 68.0, "coolTemp": 76.0}}, "before": {"ambientTemp": 77.0, "setpoint": {"heatTemp": 67.0, "coolTemp": 75.0}}}
+			{"changeTime": "2016-01-01T02:00:00", "after": {"schedule": true, "fan": "auto"}, "before": {"schedule": false, "fan": "on"}}
+			{"changeTime": "2016-01-01T04:00:00", "after": {"mode": "cool"}, "before": {"mode": "heat"}}
+			{"changeTime": "2016-01-01T03:00:00", "after": {"mode": "heat"}, "before": {"mode": "off"}}
+		`,
+		"2016/01/03.jsonl": `
+			{"changeTime": "2016-01-03T02:00:00", "after": {"setpoint": {"heatTemp": 69.0}, "fan": null}, "before": {"setpoint": {"heatTemp": 68.0}, "fan": "auto"}}
+		`,
+		"2016/01/20.jsonl": `
+			{"changeTime": "2016-01-20T01:00:00", "after": {"ambientTemp": 80.0}, "before": {"ambientTemp": 78.0}}
+		`,
+	}
+	fields := []string{"ambientTemp", "schedule", "fan", "mode", "setpoint", "setpoint.coolTemp"}
+
+	source := NewMemSource()
+	for path, dayFile := range dayFiles {
+		source.WriteFile("mem://checkpointed/"+path, []byte(dayFile))
+		source.WriteFile("mem://plain/"+path, []byte(dayFile))
+	}
+	checkpointed, err := NewClient("mem://checkpointed", WithSource("mem", source), WithMaxLookback(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := NewClient("mem://plain", WithSource("mem", source), WithMaxLookback(30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := checkpointed.Checkpoint(context.Background(), testTime("2016-01-01T00:00"), testTime("2016-01-25T00:00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Checkpoints) != 25 {
+		t.Fatalf("expected 25 checkpoints, but there were %d", len(result.Checkpoints))
+	}
+
+	tdata := []string{
+		"2016-01-01T00:30",
+		"2016-01-01T01:00",
+		"2016-01-02T00:00",
+		"2016-01-03T02:00",
+		"2016-01-03T12:00",
+		"2016-01-15T00:00", // <= further back than the max lookback for every field
+		"2016-01-20T00:30",
+		"2016-01-25T00:00",
+	}
+	for _, dateTime := range tdata {
+		t.Run(dateTime, func(t *testing.T) {
+			// logic under test
+			output, err := checkpointed.StateAt(context.Background(), testTime(dateTime), fields)
+			expectedOutput, expectedErr := plain.StateAt(context.Background(), testTime(dateTime), fields)
+
+			// assertions
+			if (err != nil) != (expectedErr != nil) {
+				t.Fatalf("expected the error (%v) to match the error without checkpoints (%v)", err, expectedErr)
+			}
+			if !reflect.DeepEqual(expectedOutput, output) {
+				t.Errorf("expected %v to equal %v", output, expectedOutput)
+			}
+		})
+	}
+}
+
+func TestCheckpointFromEarlierCheckpoint(t *testing.T) {
+	source := NewMemSource()
+	source.WriteFile("mem://audit-data/2016/01/01.jsonl", []byte(`{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}`))
+	source.WriteFile("mem://audit-data/2016/01/10.jsonl", []byte(`{"changeTime": "2016-01-10T01:00:00", "after": {"schedule": true}, "before": {"schedule": false}}`))
+	client, err := NewClient("mem://audit-data", WithSource("mem", source), WithMaxLookback(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// logic under test
+	_, err = client.Checkpoint(context.Background(), testTime("2016-01-01T00:00"), testTime("2016-01-09T00:00")) // <= within the max lookback of the 11th
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Checkpoint(context.Background(), testTime("2016-01-11T00:00"), testTime("2016-01-11T00:00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := client.StateAt(context.Background(), testTime("2016-01-11T12:00"), []string{"ambientTemp", "schedule"})
+
+	// assertions
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOutput := map[string]interface{}{"ambientTemp": 78.0, "schedule": true}
+	if !reflect.DeepEqual(expectedOutput, output.Fields) {
+		t.Errorf("expected %v to equal %v", output.Fields, expectedOutput)
+	}
+}
+
+func TestCheckpointStale(t *testing.T) {
+	source := NewMemSource()
+	source.WriteFile("mem://audit-data/2016/01/01.jsonl", []byte(`{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}`))
+	client, err := NewClient("mem://audit-data", WithSource("mem", source), WithMaxLookback(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := client.Checkpoint(context.Background(), testTime("2016-01-01T00:00"), testTime("2016-01-10T00:00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, written := range result.Checkpoints {
+		var snapshot checkpoint
+		_, err = readGzipJSON(context.Background(), source, written.Path, &snapshot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshot.Files) > 1 {
+			t.Errorf("expected the checkpoint %s to only have the day files since its base, but it had %+v", written.Path, snapshot.Files)
+		}
+	}
+	source.WriteFile("mem://audit-data/2016/01/01.jsonl", []byte(`{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 100.0}, "before": {"ambientTemp": 77.0}}`)) // <= a backfill
+
+	tdata := []struct {
+		testCase       string
+		at             string
+		rebuild        bool
+		expectedOutput interface{}
+	}{
+		{
+			testCase:       "stale",
+			at:             "2016-01-02T12:00", // <= the checkpoint of the 2nd has the changed day file
+			expectedOutput: 100.0,
+		},
+		{
+			testCase:       "stale_base",
+			at:             "2016-01-10T12:00", // <= a query only checks the day files since the base
+			expectedOutput: 78.0,
+		},
+		{
+			testCase:       "rebuilt",
+			at:             "2016-01-10T12:00",
+			rebuild:        true,
+			expectedOutput: 100.0,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			if test.rebuild {
+				result, err := client.Checkpoint(context.Background(), testTime("2016-01-10T00:00"), testTime("2016-01-10T00:00"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(result.Checkpoints) != 9 {
+					t.Errorf("expected the checkpoints to be written again from the 2nd, but they were %+v", result.Checkpoints)
+				}
+			}
+
+			// logic under test
+			output, err := client.StateAt(context.Background(), testTime(test.at), []string{"ambientTemp"})
+
+			// assertions
+			if err != nil {
+				t.Fatal(err)
+			}
+			if output.Fields["ambientTemp"] != test.expectedOutput {
+				t.Errorf("expected %v to equal %v", output.Fields["ambientTemp"], test.expectedOutput)
+			}
+		})
+	}
+}
+
+func TestCheckpointsListedOnce(t *testing.T) {
+	source := &countingSource{MemSource: NewMemSource()}
+	source.WriteFile("mem://audit-data/2016/01/01.jsonl", []byte(`{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}`))
+	client, err := NewClient("mem://audit-data", WithSource("mem", source), WithMaxLookback(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Checkpoint(context.Background(), testTime("2016-01-01T00:00"), testTime("2016-01-01T00:00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.stats, source.lists = 0, 0
+
+	// logic under test
+	_, err = client.StateAt(context.Background(), testTime("2016-01-05T12:00"), []string{"ambientTemp"})
+
+	// assertions
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.lists != 1 {
+		t.Errorf("expected the checkpoints to be listed once, but they were listed %d times", source.lists)
+	}
+	if source.stats != 1 { // <= only the day file of the checkpoint that was found
+		t.Errorf("expected 1 stat, but there were %d", source.stats)
+	}
+}
+
+func TestCheckpointStopsTheWalkForObjects(t *testing.T) {
+	source := &countingSource{MemSource: NewMemSource()}
+	source.WriteFile("mem://audit-data/2016/01/01.jsonl", []byte(`{"changeTime": "2016-01-01T01:00:00", "after": {"setpoint": {"coolTemp": 76.0}}, "before": {"setpoint": {"coolTemp": 75.0}}}`))
+	client, err := NewClient("mem://audit-data", WithSource("mem", source), WithMaxLookback(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Checkpoint(context.Background(), testTime("2016-01-05T00:00"), testTime("2016-01-05T00:00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.opens = 0
+
+	// logic under test
+	output, err := client.StateAt(context.Background(), testTime("2016-01-05T12:00"), []string{"setpoint"})
+
+	// assertions
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOutput := map[string]interface{}{"setpoint": map[string]interface{}{"coolTemp": 76.0}}
+	if !reflect.DeepEqual(expectedOutput, output.Fields) {
+		t.Errorf("expected %v to equal %v", output.Fields, expectedOutput)
+	}
+	expectedOpens := len(DefaultExtensions) + 1 // <= the 5th, with each extension, and its checkpoint
+	if source.opens != expectedOpens {
+		t.Errorf("expected %d opens, but there were %d", expectedOpens, source.opens)
+	}
+}
+
+func TestCheckpointNotWritable(t *testing.T) {
+	client, err := NewClient("mem://audit-data.zip", WithSource("mem", NewMemSource()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Checkpoint(context.Background(), testTime("2016-01-01T00:00"), testTime("2016-01-02T00:00"))
+	if !errors.Is(err, ErrBadInput) {
+		t.Errorf("expected the error (%v) to be a %v error", err, ErrBadInput)
+	}
+}
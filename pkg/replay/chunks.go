@@ -0,0 +1,209 @@
+package replay
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dayFileLine is a json line of a day file, along with its parsed changeTime
+type dayFileLine struct {
+	data       fileLineJSON
+	changeTime time.Time
+	path       string // the file the line is from, which is one of the chunks for a chunked partition
+	offset     int64  // where the line starts in the decompressed file
+	lineNumber int    // starting from 1, counting empty lines
+}
+
+// lineScanner reads the json lines of a single file, one line at a time
+type lineScanner struct {
+	path       string
+	location   *time.Location // for changeTimes without a timezone
+	lines      *bufio.Reader
+	lineNumber int   // the number of lines that have been read
+	offset     int64 // the number of bytes that have been read
+
+	// onBadLine is called for a line that isn't an event, and the scanner
+	// carries on with the next line when it returns nil. A nil onBadLine
+	// stops at the first bad line (see badLines.watch).
+	onBadLine func(line badLineError) error
+}
+
+func newLineScanner(path string, location *time.Location, fileData io.Reader) *lineScanner {
+	return &lineScanner{path: path, location: location, lines: bufio.NewReader(fileData)}
+}
+
+// next returns the next line that isn't empty, done is true once the end of
+// the file has been reached
+func (s *lineScanner) next() (output dayFileLine, done bool, err error) {
+	for {
+		lineString, readErr := s.lines.ReadString('\n')
+		lineOffset := s.offset
+		s.offset += int64(len(lineString))
+		s.lineNumber++
+		lineNumber := s.lineNumber
+		if readErr != nil && readErr != io.EOF {
+			err = fmt.Errorf("error reading line number (%d) for file (%s): %w", lineNumber, s.path, readErr)
+			return dayFileLine{}, false, err
+		}
+		if readErr == io.EOF && lineString == "" {
+			return dayFileLine{}, true, nil
+		}
+
+		// skip empty lines
+		lineString = strings.TrimSpace(lineString)
+		if lineString == "" {
+			continue
+		}
+
+		// get json data, and then the changeTime from it
+		var lineData fileLineJSON
+		var changeTime time.Time
+		reason := BadLineJSON
+		err := json.Unmarshal([]byte(lineString), &lineData)
+		if err == nil {
+			reason = BadLineChangeTime
+			changeTime, err = stringToTimeIn(string(lineData.ChangeTime), s.location)
+		}
+		if err != nil {
+			err = s.badLine(badLineError{path: s.path, lineNumber: lineNumber, reason: reason, err: err})
+			if err != nil {
+				return dayFileLine{}, false, err
+			}
+			continue
+		}
+
+		output = dayFileLine{data: lineData, changeTime: changeTime, path: s.path, offset: lineOffset, lineNumber: lineNumber}
+		return output, false, nil
+	}
+}
+
+// badLine stops at a line that isn't an event, unless onBadLine says to carry
+// on with the next line
+func (s *lineScanner) badLine(line badLineError) error {
+	if s.onBadLine == nil {
+		return withKind(ErrDataError, line)
+	}
+	return s.onBadLine(line)
+}
+
+// skipTo skips ahead to the line that starts at offset, which is lineNumber.
+// The lines in between aren't decoded.
+func (s *lineScanner) skipTo(offset int64, lineNumber int) error {
+	if offset < s.offset {
+		return fmt.Errorf("can't skip back to offset (%d) in file (%s)", offset, s.path)
+	}
+	skipped, err := io.CopyN(ioutil.Discard, s.lines, offset-s.offset)
+	s.offset += skipped
+	if err != nil {
+		err = fmt.Errorf("error skipping to offset (%d) in file (%s): %w", offset, s.path, err)
+		return err
+	}
+	s.lineNumber = lineNumber - 1 // <= the lines before it
+	return nil
+}
+
+// mergeChunks calls onLine for the lines of every chunk in changeTime order.
+// Each chunk is in changeTime order already, but chunks can overlap in time,
+// so this is a k-way merge with a heap of the next line of each chunk. Ties
+// go to the chunk that comes first. An event that is an exact duplicate of
+// one from another chunk is only passed to onLine once.
+func mergeChunks(scanners []*lineScanner, onLine func(line dayFileLine) error) error {
+	heads := &chunkHeap{}
+	for index, scanner := range scanners {
+		line, done, err := scanner.next()
+		if err != nil {
+			return err
+		}
+		if !done {
+			heap.Push(heads, chunkHead{line: line, chunk: index})
+		}
+	}
+
+	// the events at the current changeTime, keyed by their contents, and the
+	// chunk they came from
+	seen := map[string]int{}
+	seenTime := time.Time{}
+	for heads.Len() > 0 {
+		head := heap.Pop(heads).(chunkHead)
+
+		if !head.line.changeTime.Equal(seenTime) {
+			seen = map[string]int{}
+			seenTime = head.line.changeTime
+		}
+		key, err := eventKey(head.line.data)
+		if err != nil {
+			return err
+		}
+		chunk, duplicate := seen[key]
+		if duplicate && chunk != head.chunk {
+			logrus.Debugf("dropped a duplicate event at %s from %s", head.line.changeTime, scanners[head.chunk].path)
+		} else {
+			seen[key] = head.chunk
+			err = onLine(head.line)
+			if err != nil {
+				return err
+			}
+		}
+
+		line, done, err := scanners[head.chunk].next()
+		if err != nil {
+			return err
+		}
+		if !done {
+			heap.Push(heads, chunkHead{line: line, chunk: head.chunk})
+		}
+	}
+	return nil
+}
+
+// eventKey is the contents of an event, for finding duplicates. Map keys are
+// sorted when they're marshalled, so the order of the keys in the file
+// doesn't matter.
+func eventKey(lineData fileLineJSON) (string, error) {
+	key, err := json.Marshal(struct {
+		After  map[string]interface{}
+		Before map[string]interface{}
+	}{lineData.After, lineData.Before})
+	if err != nil {
+		err = fmt.Errorf("error comparing events: %w", err)
+		return "", err
+	}
+	return string(key), nil
+}
+
+// chunkHead is the next line of a chunk that hasn't been merged yet
+type chunkHead struct {
+	line  dayFileLine
+	chunk int // the index of the chunk
+}
+
+// chunkHeap is a min heap of chunkHeads by changeTime (see container/heap)
+type chunkHeap []chunkHead
+
+func (h chunkHeap) Len() int { return len(h) }
+
+func (h chunkHeap) Less(i, j int) bool {
+	if h[i].line.changeTime.Equal(h[j].line.changeTime) {
+		return h[i].chunk < h[j].chunk
+	}
+	return h[i].line.changeTime.Before(h[j].line.changeTime)
+}
+
+func (h chunkHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *chunkHeap) Push(x interface{}) { *h = append(*h, x.(chunkHead)) }
+
+func (h *chunkHeap) Pop() interface{} {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
@@ -0,0 +1,137 @@
+package replay
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chunksReader is a readerFunc that returns the chunks as a dayFileChunks
+func chunksReader(chunks ...string) readerFunc {
+	return func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+		files := &dayFileChunks{}
+		for index, chunk := range chunks {
+			files.chunks = append(files.chunks, dayFileChunk{
+				path:       path + "." + string(rune('0'+index)) + ".jsonl",
+				ReadCloser: ioutil.NopCloser(strings.NewReader(chunk)),
+			})
+		}
+		return files, true, nil
+	}
+}
+
+func TestReadDayFileChunks(t *testing.T) {
+	tdata := []struct {
+		testCase       string
+		chunks         []string
+		expectedOutput []string // the changeTimes passed to onLine
+		expectedError  bool
+	}{
+		{
+			testCase: "one_chunk",
+			chunks: []string{`
+				{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+				{"changeTime": "2016-01-01T02:00:00", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 78.0}}
+			`},
+			expectedOutput: []string{"01:00", "02:00"},
+		},
+		{
+			testCase: "overlapping_chunks",
+			chunks: []string{`
+				{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+				{"changeTime": "2016-01-01T03:00:00", "after": {"ambientTemp": 80.0}, "before": {"ambientTemp": 79.0}}
+			`, `
+				{"changeTime": "2016-01-01T00:30:00", "after": {"schedule": true}, "before": {"schedule": false}}
+				{"changeTime": "2016-01-01T02:00:00", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 78.0}}
+			`, `
+				{"changeTime": "2016-01-01T04:00:00", "after": {"schedule": false}, "before": {"schedule": true}}
+			`},
+			expectedOutput: []string{"00:30", "01:00", "02:00", "03:00", "04:00"},
+		},
+		{
+			testCase: "duplicates_across_chunks",
+			chunks: []string{`
+				{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+				{"changeTime": "2016-01-01T02:00:00", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 78.0}}
+			`, `
+				{"before": {"ambientTemp": 78.0}, "after": {"ambientTemp": 79.0}, "changeTime": "2016-01-01T02:00:00.000000"}
+				{"changeTime": "2016-01-01T02:00:00", "after": {"schedule": true}, "before": {"schedule": false}}
+			`},
+			expectedOutput: []string{"01:00", "02:00", "02:00"},
+		},
+		{
+			testCase: "empty_chunk",
+			chunks: []string{"", `
+				{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+			`},
+			expectedOutput: []string{"01:00"},
+		},
+		{
+			testCase: "bad_line",
+			chunks: []string{`
+				{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+			`, `
+				{"changeTime": "yesterday", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 78.0}}
+			`},
+			expectedError: true,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			output := []string{}
+
+			// logic under test
+			_, err := readDayFile(context.Background(), "/2016/01/01", time.UTC, nil, chunksReader(test.chunks...), func(line dayFileLine) error {
+				output = append(output, line.changeTime.Format("15:04"))
+				return nil
+			})
+
+			// assertions
+			if test.expectedError != (err != nil) {
+				t.Fatalf("expected an error: %v, but the error was: %v", test.expectedError, err)
+			}
+			if err == nil && !reflect.DeepEqual(test.expectedOutput, output) {
+				t.Errorf("expected %v to equal %v", output, test.expectedOutput)
+			}
+		})
+	}
+}
+
+func TestClientChunks(t *testing.T) {
+	source := NewMemSource()
+	source.WriteFile("mem://audit-data/2016/01/01.0000.jsonl.gz", gzipBytes(t, `
+		{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+		{"changeTime": "2016-01-01T02:30:00", "after": {"ambientTemp": 80.0}, "before": {"ambientTemp": 79.0}}
+	`))
+	source.WriteFile("mem://audit-data/2016/01/01.0001.jsonl.gz", gzipBytes(t, `
+		{"changeTime": "2016-01-01T02:00:00", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 78.0}}
+		{"changeTime": "2016-01-01T02:30:00", "after": {"ambientTemp": 80.0}, "before": {"ambientTemp": 79.0}}
+	`))
+
+	client, err := NewClient("mem://audit-data", WithSource("mem", source), WithChunks(), WithMaxLookback(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// logic under test
+	state, err := client.StateAt(context.Background(), testTime("2016-01-01T02:15"), []string{"ambientTemp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeline, err := client.Range(context.Background(), testTime("2016-01-01T00:00"), testTime("2016-01-01T03:00"), []string{"ambientTemp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assertions
+	if state.Fields["ambientTemp"] != 79.0 {
+		t.Errorf("expected the ambientTemp (%v) to be 79.0", state.Fields["ambientTemp"])
+	}
+	if len(timeline.Changes) != 3 {
+		t.Errorf("expected 3 changes without the duplicate, but there were %d", len(timeline.Changes))
+	}
+}
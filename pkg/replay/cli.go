@@ -1,10 +1,15 @@
 package replay
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -31,10 +36,36 @@ DESCRIPTION:
 USAGE:
 	{{if .UsageText}}{{.UsageText}}{{else}}{{.HelpName}} {{if .VisibleFlags}}[global options]{{end}}{{if .Commands}} command [command options]{{end}} {{if .ArgsUsage}}{{.ArgsUsage}}{{else}}[arguments...]{{end}}{{end}}
 
+COMMANDS:{{range .VisibleCommands}}
+	{{join .Names ", "}}{{"\t"}}{{.Usage}}{{end}}
+
 OPTIONS:
 	{{range $index, $option := .VisibleFlags}}{{if $index}}
 	{{end}}{{$option}}{{end}}
 
+`
+	cli.CommandHelpTemplate = `
+DESCRIPTION:
+	{{.HelpName}} - {{.Usage}}
+
+USAGE:
+	{{if .UsageText}}{{.UsageText}}{{else}}{{.HelpName}}{{if .VisibleFlags}} [command options]{{end}} {{if .ArgsUsage}}{{.ArgsUsage}}{{else}}[arguments...]{{end}}{{end}}
+
+OPTIONS:
+	{{range $index, $option := .VisibleFlags}}{{if $index}}
+	{{end}}{{$option}}{{end}}
+
+`
+	cli.SubcommandHelpTemplate = `
+DESCRIPTION:
+	{{.HelpName}} - {{.Usage}}
+
+USAGE:
+	{{if .UsageText}}{{.UsageText}}{{else}}{{.HelpName}} command [command options] {{if .ArgsUsage}}{{.ArgsUsage}}{{else}}[arguments...]{{end}}{{end}}
+
+COMMANDS:{{range .VisibleCommands}}
+	{{join .Names ", "}}{{"\t"}}{{.Usage}}{{end}}
+
 `
 }
 
@@ -46,74 +77,718 @@ var App = cli.App{
 	Usage: "a CLI for reading the state of remote systems at a point in time",
 	UsageText: `./replay --field {fieldOne} ... {dataSource} {dateTime}
 	./replay --field ambientTemp --field schedule /tmp/ehub_data 2016-01-01T03:00
-	./replay --field ambientTemp --field schedule s3://net.energyhub.assets/public/dev-exercises/audit-data/ 2016-01-01T03:00`,
-	Flags: []cli.Flag{
-		&cli.StringSliceFlag{
-			Name:     "field",
-			Usage:    "a field to show the state of, can be input multiple times",
+	./replay --field ambientTemp --field schedule s3://net.energyhub.assets/public/dev-exercises/audit-data/ 2016-01-01T03:00
+	./replay --field ambientTemp /tmp/ehub_data now-2h # <= or 'yesterday 14:00', 2016-01-01T03:00+1h30m, or an epoch like 1451617200
+	./replay --field ambientTemp --with-provenance /tmp/ehub_data 2016-01-01T03:00 # <= and the change that each value came from
+	./replay command [command options] {dataSource}`,
+	Flags: withDataSourceFlags(
+		fieldFlag(false), // <= required, but only when there's no command
+		outputFlag(),
+		floatFormatFlag(),
+		onBadLineFlag(),
+		withProvenanceFlag(),
+	),
+	Commands: []*cli.Command{
+		&rangeCommand,
+		&sampleCommand,
+		&diffCommand,
+		&serveCommand,
+		&indexCommand,
+		&checkpointCommand,
+		&verifyCommand,
+		&cacheCommand,
+	},
+	Action: func(c *cli.Context) (err error) {
+		setupLogging(c)
+
+		// `--field` can't be marked as required without making it required
+		// for every command as well, so it is checked here instead
+		if len(c.StringSlice("field")) == 0 {
+			cli.ShowAppHelp(c)
+			err = errors.New("the `--field` flag is required")
+			return err
+		}
+
+		dateTime := timeArgs("the 2nd argument specifying a `dateTime` is required", "dateTime")
+		return dataSourceAction(c, dateTime, func(client *Client, times []time.Time) (interface{}, *SkippedLines, error) {
+			output, err := client.StateAt(c.Context, times[0], c.StringSlice("field")) // <= field requires no extra validation / conversion
+			if err != nil {
+				err = fmt.Errorf("error getting state: %w", err)
+				return nil, nil, err
+			}
+			return output, output.Skipped, nil
+		})
+	},
+}
+
+var rangeCommand = cli.Command{
+	Name:  "range",
+	Usage: "show every change to the fields between two times, with the state after each change",
+	UsageText: `./replay range --field {fieldOne} ... --from {dateTime} --to {dateTime} {dataSource}
+	./replay range --field ambientTemp --from 2016-01-01T02:00 --to 2016-01-01T04:00 /tmp/ehub_data`,
+	Flags: withDataSourceFlags(
+		fieldFlag(true),
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "the `dateTime` to start the timeline at",
 			Required: true,
 		},
-		&cli.BoolFlag{
-			Name:  "debug",
-			Usage: "show debug logs on stderr",
+		&cli.StringFlag{
+			Name:     "to",
+			Usage:    "the `dateTime` to end the timeline at",
+			Required: true,
 		},
+		outputFlag(),
+		floatFormatFlag(),
+		onBadLineFlag(),
+	),
+	Action: func(c *cli.Context) (err error) {
+		setupLogging(c)
+		return dataSourceAction(c, timeFlags("from", "to"), func(client *Client, times []time.Time) (interface{}, *SkippedLines, error) {
+			output, err := client.Range(c.Context, times[0], times[1], c.StringSlice("field"))
+			if err != nil {
+				err = fmt.Errorf("error getting range: %w", err)
+				return nil, nil, err
+			}
+			return output, output.Skipped, nil
+		})
 	},
+}
+
+var sampleCommand = cli.Command{
+	Name:  "sample",
+	Usage: "show the state of the fields at a fixed interval between two times",
+	UsageText: `./replay sample --field {fieldOne} ... --every {duration} --from {dateTime} --to {dateTime} {dataSource}
+	./replay sample --field ambientTemp --field schedule --every 5m --from 2016-01-01T00:00 --to 2016-01-08T00:00 /tmp/ehub_data`,
+	Flags: withDataSourceFlags(
+		fieldFlag(true),
+		&cli.DurationFlag{
+			Name:     "every",
+			Usage:    "the interval between samples, like 30s, 5m or 1h",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "the `dateTime` of the first sample",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "to",
+			Usage:    "the `dateTime` to stop sampling at",
+			Required: true,
+		},
+		outputFlag(),
+		floatFormatFlag(),
+		onBadLineFlag(),
+	),
 	Action: func(c *cli.Context) (err error) {
-		// set log level to debug if `--debug` was passed in
-		if c.Bool("debug") == true {
-			logrus.SetLevel(logrus.DebugLevel)
-		}
+		setupLogging(c)
+		return dataSourceAction(c, timeFlags("from", "to"), func(client *Client, times []time.Time) (interface{}, *SkippedLines, error) {
+			output, err := client.Sample(c.Context, times[0], times[1], c.Duration("every"), c.StringSlice("field"))
+			if err != nil {
+				err = fmt.Errorf("error getting samples: %w", err)
+				return nil, nil, err
+			}
+			return output, output.Skipped, nil
+		})
+	},
+}
+
+var diffCommand = cli.Command{
+	Name:  "diff",
+	Usage: "show the fields that were added, removed or changed between two times",
+	UsageText: `./replay diff --field {fieldOne} ... {dataSource} {dateTime} {dateTime}
+	./replay diff --field ambientTemp --field schedule /tmp/ehub_data 2016-01-01T02:00 2016-01-01T03:00
+	./replay diff --field setpoint --output table /tmp/ehub_data 2016-01-01T02:00 2016-01-02T02:00`,
+	Flags: withDataSourceFlags(
+		fieldFlag(true),
+		outputFlag(),
+		floatFormatFlag(),
+		onBadLineFlag(),
+	),
+	Action: func(c *cli.Context) (err error) {
+		setupLogging(c)
+		dateTimes := timeArgs("the 2nd and 3rd arguments specifying the `dateTime`s to compare are required", "the 1st dateTime", "the 2nd dateTime")
+		return dataSourceAction(c, dateTimes, func(client *Client, times []time.Time) (interface{}, *SkippedLines, error) {
+			output, err := client.Diff(c.Context, times[0], times[1], c.StringSlice("field"))
+			if err != nil {
+				err = fmt.Errorf("error getting diff: %w", err)
+				return nil, nil, err
+			}
+			return output, output.Skipped, nil
+		})
+	},
+}
+
+var serveCommand = cli.Command{
+	Name:  "serve",
+	Usage: "serve an HTTP API for the state of the fields, with the same output as the other commands",
+	UsageText: `./replay serve --listen {address} {dataSource}
+	./replay serve --listen :8080 /tmp/ehub_data
+	curl 'localhost:8080/state?field=ambientTemp&field=schedule&at=2016-01-01T03:00'
+	curl 'localhost:8080/range?field=ambientTemp&from=2016-01-01T02:00&to=2016-01-01T04:00'
+	curl 'localhost:8080/sample?field=ambientTemp&every=5m&from=2016-01-01T00:00&to=2016-01-02T00:00'
+	curl 'localhost:8080/diff?field=ambientTemp&from=2016-01-01T02:00&to=2016-01-01T03:00'`,
+	Flags: withDataSourceFlags(
+		&cli.StringFlag{
+			Name:  "listen",
+			Usage: "the `address` to listen on",
+			Value: ":8080",
+		},
+		withProvenanceFlag(),
+		onBadLineFlag(),
+	),
+	Action: func(c *cli.Context) (err error) {
+		setupLogging(c)
 
 		// get dataScource arg
 		if c.Args().Len() < 1 {
-			cli.ShowAppHelp(c)
+			cli.ShowCommandHelp(c, c.Command.Name)
 			err = errors.New("the 1st argument specifying a `dataSource` is required")
 			return err
 		}
-		dataScource := c.Args().Get(0)
-
-		// turn dataScoure arg into a readerFunc
-		var readerFunc readerFunc
-		if strings.HasPrefix(dataScource, "s3://") {
-			readerFunc = s3Reader
-		} else {
-			readerFunc = localReader
+		client, err := newClient(c, c.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		location, err := LoadTimeZone(c.String("tz"))
+		if err != nil {
+			err = fmt.Errorf("error parsing --tz: %w", err)
+			return err
 		}
 
-		// get dateTime arg
-		if c.Args().Len() < 2 {
-			cli.ShowAppHelp(c)
-			err = errors.New("the 2nd argument specifying a `dateTime` is required")
+		server := &http.Server{
+			Addr:    c.String("listen"),
+			Handler: newServer(client, location),
+		}
+
+		// stop gracefully on ctrl+c, letting in flight requests finish
+		stopped := make(chan error, 1)
+		go func() {
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			<-signals
+			logrus.Info("shutting down")
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			stopped <- server.Shutdown(ctx)
+		}()
+
+		logrus.Infof("listening on %s", server.Addr)
+		err = server.ListenAndServe()
+		if err != http.ErrServerClosed {
+			err = fmt.Errorf("error serving: %w", err)
 			return err
 		}
-		dateTime := c.Args().Get(1)
-
-		// do business logic
-		output, err := getState(getStateInput{
-			fields:     c.StringSlice("field"), // <= arg requires no extra validation / conversion
-			dataSource: dataScource,
-			dateTime:   dateTime,
-			readerFunc: readerFunc,
+		return <-stopped
+	},
+}
+
+var indexCommand = cli.Command{
+	Name:  "index",
+	Usage: "write an index next to each day file between two times, so that the state at a point in time can be found without reading every line",
+	UsageText: `./replay index --from {dateTime} --to {dateTime} {dataSource}
+	./replay index --from 2016-01-01T00:00 --to 2016-01-31T00:00 /tmp/ehub_data`,
+	Flags: withDataSourceFlags(
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "the `dateTime` of the first day file to index",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "to",
+			Usage:    "the `dateTime` of the last day file to index",
+			Required: true,
+		},
+		outputFlag(),
+		floatFormatFlag(),
+	),
+	Action: func(c *cli.Context) (err error) {
+		setupLogging(c)
+		return dataSourceAction(c, timeFlags("from", "to"), func(client *Client, times []time.Time) (interface{}, *SkippedLines, error) {
+			output, err := client.Index(c.Context, times[0], times[1])
+			if err != nil {
+				err = fmt.Errorf("error indexing: %w", err)
+				return nil, nil, err
+			}
+			return output, nil, nil
+		})
+	},
+}
+
+var checkpointCommand = cli.Command{
+	Name:  "checkpoint",
+	Usage: "write a snapshot of the state of every field at the start of each partition between two times, so that queries don't have to look back further than that",
+	UsageText: `./replay checkpoint --from {dateTime} --to {dateTime} {dataSource}
+	./replay checkpoint --from 2016-01-01T00:00 --to 2016-01-31T00:00 /tmp/ehub_data`,
+	Flags: withDataSourceFlags(
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "the `dateTime` of the first partition to checkpoint",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "to",
+			Usage:    "the `dateTime` of the last partition to checkpoint",
+			Required: true,
+		},
+		outputFlag(),
+		floatFormatFlag(),
+	),
+	Action: func(c *cli.Context) (err error) {
+		setupLogging(c)
+		return dataSourceAction(c, timeFlags("from", "to"), func(client *Client, times []time.Time) (interface{}, *SkippedLines, error) {
+			output, err := client.Checkpoint(c.Context, times[0], times[1])
+			if err != nil {
+				err = fmt.Errorf("error writing checkpoints: %w", err)
+				return nil, nil, err
+			}
+			return output, nil, nil
+		})
+	},
+}
+
+var verifyCommand = cli.Command{
+	Name:  "verify",
+	Usage: "check that the change log between two times is consistent, reporting every break with its file and line, and exiting with an error when there are any",
+	UsageText: `./replay verify --from {dateTime} --to {dateTime} {dataSource}
+	./replay verify --from 2016-01-01T00:00 --to 2016-01-31T00:00 /tmp/ehub_data
+	./replay verify --from 2016-01-01T00:00 --to 2016-01-31T00:00 --output table /tmp/ehub_data`,
+	Flags: withDataSourceFlags(
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "the `dateTime` of the first partition to check",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "to",
+			Usage:    "the `dateTime` of the last partition to check",
+			Required: true,
+		},
+		outputFlag(),
+		floatFormatFlag(),
+	),
+	Action: func(c *cli.Context) (err error) {
+		setupLogging(c)
+		var output VerifyResult
+		err = dataSourceAction(c, timeFlags("from", "to"), func(client *Client, times []time.Time) (interface{}, *SkippedLines, error) {
+			result, err := client.Verify(c.Context, times[0], times[1])
+			if err != nil {
+				err = fmt.Errorf("error verifying the change log: %w", err)
+				return nil, nil, err
+			}
+			output = result
+			return output, nil, nil
 		})
 		if err != nil {
-			err = fmt.Errorf("error getting state: %w", err)
 			return err
 		}
-
-		// format output
-		jsonOutput, err := json.Marshal(output)
-		if err != nil {
-			err = fmt.Errorf("error with json.Marshal: %w", err)
+		if len(output.Breaks) > 0 {
+			err = fmt.Errorf("found %d breaks in the %d lines of the change log", len(output.Breaks), output.Lines)
 			return err
 		}
-		jsonString := string(jsonOutput)
+		return nil
+	},
+}
 
-		// TODO: display all numbers as floats with 1 decimal place
+var cacheCommand = cli.Command{
+	Name:  "cache",
+	Usage: "manage the local cache of files read from s3 or http(s)",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "stats",
+			Usage: "show the number of files in the cache and their total size",
+			Flags: []cli.Flag{
+				cacheDirFlag(),
+				outputFlag(),
+				floatFormatFlag(),
+				debugFlag(),
+			},
+			Action: func(c *cli.Context) (err error) {
+				setupLogging(c)
 
-		// show output on stdout
-		// this is the only thing allowed to write to stdout!
-		fmt.Println(jsonString)
+				// check the output format before doing any work
+				options, err := parseOutputFlags(c)
+				if err != nil {
+					return err
+				}
 
-		return nil
+				output, err := newDiskCache(c.String("cache-dir"), DefaultCacheMaxBytes).stats()
+				if err != nil {
+					err = fmt.Errorf("error getting cache stats: %w", err)
+					return err
+				}
+
+				return printOutput(options, output)
+			},
+		},
+		{
+			Name:  "clear",
+			Usage: "remove every file from the cache",
+			Flags: []cli.Flag{
+				cacheDirFlag(),
+				debugFlag(),
+			},
+			Action: func(c *cli.Context) (err error) {
+				setupLogging(c)
+
+				cache := newDiskCache(c.String("cache-dir"), DefaultCacheMaxBytes)
+				err = cache.clear()
+				if err != nil {
+					err = fmt.Errorf("error clearing cache: %w", err)
+					return err
+				}
+				logrus.Infof("cleared the cache at %s", cache.dir)
+
+				return nil
+			},
+		},
 	},
 }
+
+// The flags below are shared by several commands. They're constructed by
+// functions since a flag holds on to its value, and can't be shared between
+// commands itself.
+
+func fieldFlag(required bool) cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:     "field",
+		Usage:    "a field to show the state of, can be input multiple times. Nested fields use dots and array indexes, like setpoint.heatTemp or periods[0].start",
+		Required: required,
+	}
+}
+
+// withDataSourceFlags adds the flags for reading a dataSource, and the debug
+// flag, after the flags of a command
+func withDataSourceFlags(flags ...cli.Flag) []cli.Flag {
+	return append(flags,
+		maxLookbackFlag(),
+		&cli.StringSliceFlag{
+			Name:  "extension",
+			Usage: "an `extension` that day files can have, can be input multiple times (default: .jsonl.gz, .jsonl.zst, .jsonl.bz2, .jsonl)",
+		},
+		&cli.StringFlag{
+			Name:    "layout",
+			Usage:   "the `template` of the path of each file in the dataSource, with the placeholders {year}, {month}, {day}, {hour} and {device}, and glob patterns like part-*",
+			Value:   DefaultLayout,
+			EnvVars: []string{"REPLAY_LAYOUT"},
+		},
+		&cli.StringFlag{
+			Name:    "device",
+			Usage:   "the `device` to read, for a layout with a {device}",
+			EnvVars: []string{"REPLAY_DEVICE"},
+		},
+		&cli.StringFlag{
+			Name:    "tz",
+			Usage:   "the time `zone` of dateTimes without one, and of the output, as an IANA name like America/New_York",
+			Value:   "UTC",
+			EnvVars: []string{"REPLAY_TZ"},
+		},
+		&cli.StringFlag{
+			Name:    "partition-tz",
+			Usage:   "the time `zone` that the files of the dataSource are partitioned in, and of changeTimes without one",
+			Value:   "UTC",
+			EnvVars: []string{"REPLAY_PARTITION_TZ"},
+		},
+		&cli.BoolFlag{
+			Name:    "chunks",
+			Usage:   "read every chunk of each partition, like 2016/01/01.0000.jsonl.gz and 2016/01/01.0001.jsonl.gz, merged in changeTime order",
+			EnvVars: []string{"REPLAY_CHUNKS"},
+		},
+		noCacheFlag(),
+		cacheDirFlag(),
+		&cli.StringFlag{
+			Name:    "s3-region",
+			Usage:   "the aws `region` of the s3 bucket (default: us-east-1, or the region of the aws profile)",
+			EnvVars: []string{"REPLAY_S3_REGION"},
+		},
+		&cli.StringFlag{
+			Name:    "s3-endpoint",
+			Usage:   "the `url` of an s3 compatible store to use instead of aws, like http://localhost:9000 for MinIO",
+			EnvVars: []string{"REPLAY_S3_ENDPOINT"},
+		},
+		&cli.StringFlag{
+			Name:    "s3-profile",
+			Usage:   "the aws `profile` to read s3 credentials from",
+			EnvVars: []string{"REPLAY_S3_PROFILE"},
+		},
+		&cli.BoolFlag{
+			Name:    "s3-path-style",
+			Usage:   "put the bucket in the path of s3 urls instead of the hostname, which most s3 compatible stores need",
+			EnvVars: []string{"REPLAY_S3_PATH_STYLE"},
+		},
+		&cli.BoolFlag{
+			Name:    "no-sign-request",
+			Usage:   "read from a public s3 bucket without credentials",
+			EnvVars: []string{"REPLAY_NO_SIGN_REQUEST"},
+		},
+		&cli.StringFlag{
+			Name:    "http-bearer-token",
+			Usage:   "a `token` to send in the Authorization header of http(s) requests",
+			EnvVars: []string{"REPLAY_HTTP_BEARER_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "http-user",
+			Usage:   "the `username` for basic auth on http(s) requests",
+			EnvVars: []string{"REPLAY_HTTP_USER"},
+		},
+		&cli.StringFlag{
+			Name:    "http-password",
+			Usage:   "the `password` for basic auth on http(s) requests",
+			EnvVars: []string{"REPLAY_HTTP_PASSWORD"},
+		},
+		&cli.DurationFlag{
+			Name:    "http-timeout",
+			Usage:   "the max `duration` to download each file over http(s), 0 for no limit",
+			Value:   time.Minute,
+			EnvVars: []string{"REPLAY_HTTP_TIMEOUT"},
+		},
+		debugFlag(),
+	)
+}
+
+func outputFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "output",
+		Usage:   "the output `format`, one of " + strings.Join(outputFormats, ", "),
+		Value:   "json",
+		EnvVars: []string{"REPLAY_OUTPUT"},
+	}
+}
+
+func floatFormatFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "float-format",
+		Usage:   "how to show floats, the `format` shortest, or fixed:{decimals} like fixed:1",
+		Value:   "shortest",
+		EnvVars: []string{"REPLAY_FLOAT_FORMAT"},
+	}
+}
+
+func withProvenanceFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:  "with-provenance",
+		Usage: "show where the value of each field came from: its changeTime, file, line, side and age",
+	}
+}
+
+func onBadLineFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "on-bad-line",
+		Usage:   "what to do with a line of a day file that isn't an event, the `policy` " + strings.Join(badLinePolicies, ", "),
+		Value:   BadLineFail,
+		EnvVars: []string{"REPLAY_ON_BAD_LINE"},
+	}
+}
+
+func maxLookbackFlag() cli.Flag {
+	return &cli.IntFlag{
+		Name:  "max-lookback",
+		Usage: "the max number of `days` to look backwards and forwards for fields that didn't change on the day of the dateTime",
+		Value: DefaultMaxLookback,
+	}
+}
+
+func noCacheFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:  "no-cache",
+		Usage: "always download files from s3 or http(s), instead of reading them from the local cache",
+	}
+}
+
+func cacheDirFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:    "cache-dir",
+		Usage:   "the `dir` to cache files read from s3 or http(s) in",
+		Value:   defaultCacheDir(),
+		EnvVars: []string{"REPLAY_CACHE_DIR"},
+	}
+}
+
+func debugFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:  "debug",
+		Usage: "show debug logs on stderr",
+	}
+}
+
+// setupLogging sets the log level to debug if `--debug` was passed in
+func setupLogging(c *cli.Context) {
+	if c.Bool("debug") == true {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+}
+
+// newClient turns a dataSource arg into a Client, configured by the shared flags
+func newClient(c *cli.Context, dataScource string) (*Client, error) {
+	partitionLocation, err := LoadTimeZone(c.String("partition-tz"))
+	if err != nil {
+		err = fmt.Errorf("error parsing --partition-tz: %w", err)
+		return nil, err
+	}
+	options := []Option{
+		WithMaxLookback(c.Int("max-lookback")),
+		WithLayout(c.String("layout"), c.String("device")),
+		WithPartitionTimeZone(partitionLocation),
+		WithS3Config(S3Config{
+			Region:        c.String("s3-region"),
+			Endpoint:      c.String("s3-endpoint"),
+			Profile:       c.String("s3-profile"),
+			PathStyle:     c.Bool("s3-path-style"),
+			NoSignRequest: c.Bool("no-sign-request"),
+		}),
+		WithHTTPConfig(HTTPConfig{
+			BearerToken: c.String("http-bearer-token"),
+			Username:    c.String("http-user"),
+			Password:    c.String("http-password"),
+			Timeout:     c.Duration("http-timeout"),
+		}),
+	}
+	if len(c.StringSlice("extension")) > 0 {
+		options = append(options, WithExtensions(c.StringSlice("extension")...))
+	}
+	if c.Bool("chunks") {
+		options = append(options, WithChunks())
+	}
+	if c.Bool("with-provenance") {
+		options = append(options, WithProvenance())
+	}
+	if c.String("on-bad-line") != "" {
+		options = append(options, WithBadLinePolicy(c.String("on-bad-line")))
+	}
+	if !c.Bool("no-cache") {
+		options = append(options, WithCache(c.String("cache-dir"), DefaultCacheMaxBytes))
+	}
+	client, err := NewClient(dataScource, options...)
+	if err != nil {
+		err = fmt.Errorf("error setting up the dataSource: %w", err)
+		return nil, err
+	}
+	return client, nil
+}
+
+// dataSourceAction is the Action of a command that reads the dataSource in
+// its 1st arg at the times from parseTimes. The output format is checked
+// before doing any work, and the lines that run skipped are reported on
+// stderr before its output is printed.
+func dataSourceAction(c *cli.Context, parseTimes func(c *cli.Context) ([]time.Time, error), run func(client *Client, times []time.Time) (output interface{}, skipped *SkippedLines, err error)) error {
+	options, err := parseOutputFlags(c)
+	if err != nil {
+		return err
+	}
+
+	// get dataScource arg
+	if c.Args().Len() < 1 {
+		showHelp(c)
+		err = errors.New("the 1st argument specifying a `dataSource` is required")
+		return err
+	}
+	client, err := newClient(c, c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	times, err := parseTimes(c)
+	if err != nil {
+		return err
+	}
+
+	// do business logic
+	output, skipped, err := run(client, times)
+	if err != nil {
+		return err
+	}
+	warnSkippedLines(skipped)
+
+	return printOutput(options, output)
+}
+
+// timeFlags parses the dateTime flags with names, for dataSourceAction
+func timeFlags(names ...string) func(c *cli.Context) ([]time.Time, error) {
+	return func(c *cli.Context) ([]time.Time, error) {
+		output := []time.Time{}
+		for _, name := range names {
+			dateTime, err := parseTimeArg(c, name, c.String(name))
+			if err != nil {
+				return nil, err
+			}
+			output = append(output, dateTime)
+		}
+		return output, nil
+	}
+}
+
+// timeArgs parses the dateTime args after the dataSource arg, which are named
+// by names, for dataSourceAction. missing is the error when there aren't as
+// many as names.
+func timeArgs(missing string, names ...string) func(c *cli.Context) ([]time.Time, error) {
+	return func(c *cli.Context) ([]time.Time, error) {
+		if c.Args().Len() < len(names)+1 {
+			showHelp(c)
+			err := errors.New(missing)
+			return nil, err
+		}
+		output := []time.Time{}
+		for i, name := range names {
+			dateTime, err := parseTimeArg(c, name, c.Args().Get(i+1))
+			if err != nil {
+				return nil, err
+			}
+			output = append(output, dateTime)
+		}
+		return output, nil
+	}
+}
+
+// showHelp shows the help of the command that is running, or of the app for
+// its own Action
+func showHelp(c *cli.Context) {
+	if c.Command == nil || c.Command.Name == "" {
+		cli.ShowAppHelp(c)
+		return
+	}
+	cli.ShowCommandHelp(c, c.Command.Name)
+}
+
+// warnSkippedLines reports the lines that a query skipped on stderr, with
+// how many were skipped for each reason
+func warnSkippedLines(skipped *SkippedLines) {
+	if skipped != nil {
+		logrus.Warn(skipped.String())
+	}
+}
+
+// parseTimeArg parses a dateTime arg in the zone of `--tz`, naming the arg in
+// the error
+func parseTimeArg(c *cli.Context, name string, dateTime string) (time.Time, error) {
+	location, err := LoadTimeZone(c.String("tz"))
+	if err != nil {
+		err = fmt.Errorf("error parsing --tz: %w", err)
+		return time.Time{}, err
+	}
+	output, err := ParseTimeIn(dateTime, location)
+	if err != nil {
+		err = fmt.Errorf("error parsing %s: %w", name, err)
+		return time.Time{}, err
+	}
+	return output, nil
+}
+
+// parseOutputFlags checks `--output` and `--float-format`
+func parseOutputFlags(c *cli.Context) (outputOptions, error) {
+	options, err := parseOutputOptions(c.String("output"), c.String("float-format"))
+	if err != nil {
+		err = fmt.Errorf("error parsing the output flags: %w", err)
+		return outputOptions{}, err
+	}
+	return options, nil
+}
+
+// printOutput shows the output of a command on stdout, see writeOutput
+func printOutput(options outputOptions, output interface{}) error {
+	// show output on stdout
+	// this is the only thing allowed to write to stdout!
+	return writeOutput(os.Stdout, output, options)
+}
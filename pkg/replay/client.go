@@ -0,0 +1,412 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// DefaultMaxLookback is the default number of days that a Client will look
+// backwards and forwards for fields that didn't change on the requested day
+const DefaultMaxLookback = 7
+
+// Client reconstructs the state of remote systems from a data source. It is
+// the public API of this package, and is what the CLI is built on.
+//
+//	client, err := replay.NewClient("s3://net.energyhub.assets/public/dev-exercises/audit-data/")
+//	if err != nil {
+//		return err
+//	}
+//	defer client.Close()
+//	state, err := client.StateAt(ctx, at, []string{"ambientTemp", "setpoint.heatTemp"})
+//	if errors.Is(err, replay.ErrNotFound) {
+//		...
+//	}
+//
+// A Client is safe for concurrent use.
+type Client struct {
+	dataSource    string
+	readerFunc    readerFunc
+	maxLookback   int
+	extensions    []string
+	layout        layout
+	template      string // the layout template, parsed by NewClient
+	device        string
+	chunks        bool
+	location      *time.Location // the partition time zone
+	provenance    bool
+	badLinePolicy string
+
+	source       Source
+	sourceConfig SourceConfig
+	sources      map[string]Source // injected with WithSource, keyed by scheme
+}
+
+// S3Config is how to connect to s3, or to an s3 compatible store like MinIO.
+// Settings that are left empty fall back to the aws defaults, like the
+// AWS_REGION and AWS_PROFILE env vars and ~/.aws/config, and the region
+// falls back to us-east-1 after that.
+type S3Config struct {
+	Region        string
+	Endpoint      string // ex: `http://localhost:9000` for MinIO
+	Profile       string // a profile from ~/.aws/credentials
+	PathStyle     bool   // use `{endpoint}/{bucket}/{key}` urls instead of `{bucket}.{endpoint}/{key}`
+	NoSignRequest bool   // read public buckets without credentials
+}
+
+// Option configures a Client
+type Option func(client *Client)
+
+// WithMaxLookback sets the max number of days to look backwards and forwards
+// for fields that didn't change on the requested day
+func WithMaxLookback(days int) Option {
+	return func(client *Client) {
+		client.maxLookback = days
+	}
+}
+
+// WithExtensions sets the extensions that a day file can have, in the order
+// that they're looked for. The default is DefaultExtensions. The compression
+// of each file is detected from its contents, or else from its extension.
+func WithExtensions(extensions ...string) Option {
+	return func(client *Client) {
+		client.extensions = extensions
+	}
+}
+
+// WithLayout sets where the file for each partition of time is in the data
+// source, like `{year}/{month}/{day}/{hour}` for hourly files. The default is
+// DefaultLayout. The placeholders are `{year}`, `{month}`, `{day}`, `{hour}`
+// and `{device}`, and the files are read one partition at a time, so a layout
+// with an `{hour}` walks the data an hour at a time. The layout can have glob
+// patterns (see path.Match) like `dt={year}-{month}-{day}/part-*`, in which
+// case every matching file is read. device replaces `{device}`, and is
+// required when the layout has one.
+func WithLayout(template string, device string) Option {
+	return func(client *Client) {
+		client.template = template
+		client.device = device
+	}
+}
+
+// WithChunks reads partitions that are split into several chunks, like the
+// rotated logs `2016/01/01.0000.jsonl.gz` and `2016/01/01.0001.jsonl.gz`.
+// Every chunk of a partition is read, and their events are merged in
+// changeTime order, since chunks can overlap in time. An event that is in
+// more than one chunk is only read once.
+func WithChunks() Option {
+	return func(client *Client) {
+		client.chunks = true
+	}
+}
+
+// WithPartitionTimeZone sets the time zone that the data source is
+// partitioned in, so that `2016/01/01` has the changes from midnight to
+// midnight in location. Times are converted to location before their
+// partition is picked, and changeTimes without a timezone are in location.
+// The default is UTC.
+func WithPartitionTimeZone(location *time.Location) Option {
+	return func(client *Client) {
+		client.location = location
+	}
+}
+
+// WithProvenance adds where the value of each field came from to the output
+// of StateAt, see Provenance
+func WithProvenance() Option {
+	return func(client *Client) {
+		client.provenance = true
+	}
+}
+
+// WithBadLinePolicy sets what to do with a line of a day file that isn't an
+// event, like a line that isn't json or that has no changeTime. The policy is
+// BadLineFail, BadLineWarn or BadLineSkip, and the default is BadLineFail.
+// The lines that were skipped are in the output of each query, along with
+// why. Skipping reads every line of a day file, even one with an index, so
+// that every bad line is counted. Index and Checkpoint always fail on a bad line, since they'd write it
+// out as if it wasn't there.
+func WithBadLinePolicy(policy string) Option {
+	return func(client *Client) {
+		client.badLinePolicy = policy
+	}
+}
+
+// WithCache keeps a copy of every remote file that is read in dir, so that
+// it is only downloaded again once it changes. The least recently used files
+// are removed once dir grows past maxBytes. The same dir can be shared by
+// several processes at once.
+func WithCache(dir string, maxBytes int64) Option {
+	return func(client *Client) {
+		client.sourceConfig.CacheDir = dir
+		client.sourceConfig.CacheMaxBytes = maxBytes
+	}
+}
+
+// HTTPConfig is how to connect to an http(s) server. Only one of BearerToken
+// or Username and Password can be set.
+type HTTPConfig struct {
+	BearerToken string
+	Username    string // for basic auth
+	Password    string
+	Timeout     time.Duration // the max time for each file to download, no limit when 0
+}
+
+// WithHTTPConfig sets how to connect to an http(s) server, when the data
+// source is an http(s) url
+func WithHTTPConfig(config HTTPConfig) Option {
+	return func(client *Client) {
+		client.sourceConfig.HTTP = config
+	}
+}
+
+// WithS3Config sets how to connect to s3, when the data source is an s3 url
+func WithS3Config(config S3Config) Option {
+	return func(client *Client) {
+		client.sourceConfig.S3 = config
+	}
+}
+
+// WithSource reads data sources that start with `{scheme}://` from source,
+// instead of from the Source in the registry for that scheme (see
+// RegisterSource). Use the scheme `file` for data sources without a scheme.
+func WithSource(scheme string, source Source) Option {
+	return func(client *Client) {
+		client.sources[scheme] = source
+	}
+}
+
+// NewClient creates a Client for a data source, which is a local directory,
+// an s3 url like `s3://bucket/prefix`, an http(s) url like
+// `https://example.com/prefix`, or any other url with a scheme that has a
+// Source (see RegisterSource and WithSource). The data source can also be a
+// tar or zip archive in any of those places, like `/tmp/audit-data.tar.gz`.
+func NewClient(dataSource string, options ...Option) (*Client, error) {
+	if dataSource == "" {
+		err := withKind(ErrBadInput, errors.New("the dataSource was empty"))
+		return nil, err
+	}
+
+	client := &Client{
+		dataSource:  dataSource,
+		maxLookback: DefaultMaxLookback,
+		extensions:  DefaultExtensions,
+		sources:     map[string]Source{},
+	}
+	for _, option := range options {
+		option(client)
+	}
+
+	if client.maxLookback < 0 {
+		err := withKind(ErrBadInput, fmt.Errorf("the max lookback (%d) must not be negative", client.maxLookback))
+		return nil, err
+	}
+	err := checkBadLinePolicy(client.badLinePolicy)
+	if err != nil {
+		return nil, withKind(ErrBadInput, err)
+	}
+	if len(client.extensions) == 0 {
+		err := withKind(ErrBadInput, errors.New("at least one day file extension is required"))
+		return nil, err
+	}
+	layout, err := parseLayout(client.template, client.device)
+	if err != nil {
+		return nil, withKind(ErrBadInput, err)
+	}
+	layout.chunks = client.chunks
+	layout.location = client.location
+	client.layout = layout
+	config := client.sourceConfig
+	if (config.CacheDir != "" || config.CacheMaxBytes != 0) && (config.CacheDir == "" || config.CacheMaxBytes <= 0) {
+		err := withKind(ErrBadInput, errors.New("the cache needs a dir and a max size above 0"))
+		return nil, err
+	}
+
+	source, err := newSource(dataSource, config, client.sources)
+	if err != nil {
+		return nil, err
+	}
+	if isArchive(dataSource) {
+		source = newArchiveSource(source, strings.TrimSuffix(dataSource, "/"))
+	}
+	client.source = source
+	client.readerFunc = sourceReader(source, dataSource, client.extensions)
+
+	return client, nil
+}
+
+// Close releases what the Client keeps open between queries, like the
+// archive of a zip or tar data source. A Client that is used after Close
+// opens them again.
+func (c *Client) Close() error {
+	if closer, ok := c.source.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// checkpoints returns the checkpointFunc for a single query, nil when the
+// Client has no Source
+func (c *Client) checkpoints() checkpointFunc {
+	if c.source == nil {
+		return nil
+	}
+	return sourceCheckpoints(c.source, c.dataSource, c.layout)
+}
+
+// StateAt reconstructs the state of the fields at a point in time. Fields can
+// be paths into nested values, like `setpoint.heatTemp` or `periods[0].start`.
+func (c *Client) StateAt(ctx context.Context, at time.Time, fields []string) (State, error) {
+	return getState(ctx, getStateInput{
+		fields:         fields,
+		dataSource:     c.dataSource,
+		dateTime:       at,
+		readerFunc:     c.readerFunc,
+		checkpointFunc: c.checkpoints(),
+		maxLookback:    c.maxLookback,
+		layout:         c.layout,
+		provenance:     c.provenance,
+		badLinePolicy:  c.badLinePolicy,
+	})
+}
+
+// Range returns every change to the fields after from, up to and including
+// to, along with the state after each change
+func (c *Client) Range(ctx context.Context, from time.Time, to time.Time, fields []string) (Timeline, error) {
+	return getRange(ctx, getRangeInput{
+		fields:         fields,
+		dataSource:     c.dataSource,
+		from:           from,
+		to:             to,
+		readerFunc:     c.readerFunc,
+		checkpointFunc: c.checkpoints(),
+		maxLookback:    c.maxLookback,
+		layout:         c.layout,
+		badLinePolicy:  c.badLinePolicy,
+	})
+}
+
+// Sample reconstructs the state of the fields at every interval between from
+// and to (inclusive), reading each day file only once
+func (c *Client) Sample(ctx context.Context, from time.Time, to time.Time, every time.Duration, fields []string) (Samples, error) {
+	return getSample(ctx, getSampleInput{
+		fields:         fields,
+		dataSource:     c.dataSource,
+		from:           from,
+		to:             to,
+		every:          every,
+		readerFunc:     c.readerFunc,
+		checkpointFunc: c.checkpoints(),
+		maxLookback:    c.maxLookback,
+		layout:         c.layout,
+		badLinePolicy:  c.badLinePolicy,
+	})
+}
+
+// Diff compares the state of the fields at two points in time
+func (c *Client) Diff(ctx context.Context, from time.Time, to time.Time, fields []string) (Diff, error) {
+	return getDiff(ctx, getDiffInput{
+		fields:         fields,
+		dataSource:     c.dataSource,
+		from:           from,
+		to:             to,
+		readerFunc:     c.readerFunc,
+		checkpointFunc: c.checkpoints(),
+		maxLookback:    c.maxLookback,
+		layout:         c.layout,
+		badLinePolicy:  c.badLinePolicy,
+	})
+}
+
+// Index writes a sidecar index next to every day file between from and to
+// (inclusive), which StateAt uses to find the lines that it needs without
+// decoding the rest of the file. An index is ignored once its day file
+// changes, until it is indexed again. Indexes can only be written to data
+// sources whose Source is a WritableSource, like local directories.
+func (c *Client) Index(ctx context.Context, from time.Time, to time.Time) (IndexResult, error) {
+	return getIndex(ctx, getIndexInput{
+		dataSource: c.dataSource,
+		layout:     c.layout,
+		source:     c.source,
+		extensions: c.extensions,
+		from:       from,
+		to:         to,
+	})
+}
+
+// Checkpoint writes a checkpoint for every partition between from and to
+// (inclusive), with the state of every field at the start of the partition.
+// StateAt starts from the nearest checkpoint, so it only reads the changes
+// after it, no matter how long ago a field last changed. Checkpoints can only
+// be written to data sources whose Source is a WritableSource, like local
+// directories. A query ignores a checkpoint once a day file that was replayed
+// since the checkpoint before it changes, and Checkpoint writes the
+// checkpoints again from the oldest one that is stale, so it should be run
+// again after a backfill. A day file that is added to an older partition that
+// didn't have one isn't noticed, so the checkpoints after it should be written
+// again with a from before it.
+func (c *Client) Checkpoint(ctx context.Context, from time.Time, to time.Time) (CheckpointResult, error) {
+	return getCheckpoint(ctx, getCheckpointInput{
+		dataSource:  c.dataSource,
+		layout:      c.layout,
+		source:      c.source,
+		readerFunc:  c.readerFunc,
+		maxLookback: c.maxLookback,
+		from:        from,
+		to:          to,
+	})
+}
+
+// Verify checks the change log of every partition between from and to
+// (inclusive) for breaks: a before that isn't the after of the previous
+// change to the same field, even when that change is in an earlier partition,
+// changeTimes that go backwards or repeat, and lines that can't be read. Every
+// break is in the output with its file and line, and only errors that stop
+// the check, like a failure to talk to s3, are returned.
+func (c *Client) Verify(ctx context.Context, from time.Time, to time.Time) (VerifyResult, error) {
+	return getVerify(ctx, getVerifyInput{
+		dataSource: c.dataSource,
+		layout:     c.layout,
+		readerFunc: c.readerFunc,
+		from:       from,
+		to:         to,
+	})
+}
+
+// ParseTime parses a dateTime in any of the formats that the CLI accepts,
+// like `2016-01-01T03:00`, `2016-01-01T03:00:00.001180Z`, a unix epoch in
+// seconds, milliseconds, microseconds or nanoseconds, or a time relative to now like `now-2h` or
+// `yesterday 14:00`. dateTimes without a timezone are in UTC, and the output
+// is in UTC.
+func ParseTime(dateTime string) (time.Time, error) {
+	return ParseTimeIn(dateTime, time.UTC)
+}
+
+// ParseTimeIn is ParseTime for dateTimes without a timezone that are in
+// location. The dateTime can also end with the IANA name of its zone, like
+// `2016-01-01T03:00 America/New_York` or `2016-01-01T03:00[America/New_York]`.
+// Either way the output is in location, which is the zone that the output of
+// the Client is shown in.
+func ParseTimeIn(dateTime string, location *time.Location) (time.Time, error) {
+	if location == nil {
+		location = time.UTC
+	}
+	output, err := parseTimeExpression(dateTime, location)
+	if err != nil {
+		return time.Time{}, withKind(ErrBadInput, err)
+	}
+	return output.In(location), nil
+}
+
+// LoadTimeZone loads the zone with an IANA name like `America/New_York`, or
+// UTC for an empty name
+func LoadTimeZone(name string) (*time.Location, error) {
+	output, err := loadLocation(name)
+	if err != nil {
+		return nil, withKind(ErrBadInput, err)
+	}
+	return output, nil
+}
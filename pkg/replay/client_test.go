@@ -0,0 +1,331 @@
+package replay
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewClient(t *testing.T) {
+	tdata := []struct {
+		testCase          string
+		dataSource        string
+		options           []Option
+		expectedErrorKind error
+	}{
+		{
+			testCase:          "empty",
+			expectedErrorKind: ErrBadInput,
+		},
+		{
+			testCase:   "local",
+			dataSource: "/tmp/ehub_data",
+		},
+		{
+			testCase:   "s3",
+			dataSource: "s3://net.energyhub.assets/public/dev-exercises/audit-data/",
+		},
+		{
+			testCase:          "negative_max_lookback",
+			dataSource:        "/tmp/ehub_data",
+			options:           []Option{WithMaxLookback(-1)},
+			expectedErrorKind: ErrBadInput,
+		},
+		{
+			testCase:          "cache_without_dir",
+			dataSource:        "s3://net.energyhub.assets/public/dev-exercises/audit-data/",
+			options:           []Option{WithCache("", DefaultCacheMaxBytes)},
+			expectedErrorKind: ErrBadInput,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			client, err := NewClient(test.dataSource, test.options...)
+
+			// assertions
+			if test.expectedErrorKind == nil && err != nil {
+				t.Error(err)
+			}
+			if test.expectedErrorKind != nil && !errors.Is(err, test.expectedErrorKind) {
+				t.Errorf("expected the error (%v) to be a %v error", err, test.expectedErrorKind)
+			}
+			if test.expectedErrorKind == nil && client == nil {
+				t.Error("expected a client, but there was none!")
+			}
+		})
+	}
+}
+
+func TestClientStateAt(t *testing.T) {
+	// write a day file to a local directory, the same way that the real data is laid out
+	dataSource, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dataSource)
+	writeGzipFile(t, filepath.Join(dataSource, "2016", "01", "01.jsonl.gz"), `
+		{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}
+		{"changeTime": "2016-01-01T03:18:30.001950", "after": {"schedule": true}, "before": {"schedule": false}}
+	`)
+
+	client, err := NewClient(dataSource, WithMaxLookback(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tdata := []struct {
+		testCase          string
+		at                time.Time
+		fields            []string
+		expectedOutput    State
+		expectedErrorKind error
+	}{
+		{
+			testCase: "found",
+			at:       testTime("2016-01-01T03:00"),
+			fields:   []string{"ambientTemp", "schedule"},
+			expectedOutput: State{
+				Fields: map[string]interface{}{
+					"ambientTemp": 79.0,
+					"schedule":    false,
+				},
+				Ts: "2016-01-01T03:00:00",
+			},
+		},
+		{
+			testCase:          "missing_day_file",
+			at:                testTime("2017-01-01T03:00"),
+			fields:            []string{"ambientTemp"},
+			expectedErrorKind: ErrNotFound,
+		},
+		{
+			testCase:          "bad_field",
+			at:                testTime("2016-01-01T03:00"),
+			fields:            []string{"ambientTemp."},
+			expectedErrorKind: ErrBadInput,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			output, err := client.StateAt(context.Background(), test.at, test.fields)
+
+			// assertions
+			if !reflect.DeepEqual(test.expectedOutput, output) {
+				t.Errorf("expected %+v to equal %+v", test.expectedOutput, output)
+			}
+			if test.expectedErrorKind == nil && err != nil {
+				t.Error(err)
+			}
+			if test.expectedErrorKind != nil && !errors.Is(err, test.expectedErrorKind) {
+				t.Errorf("expected the error (%v) to be a %v error", err, test.expectedErrorKind)
+			}
+		})
+	}
+}
+
+func TestClientTimeZones(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewMemSource()
+	source.WriteFile("mem://utc/2016/01/01.jsonl", []byte(`{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 70.0}, "before": {"ambientTemp": 69.0}}`))
+	source.WriteFile("mem://utc/2016/01/02.jsonl", []byte(`{"changeTime": "2016-01-02T04:00:00", "after": {"ambientTemp": 72.0}, "before": {"ambientTemp": 71.0}}`))
+	source.WriteFile("mem://new-york/2016/01/01.jsonl", []byte(`{"changeTime": "2016-01-01T23:00:00", "after": {"ambientTemp": 80.0}, "before": {"ambientTemp": 79.0}}`))
+
+	tdata := []struct {
+		testCase       string
+		dataSource     string
+		location       *time.Location
+		at             string
+		tz             *time.Location // <= the zone of the output
+		expectedOutput State
+	}{
+		{
+			testCase:       "utc_offset",
+			dataSource:     "mem://utc",
+			at:             "2016-01-01T23:30-05:00", // <= the 2nd in UTC
+			expectedOutput: State{Fields: map[string]interface{}{"ambientTemp": 72.0}, Ts: "2016-01-02T04:30:00"},
+		},
+		{
+			testCase:       "utc_offset_in_tz",
+			dataSource:     "mem://utc",
+			at:             "2016-01-02T04:30Z",
+			tz:             newYork,
+			expectedOutput: State{Fields: map[string]interface{}{"ambientTemp": 72.0}, Ts: "2016-01-01T23:30:00-05:00"},
+		},
+		{
+			testCase:       "zone_name",
+			dataSource:     "mem://utc",
+			at:             "2016-01-01T18:00 America/New_York",
+			expectedOutput: State{Fields: map[string]interface{}{"ambientTemp": 70.0}, Ts: "2016-01-01T23:00:00"},
+		},
+		{
+			testCase:       "zone_name_in_tz",
+			dataSource:     "mem://utc",
+			at:             "2016-01-01T18:00 America/New_York",
+			tz:             newYork,
+			expectedOutput: State{Fields: map[string]interface{}{"ambientTemp": 70.0}, Ts: "2016-01-01T18:00:00-05:00"},
+		},
+		{
+			testCase:       "partition_time_zone",
+			dataSource:     "mem://new-york",
+			location:       newYork,
+			at:             "2016-01-02T04:30:00Z", // <= the 1st in New York
+			expectedOutput: State{Fields: map[string]interface{}{"ambientTemp": 80.0}, Ts: "2016-01-02T04:30:00"},
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			client, err := NewClient(test.dataSource, WithSource("mem", source), WithPartitionTimeZone(test.location), WithMaxLookback(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			at, err := ParseTimeIn(test.at, test.tz)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// logic under test
+			output, err := client.StateAt(context.Background(), at, []string{"ambientTemp"})
+
+			// assertions
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(test.expectedOutput, output) {
+				t.Errorf("expected %+v to equal %+v", output, test.expectedOutput)
+			}
+		})
+	}
+}
+
+func TestClientProvenance(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewMemSource()
+	source.WriteFile("mem://audit-data/2016/01/01.jsonl", []byte(`
+{"changeTime": "2016-01-01T00:30:00", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}
+{"changeTime": "2016-01-01T03:18:30", "after": {"schedule": true}, "before": {"schedule": false}}
+`))
+	source.WriteFile("mem://checkpointed/2016/01/01.jsonl", []byte(`{"changeTime": "2016-01-01T00:30:00", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`))
+	checkpointed, err := NewClient("mem://checkpointed", WithSource("mem", source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = checkpointed.Checkpoint(context.Background(), testTime("2016-01-01T00:00"), testTime("2016-01-20T00:00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tdata := []struct {
+		testCase           string
+		dataSource         string
+		at                 string
+		tz                 *time.Location
+		fields             []string
+		expectedProvenance map[string]Provenance
+	}{
+		{
+			testCase:   "day_file",
+			dataSource: "mem://audit-data",
+			at:         "2016-01-01T03:00",
+			fields:     []string{"ambientTemp", "schedule"},
+			expectedProvenance: map[string]Provenance{
+				"ambientTemp": {Value: 79.0, ChangeTime: "2016-01-01T00:30:00", Path: "mem://audit-data/2016/01/01.jsonl", Line: 2, Side: "nearestBefore", Age: "2h30m0s"},
+				"schedule":    {Value: false, ChangeTime: "2016-01-01T03:18:30", Path: "mem://audit-data/2016/01/01.jsonl", Line: 3, Side: "nearestAfter", Age: "-18m30s"},
+			},
+		},
+		{
+			testCase:   "in_the_input_time_zone",
+			dataSource: "mem://audit-data",
+			at:         "2015-12-31T22:00",
+			tz:         newYork,
+			fields:     []string{"ambientTemp"},
+			expectedProvenance: map[string]Provenance{
+				"ambientTemp": {Value: 79.0, ChangeTime: "2015-12-31T19:30:00-05:00", Path: "mem://audit-data/2016/01/01.jsonl", Line: 2, Side: "nearestBefore", Age: "2h30m0s"},
+			},
+		},
+		{
+			testCase:   "checkpoint",
+			dataSource: "mem://checkpointed",
+			at:         "2016-01-20T12:00",
+			fields:     []string{"ambientTemp"},
+			expectedProvenance: map[string]Provenance{
+				"ambientTemp": {Value: 79.0, ChangeTime: "2016-01-01T00:30:00", Path: "mem://checkpointed/_checkpoints/2016-01-20T00Z.json.gz", Side: "nearestBefore", Age: "467h30m0s"},
+			},
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			client, err := NewClient(test.dataSource, WithSource("mem", source), WithMaxLookback(0), WithProvenance())
+			if err != nil {
+				t.Fatal(err)
+			}
+			at, err := ParseTimeIn(test.at, test.tz)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// logic under test
+			output, err := client.StateAt(context.Background(), at, test.fields)
+
+			// assertions
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(test.expectedProvenance, output.Provenance) {
+				t.Errorf("expected %+v to equal %+v", output.Provenance, test.expectedProvenance)
+			}
+		})
+	}
+}
+
+func TestClientStateAtCanceled(t *testing.T) {
+	client, err := NewClient("/tmp/ehub_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// logic under test
+	_, err = client.StateAt(ctx, testTime("2016-01-01T03:00"), []string{"ambientTemp"})
+
+	// assertions
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the error (%v) to be context.Canceled", err)
+	}
+}
+
+// writeGzipFile writes a gzip compressed file, creating its directory if needed
+func writeGzipFile(t *testing.T, path string, fileData string) {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileObject, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileObject.Close()
+	gzWriter := gzip.NewWriter(fileObject)
+	_, err = gzWriter.Write([]byte(fileData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gzWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,110 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultExtensions are the extensions that a day file can have, in the order
+// that they're looked for
+var DefaultExtensions = []string{".jsonl.gz", ".jsonl.zst", ".jsonl.bz2", ".jsonl"}
+
+// compression is a format that day files can be compressed with
+type compression struct {
+	name      string
+	magic     []byte // the bytes that every file in this format starts with
+	extension string
+	newReader func(source io.Reader) (io.ReadCloser, error)
+}
+
+var compressions = []compression{
+	{
+		name:      "gzip",
+		magic:     []byte{0x1f, 0x8b},
+		extension: ".gz",
+		newReader: func(source io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(source)
+		},
+	},
+	{
+		name:      "zstd",
+		magic:     []byte{0x28, 0xb5, 0x2f, 0xfd},
+		extension: ".zst",
+		newReader: func(source io.Reader) (io.ReadCloser, error) {
+			// day files are read one at a time, so the decoder doesn't need a
+			// goroutine per cpu
+			decoder, err := zstd.NewReader(source, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		},
+	},
+	{
+		name:      "bzip2",
+		magic:     []byte("BZh"),
+		extension: ".bz2",
+		newReader: func(source io.Reader) (io.ReadCloser, error) {
+			return ioutil.NopCloser(bzip2.NewReader(source)), nil
+		},
+	},
+}
+
+// decompressReadCloser closes both the decompressed stream and the
+// underlying source stream
+type decompressReadCloser struct {
+	io.ReadCloser
+	source io.Closer
+}
+
+func (d decompressReadCloser) Close() error {
+	decompressErr := d.ReadCloser.Close()
+	sourceErr := d.source.Close()
+	if decompressErr != nil {
+		return decompressErr
+	}
+	return sourceErr
+}
+
+// decompress detects how source is compressed from its magic bytes, falling
+// back to the extension of path, and returns a stream of its decompressed
+// contents. Files that don't look compressed are read as plain text.
+// source is closed if that fails.
+func decompress(source io.ReadCloser, path string) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(source)
+	magic, _ := buffered.Peek(4) // <= shorter for tiny files, which aren't compressed
+	format, found := compression{}, false
+	for _, candidate := range compressions {
+		if bytes.HasPrefix(magic, candidate.magic) {
+			format, found = candidate, true
+			break
+		}
+	}
+	if !found {
+		for _, candidate := range compressions {
+			if strings.HasSuffix(path, candidate.extension) {
+				format, found = candidate, true
+				break
+			}
+		}
+	}
+	if !found {
+		return decompressReadCloser{ReadCloser: ioutil.NopCloser(buffered), source: source}, nil
+	}
+
+	decompressed, err := format.newReader(buffered)
+	if err != nil {
+		source.Close()
+		err = fmt.Errorf("error setting up %s reader for file (%s): %w", format.name, path, err)
+		return nil, err
+	}
+	return decompressReadCloser{ReadCloser: decompressed, source: source}, nil
+}
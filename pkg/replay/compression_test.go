@@ -0,0 +1,130 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const compressionTestLine = `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}` + "\n"
+
+// bzip2TestLine is compressionTestLine compressed with bzip2, since there's
+// no bzip2 writer in the standard library
+var bzip2TestLine = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xbf, 0x0c, 0xd5, 0x96, 0x00, 0x00,
+	0x35, 0x5b, 0x80, 0x00, 0x10, 0x50, 0x07, 0x7b, 0xb0, 0x04, 0x00, 0x3b, 0xe3, 0xd4, 0x0a, 0x20,
+	0x00, 0x6a, 0x1a, 0xa7, 0x94, 0xc8, 0xc4, 0xd1, 0xea, 0x31, 0x00, 0xc9, 0xea, 0x0d, 0x4c, 0xa7,
+	0xa3, 0x53, 0xd4, 0x01, 0xa0, 0x0d, 0x31, 0x15, 0x1a, 0x08, 0x04, 0x6d, 0x2a, 0x0a, 0xd7, 0xa8,
+	0x22, 0x83, 0xe4, 0x89, 0x09, 0x9c, 0x75, 0xa6, 0xcc, 0x91, 0xba, 0x6c, 0xc0, 0x10, 0x11, 0x71,
+	0xb5, 0xfa, 0x0a, 0x1c, 0x1c, 0x1e, 0x1f, 0x51, 0x14, 0x74, 0x8a, 0x60, 0x8b, 0x7f, 0xbd, 0x88,
+	0x98, 0x66, 0x95, 0x26, 0xa1, 0x50, 0x2c, 0x01, 0x90, 0xa5, 0xe0, 0xea, 0xc1, 0x36, 0x0b, 0x59,
+	0x8f, 0xc5, 0xdc, 0x91, 0x4e, 0x14, 0x24, 0x2f, 0xc3, 0x35, 0x65, 0x80,
+}
+
+// zstdBytes compresses fileData with zstd
+func zstdBytes(t *testing.T, fileData string) []byte {
+	compressed := bytes.Buffer{}
+	encoder, err := zstd.NewWriter(&compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = encoder.Write([]byte(fileData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = encoder.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compressed.Bytes()
+}
+
+func TestSourceReaderCompression(t *testing.T) {
+	tdata := []struct {
+		testCase      string
+		fileName      string
+		fileData      []byte
+		expectedFound bool
+		expectedError bool
+	}{
+		{
+			testCase:      "gzip",
+			fileName:      "01.jsonl.gz",
+			fileData:      gzipBytes(t, compressionTestLine),
+			expectedFound: true,
+		},
+		{
+			testCase:      "zstd",
+			fileName:      "01.jsonl.zst",
+			fileData:      zstdBytes(t, compressionTestLine),
+			expectedFound: true,
+		},
+		{
+			testCase:      "bzip2",
+			fileName:      "01.jsonl.bz2",
+			fileData:      bzip2TestLine,
+			expectedFound: true,
+		},
+		{
+			testCase:      "plain",
+			fileName:      "01.jsonl",
+			fileData:      []byte(compressionTestLine),
+			expectedFound: true,
+		},
+		{
+			testCase:      "zstd_with_the_wrong_extension",
+			fileName:      "01.jsonl.gz",
+			fileData:      zstdBytes(t, compressionTestLine),
+			expectedFound: true,
+		},
+		{
+			testCase:      "plain_with_a_compressed_extension",
+			fileName:      "01.jsonl.gz",
+			fileData:      []byte(compressionTestLine),
+			expectedError: true,
+		},
+		{
+			testCase:      "unknown_extension",
+			fileName:      "01.json",
+			fileData:      []byte(compressionTestLine),
+			expectedFound: false,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			source := NewMemSource()
+			source.WriteFile("mem://audit-data/2016/01/"+test.fileName, test.fileData)
+
+			// logic under test
+			output, found, err := sourceReader(source, "mem://audit-data", DefaultExtensions)(context.Background(), "mem://audit-data/2016/01/01")
+
+			// assertions
+			if test.expectedError {
+				if err == nil {
+					t.Error("expected an error, but there was none!")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != test.expectedFound {
+				t.Fatalf("expected found to be %v", test.expectedFound)
+			}
+			if !found {
+				return
+			}
+			defer output.Close()
+			contents, err := ioutil.ReadAll(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(contents) != compressionTestLine {
+				t.Errorf("expected %q to equal %q", contents, compressionTestLine)
+			}
+		})
+	}
+}
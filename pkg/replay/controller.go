@@ -1,173 +1,495 @@
 package replay
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
+	"io"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 type getStateInput struct {
-	fields     []string
-	dataSource string
-	dateTime   string
-	readerFunc readerFunc
+	fields         []string
+	dataSource     string
+	layout         layout
+	dateTime       time.Time
+	readerFunc     readerFunc
+	checkpointFunc checkpointFunc
+	maxLookback    int    // the number of days to walk backwards / forwards looking for unresolved fields
+	provenance     bool   // whether to say where the value of each field came from
+	badLinePolicy  string // one of BadLineFail, BadLineWarn or BadLineSkip
 }
 
-type getStateOutput struct {
-	State map[string]interface{} `json:"state"`
-	Ts    string                 `json:"ts"`
+// State is the state of the requested fields at a point in time
+type State struct {
+	Fields     map[string]interface{} `json:"state"`                  // keyed by the requested field
+	Ts         string                 `json:"ts"`                     // the point in time, formatted as 2006-01-02T15:04:05
+	Provenance map[string]Provenance  `json:"provenance,omitempty"`   // keyed by the requested field, only with WithProvenance
+	Skipped    *SkippedLines          `json:"skippedLines,omitempty"` // only when bad lines were skipped, see WithBadLinePolicy
 }
 
+// Provenance is where the value of a field in a State came from
+type Provenance struct {
+	Value      interface{} `json:"value"`
+	ChangeTime string      `json:"changeTime"`     // the change that set the value, formatted like State.Ts
+	Path       string      `json:"path"`           // the day file of the change, or the checkpoint it was read from
+	Line       int         `json:"line,omitempty"` // the line of the change in the day file, starting from 1
+	Side       string      `json:"side"`           // nearestBefore or nearestAfter
+	Age        string      `json:"age"`            // the time from the change to the State, negative for a nearestAfter
+}
+
+// the sides that the value of a field can be resolved from
+const (
+	sideNearestBefore = "nearestBefore" // the *after* value of the last change at or before the input time
+	sideNearestAfter  = "nearestAfter"  // the *before* value of the first change after the input time
+)
+
 type fieldData struct {
-	value interface{}
-	time  time.Time
+	value      interface{}
+	time       time.Time
+	path       string // where the change is from
+	lineNumber int    // 0 when it isn't from a day file
+	complete   bool   // the value has every key of an object, since it was merged with a checkpoint
 }
 
 type fileLineJSON struct {
 	After      map[string]interface{} `json:"after"`
 	Before     map[string]interface{} `json:"before"`
-	ChangeTime string                 `json:"changeTime"`
+	ChangeTime changeTimeJSON         `json:"changeTime"`
 }
 
-func getState(input getStateInput) (output getStateOutput, err error) {
-	// parse dateTime input
-	inputDateTime, err := stringToTime(input.dateTime)
+// changeTimeJSON is the changeTime of a json line, which is a string in any
+// of the formats of stringToTime, or a number for a unix epoch
+type changeTimeJSON string
+
+// UnmarshalJSON keeps the text of a number, so that an epoch doesn't lose any
+// precision to a float
+func (c *changeTimeJSON) UnmarshalJSON(data []byte) error {
+	var text string
+	err := json.Unmarshal(data, &text)
+	if err == nil {
+		*c = changeTimeJSON(text)
+		return nil
+	}
+	var number json.Number
+	err = json.Unmarshal(data, &number)
 	if err != nil {
-		err = fmt.Errorf("error parsing dateTime: %w", err)
-		return getStateOutput{}, err
+		return fmt.Errorf("the changeTime (%s) must be a string or a number", data)
 	}
-	inputYear, inputMonth, inputDay := inputDateTime.Date()
+	*c = changeTimeJSON(number.String())
+	return nil
+}
 
-	// construct path for reader
-	// paths look like so => /tmp/ehub_data/2016/01/01.jsonl.gz
-	path := fmt.Sprintf(`%s/%d/%02d/%02d.jsonl.gz`, input.dataSource, inputYear, inputMonth, inputDay)
+func getState(ctx context.Context, input getStateInput) (output State, err error) {
+	// check dateTime input
+	if input.dateTime.IsZero() {
+		err = withKind(ErrBadInput, errors.New("the dateTime was empty"))
+		return State{}, err
+	}
 
-	// get reader data
-	fileData, found, err := input.readerFunc(path)
+	// parse the fields, these can be paths into nested values
+	fields, err := parseFieldPaths(input.fields)
 	if err != nil {
-		err = fmt.Errorf("error reading state data: %w", err)
-		return getStateOutput{}, err
+		err = withKind(ErrBadInput, fmt.Errorf("error parsing field: %w", err))
+		return State{}, err
 	}
-	if found == false {
-		err = fmt.Errorf("the file %s was not found", path)
-		return getStateOutput{}, err
+
+	var provenance map[string]Provenance
+	if input.provenance {
+		provenance = make(map[string]Provenance)
 	}
+	badLines := newBadLines(input.badLinePolicy)
+	output.Fields, err = stateAt(ctx, stateAtInput{
+		fields:         fields,
+		dataSource:     input.dataSource,
+		layout:         input.layout,
+		readerFunc:     input.readerFunc,
+		checkpointFunc: input.checkpointFunc,
+		maxLookback:    input.maxLookback,
+		inputDateTime:  input.dateTime,
+		provenance:     provenance,
+		badLines:       badLines,
+	})
+	if err != nil {
+		return State{}, err
+	}
+
+	if len(output.Fields) == 0 {
+		err = withKind(ErrBadInput, fmt.Errorf("no data found for fields %s", input.fields))
+		return State{}, err
+	}
+
+	// as far as I can tell, the output time is literally just the input time but
+	// displayed at a higher percision???
+	output.Ts = formatTime(input.dateTime, "2006-01-02T15:04:05")
+	output.Provenance = provenance
+	output.Skipped = badLines.summary()
 
+	return output, nil
+}
+
+type stateAtInput struct {
+	fields         []fieldPath
+	dataSource     string
+	layout         layout
+	readerFunc     readerFunc
+	checkpointFunc checkpointFunc // nil when there are no checkpoints
+	maxLookback    int
+	inputDateTime  time.Time
+	provenance     map[string]Provenance // filled in with where each field came from, when it isn't nil
+	badLines       *badLines
+}
+
+// stateAt reconstructs the state of the fields at the input time. Fields that
+// couldn't be found are left out of the output.
+func stateAt(ctx context.Context, input stateAtInput) (output map[string]interface{}, err error) {
 	// the key for this map is "field"
 	nearestBefore := make(map[string]fieldData)
 	nearestAfter := make(map[string]fieldData)
 
-	// unpack the json lines and find our output values
-	//
-	// time complexity => O(n), we only iterate through the input data once
-	// space complexity => O(n) but nearly O(k), we only need to store nearestBefore and nearestAfter
-	// 		in memory, but the way this is written causes all of the fileData to be read into memory
-	// 		at once. A potential future optimization would be to only load one line of the file into
-	// 		memory at a time, which would make this function O(k).
-	for lineNumber, lineString := range strings.Split(fileData, "\n") {
-		// skip empty lines
-		lineString = strings.TrimSpace(lineString)
-		if lineString == "" {
-			continue
+	scan := scanDayFileInput{
+		dataSource:    input.dataSource,
+		layout:        input.layout,
+		readerFunc:    input.readerFunc,
+		inputDateTime: input.inputDateTime,
+		badLines:      input.badLines,
+		nearestBefore: nearestBefore,
+		nearestAfter:  nearestAfter,
+	}
+
+	// start with the day file for the dateTime itself
+	path := input.layout.path(input.dataSource, input.layout.partitionStart(input.inputDateTime))
+	scan.fields = input.fields
+	scan.partition = input.layout.partitionStart(input.inputDateTime)
+	scanned := map[string]bool{path: true} // <= the hour that is repeated when the clocks go back is in the same file
+	found, err := scanDayFile(ctx, scan)
+	if err != nil {
+		return nil, err
+	}
+	filesFound := 0
+	if found {
+		filesFound++
+	}
+	checkpointed, err := applyCheckpoint(ctx, input.checkpointFunc, scan.partition, input.fields, input.inputDateTime, nearestBefore)
+	if err != nil {
+		return nil, err
+	}
+	if checkpointed {
+		filesFound++
+	}
+
+	// Fields that didn't change on the day of dateTime still have a known value,
+	// it's just stored in another day file. Walk backwards first, since the
+	// *after* value of an earlier change is the most direct source of truth,
+	// then walk forwards for anything that's still missing. Both walks are
+	// bounded by maxLookback so that a field that doesn't exist can't cause
+	// us to scan months of data. Layouts with partitions that aren't a day long
+	// walk the same amount of time, ex: 24 hourly partitions per day. A
+	// checkpoint has the state of every field at the start of its partition,
+	// so the walk backwards stops at the first one.
+	maxPartitions := input.layout.lookbackPartitions(input.maxLookback)
+	for _, direction := range []int{-1, 1} {
+		for partitions := 1; partitions <= maxPartitions; partitions++ {
+			if direction < 0 && checkpointed {
+				break
+			}
+			scan.fields = unresolvedFields(input.fields, nearestBefore, nearestAfter)
+			if len(scan.fields) == 0 {
+				break
+			}
+			scan.partition = input.layout.addPartitions(input.inputDateTime, direction*partitions)
+			partitionPath := input.layout.path(input.dataSource, scan.partition)
+			if !scanned[partitionPath] {
+				scanned[partitionPath] = true
+				found, err := scanDayFile(ctx, scan)
+				if err != nil {
+					return nil, err
+				}
+				if found {
+					filesFound++
+				}
+			}
+			if direction < 0 {
+				checkpointed, err = applyCheckpoint(ctx, input.checkpointFunc, scan.partition, input.fields, input.inputDateTime, nearestBefore)
+				if err != nil {
+					return nil, err
+				}
+				if checkpointed {
+					filesFound++
+				}
+			}
 		}
+	}
 
-		// get json data
-		var lineData fileLineJSON
-		err := json.Unmarshal([]byte(lineString), &lineData)
-		if err != nil {
-			err = fmt.Errorf("error reading json line number (%d) for file (%s): %w", lineNumber, path, err)
+	if filesFound == 0 {
+		err = withKind(ErrNotFound, fmt.Errorf("the day file %s was not found", path))
+		return nil, err
+	}
+
+	output = make(map[string]interface{})
+	output, err = updateOutputState(output, nearestBefore, nearestAfter)
+	if err != nil {
+		return nil, err
+	}
+	output, err = updateOutputState(output, nearestAfter, nearestBefore)
+	if err != nil {
+		return nil, err
+	}
+	if input.provenance != nil {
+		addProvenance(input.provenance, output, input.inputDateTime, nearestBefore, nearestAfter)
+	}
+
+	return output, nil
+}
+
+// addProvenance says where the value of each field in the output came from.
+// The nearest before wins when there are both, like in updateOutputState.
+// Objects can be merged from several changes, in which case it's the change
+// that was nearest to the input time.
+func addProvenance(provenance map[string]Provenance, output map[string]interface{}, inputDateTime time.Time, nearestBefore map[string]fieldData, nearestAfter map[string]fieldData) {
+	for field, value := range output {
+		source, side := nearestBefore[field], sideNearestBefore
+		if source.time.IsZero() {
+			source, side = nearestAfter[field], sideNearestAfter
 		}
+		provenance[field] = Provenance{
+			Value:      value,
+			ChangeTime: formatTime(source.time.In(inputDateTime.Location()), "2006-01-02T15:04:05"),
+			Path:       source.path,
+			Line:       source.lineNumber,
+			Side:       side,
+			Age:        inputDateTime.Sub(source.time).String(),
+		}
+	}
+}
 
-		// get changeTime from json data
-		changeTime, err := stringToTime(lineData.ChangeTime)
-		if err != nil {
-			err = fmt.Errorf("error parsing changeTime for json line number (%d) for file (%s): %w", lineNumber, path, err)
-			return getStateOutput{}, err
+// unresolvedFields returns the fields that don't have a value on either side
+// of the input time yet.
+//
+// Objects are built up from partial updates (see mergePatch), so a change
+// only has the keys that changed. The keys that didn't change on the days
+// we've read can only be filled in from other days, ex: a `setpoint` whose
+// `coolTemp` changed on the 1st and whose `heatTemp` changed on the 3rd needs
+// both days (see TestStateAtPartialObjects), so an object keeps the walk going
+// until the max lookback. The exception is an object that was merged with a
+// checkpoint on the before side, which has every key that was set before the
+// input time. The after side can't add anything to it, since a key that the
+// checkpoint doesn't have wasn't set yet.
+func unresolvedFields(fields []fieldPath, nearestBefore map[string]fieldData, nearestAfter map[string]fieldData) []fieldPath {
+	var unresolved []fieldPath
+	for _, field := range fields {
+		before, after := nearestBefore[field.raw], nearestAfter[field.raw]
+		_, beforeIsObject := before.value.(map[string]interface{})
+		_, afterIsObject := after.value.(map[string]interface{})
+		switch {
+		case before.time.IsZero() && after.time.IsZero():
+			unresolved = append(unresolved, field)
+		case before.complete:
+		case beforeIsObject || afterIsObject:
+			unresolved = append(unresolved, field)
+		}
+	}
+	return unresolved
+}
+
+type scanDayFileInput struct {
+	fields        []fieldPath
+	dataSource    string
+	layout        layout
+	partition     time.Time // the start of the partition to read
+	readerFunc    readerFunc
+	inputDateTime time.Time
+	badLines      *badLines
+	nearestBefore map[string]fieldData
+	nearestAfter  map[string]fieldData
+}
+
+// scanDayFile reads the day file for the given partition and updates the nearest
+// maps in place. A day file that doesn't exist is not an error, it is reported
+// back to the caller via found.
+func scanDayFile(ctx context.Context, input scanDayFileInput) (found bool, err error) {
+	path := input.layout.path(input.dataSource, input.partition)
+
+	return readStateLines(ctx, path, input.layout.timeZone(), input.badLines, input.readerFunc, input.fields, input.inputDateTime, func(line dayFileLine) error {
+		// a change at exactly our input time has already happened by then, so it
+		// counts towards the nearest before
+		atOrBefore := func(inputDateTime time.Time) bool {
+			return !line.changeTime.After(inputDateTime)
 		}
 
-		nearestBefore = setNearest(setNearestInput{
+		input.nearestBefore = setNearest(setNearestInput{
 			// shared fields
 			inputFields:   input.fields,
-			changeTime:    changeTime,
-			inputDateTime: inputDateTime,
+			changeTime:    line.changeTime,
+			inputDateTime: input.inputDateTime,
+			path:          line.path,
+			lineNumber:    line.lineNumber,
 			// changing fields
-			debugString:   "nearestBefore",
-			fieldData:     lineData.After,    // the nearest before uses the *after* attribute
-			firstCompare:  changeTime.Before, // the nearest before is *before* our input time
-			secondCompare: changeTime.After,  // if this is the new nearest before, it should be *after* the existing one
-			nearest:       nearestBefore,
+			debugString:   sideNearestBefore,
+			fieldData:     line.data.After,       // the nearest before uses the *after* attribute
+			firstCompare:  atOrBefore,            // the nearest before is *at or before* our input time
+			secondCompare: line.changeTime.After, // if this is the new nearest before, it should be *after* the existing one
+			nearest:       input.nearestBefore,
 		})
 
-		nearestAfter = setNearest(setNearestInput{
+		input.nearestAfter = setNearest(setNearestInput{
 			// shared fields
 			inputFields:   input.fields,
-			changeTime:    changeTime,
-			inputDateTime: inputDateTime,
+			changeTime:    line.changeTime,
+			inputDateTime: input.inputDateTime,
+			path:          line.path,
+			lineNumber:    line.lineNumber,
 			// changing fields
-			debugString:   "nearestAfter",
-			fieldData:     lineData.Before,   // the nearest after uses the *before* attribute
-			firstCompare:  changeTime.After,  // the nearest after is *after* our input time
-			secondCompare: changeTime.Before, // if this is the new nearest after, it should be *before* the existing one
-			nearest:       nearestAfter,
+			debugString:   sideNearestAfter,
+			fieldData:     line.data.Before,       // the nearest after uses the *before* attribute
+			firstCompare:  line.changeTime.After,  // the nearest after is *after* our input time
+			secondCompare: line.changeTime.Before, // if this is the new nearest after, it should be *before* the existing one
+			nearest:       input.nearestAfter,
 		})
+
+		return nil
+	})
+}
+
+// readDayFile streams the json lines of the file at path into onLine, one
+// line at a time. A file that doesn't exist is not an error, it is reported
+// back to the caller via found. A partition that is split into several
+// chunks (see dayFileChunks) is merged into a single stream of lines in
+// changeTime order first.
+//
+// time complexity => O(n log k), we only iterate through the input data once,
+// with a heap of the k chunks to merge them
+// space complexity => O(k), each chunk is streamed through one line at a time
+func readDayFile(ctx context.Context, path string, location *time.Location, badLines *badLines, readerFunc readerFunc, onLine func(line dayFileLine) error) (found bool, err error) {
+	fileData, found, err := openDayFile(ctx, path, readerFunc)
+	if err != nil || !found {
+		return found, err
 	}
+	defer fileData.Close()
 
-	output.State = make(map[string]interface{})
-	output.State, err = updateOutputState(output.State, nearestBefore, nearestAfter)
+	return true, scanLines(path, location, badLines, fileData, onLine)
+}
+
+// openDayFile opens the file at path with the readerFunc, logging when it
+// wasn't found
+func openDayFile(ctx context.Context, path string, readerFunc readerFunc) (fileData io.ReadCloser, found bool, err error) {
+	// stop early if the caller has given up
+	err = ctx.Err()
 	if err != nil {
-		return getStateOutput{}, err
+		return nil, false, err
 	}
-	output.State, err = updateOutputState(output.State, nearestAfter, nearestBefore)
+
+	// get reader data
+	fileData, found, err = readerFunc(ctx, path)
 	if err != nil {
-		return getStateOutput{}, err
+		err = fmt.Errorf("error reading state data: %w", err)
+		return nil, false, err
 	}
-
-	if len(output.State) == 0 {
-		err = fmt.Errorf("no data found for fields %s", input.fields)
-		return getStateOutput{}, err
+	if found == false {
+		logrus.Debugf("the day file %s was not found", path)
+		return nil, false, nil
 	}
+	return fileData, true, nil
+}
 
-	// as far as I can tell, the output time is literally just the input time but
-	// displayed at a higher percision???
-	output.Ts = inputDateTime.Format("2006-01-02T15:04:05")
+// scanLines calls onLine for every line of a file that was opened with a
+// readerFunc, merging the chunks of a dayFileChunks. changeTimes without a
+// timezone are in location.
+func scanLines(path string, location *time.Location, badLines *badLines, fileData io.Reader, onLine func(line dayFileLine) error) error {
+	scanners := newLineScanners(path, location, badLines, fileData)
+	if len(scanners) > 1 {
+		return mergeChunks(scanners, onLine)
+	}
+	for {
+		line, done, err := scanners[0].next()
+		if err != nil || done {
+			return err
+		}
+		err = onLine(line)
+		if err != nil {
+			return err
+		}
+	}
+}
 
-	return output, nil
+// newLineScanners returns a lineScanner for each of the files of a file that
+// was opened with a readerFunc, which is one per chunk of a dayFileChunks.
+// path is only used when the file doesn't know its own path.
+func newLineScanners(path string, location *time.Location, badLines *badLines, fileData io.Reader) []*lineScanner {
+	var scanners []*lineScanner
+	switch file := fileData.(type) {
+	case *namedDayFile:
+		scanners = []*lineScanner{newLineScanner(file.path, location, fileData)}
+	case *indexedDayFile:
+		scanners = []*lineScanner{newLineScanner(file.path, location, fileData)}
+	case *dayFileChunks:
+		for _, chunk := range file.chunks {
+			scanners = append(scanners, newLineScanner(chunk.path, location, chunk))
+		}
+	default:
+		scanners = []*lineScanner{newLineScanner(path, location, fileData)}
+	}
+	for _, scanner := range scanners {
+		badLines.watch(scanner)
+	}
+	return scanners
 }
 
 type setNearestInput struct {
 	debugString   string
 	fieldData     map[string]interface{}
-	inputFields   []string
+	inputFields   []fieldPath
 	firstCompare  func(time.Time) bool
 	secondCompare func(time.Time) bool
 	inputDateTime time.Time
 	changeTime    time.Time
+	path          string
+	lineNumber    int
+	complete      bool // the values are the full state of each field, like in a checkpoint
 	nearest       map[string]fieldData
 }
 
 func setNearest(input setNearestInput) map[string]fieldData {
-	for checkingField, value := range input.fieldData {
-		for _, inputField := range input.inputFields {
-			if checkingField == inputField {
-				// set values if the existing values are empty
-				if input.firstCompare(input.inputDateTime) && input.nearest[checkingField].time.IsZero() {
-					input.nearest[checkingField] = fieldData{
-						value: value,
-						time:  input.changeTime,
-					}
-					logrus.Debugf("%s %s (was empty) => %+v\n", input.debugString, checkingField, value)
-				}
-				// set values if the time comparison succeed
-				if input.firstCompare(input.inputDateTime) && input.secondCompare(input.nearest[checkingField].time) {
-					input.nearest[checkingField] = fieldData{
-						value: value,
-						time:  input.changeTime,
-					}
-					logrus.Debugf("%s %s (comparison succeed) => %+v\n", input.debugString, checkingField, value)
-				}
+	for _, inputField := range input.inputFields {
+		value, found := inputField.lookup(input.fieldData)
+		if !found || !input.firstCompare(input.inputDateTime) {
+			continue
+		}
+		checkingField := inputField.raw
+		existing := input.nearest[checkingField]
+		switch {
+		// set values if the existing values are empty
+		case existing.time.IsZero():
+			input.nearest[checkingField] = fieldData{
+				value:      value,
+				time:       input.changeTime,
+				path:       input.path,
+				lineNumber: input.lineNumber,
+				complete:   input.complete,
+			}
+			logrus.Debugf("%s %s (was empty) => %+v\n", input.debugString, checkingField, value)
+		// set values if the time comparison succeed, objects are partial
+		// updates so they're merged with what we already have
+		case input.secondCompare(existing.time):
+			input.nearest[checkingField] = fieldData{
+				value:      mergePatch(existing.value, value),
+				time:       input.changeTime,
+				path:       input.path,
+				lineNumber: input.lineNumber,
+				complete:   existing.complete || input.complete,
+			}
+			logrus.Debugf("%s %s (comparison succeed) => %+v\n", input.debugString, checkingField, value)
+		// this change is further away than the one we already have, but if
+		// both are objects it can still fill in keys that we haven't seen yet
+		default:
+			if _, ok := existing.value.(map[string]interface{}); ok {
+				existing.value = mergePatch(value, existing.value)
+				existing.complete = existing.complete || input.complete
+				input.nearest[checkingField] = existing
+				logrus.Debugf("%s %s (merged further change) => %+v\n", input.debugString, checkingField, value)
 			}
 		}
 	}
@@ -178,11 +500,17 @@ func updateOutputState(outputState map[string]interface{}, sourceNearest map[str
 	for field, fieldData := range sourceNearest {
 		if !fieldData.time.IsZero() {
 			otherFieldData := otherNearest[field]
-			if !otherFieldData.time.IsZero() && fieldData.value != otherFieldData.value {
-				err := fmt.Errorf("data error, mismatched values on \"before\" and \"after\" (%v, %v) data for the field %s", fieldData.value, otherFieldData.value, field)
+			if !otherFieldData.time.IsZero() && valuesConflict(fieldData.value, otherFieldData.value) {
+				err := withKind(ErrDataError, fmt.Errorf("data error, mismatched values on \"before\" and \"after\" (%v, %v) data for the field %s", fieldData.value, otherFieldData.value, field))
 				return outputState, err
 			}
-			outputState[field] = fieldData.value
+			// objects can be partial, so whatever is already in the output
+			// is kept and this side only fills in the keys that are missing
+			value := fieldData.value
+			if existing, ok := outputState[field]; ok {
+				value = mergePatch(value, existing)
+			}
+			outputState[field] = removeNulls(value)
 		}
 	}
 	return outputState, nil
@@ -1,9 +1,14 @@
 package replay
 
 import (
+	"context"
 	"errors"
+	"io"
+	"io/ioutil"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -14,33 +19,36 @@ func init() {
 
 func TestGetState(t *testing.T) {
 	tdata := []struct {
-		testCase        string
-		input           getStateInput
-		expectedOutput  getStateOutput
-		expectedAnError bool // TODO: check for specific types of errors
+		testCase          string
+		input             getStateInput
+		expectedOutput    State
+		expectedAnError   bool
+		expectedErrorKind error // one of ErrNotFound, ErrBadInput or ErrDataError
 	}{
 		{
-			testCase:        "empty",
-			expectedAnError: true,
+			testCase:          "empty",
+			expectedAnError:   true,
+			expectedErrorKind: ErrBadInput,
 		},
 		{
 			testCase: "reader_returned_not_found",
 			input: getStateInput{
-				dateTime: "2016-01-01T00:43",
+				dateTime: testTime("2016-01-01T00:43"),
 				fields:   []string{"ambientTemp"},
-				readerFunc: func(path string) (output string, found bool, err error) {
-					return "", false, nil
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return nil, false, nil
 				},
 			},
-			expectedAnError: true,
+			expectedAnError:   true,
+			expectedErrorKind: ErrNotFound,
 		},
 		{
 			testCase: "reader_raised_an_error",
 			input: getStateInput{
-				dateTime: "2016-01-01T00:43",
+				dateTime: testTime("2016-01-01T00:43"),
 				fields:   []string{"ambientTemp"},
-				readerFunc: func(path string) (output string, found bool, err error) {
-					return "", true, errors.New("some error here")
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return nil, true, errors.New("some error here")
 				},
 			},
 			expectedAnError: true,
@@ -48,116 +56,141 @@ func TestGetState(t *testing.T) {
 		{
 			testCase: "simple_case__one_line__after",
 			input: getStateInput{
-				dateTime: "2016-01-01T00:43",
+				dateTime: testTime("2016-01-01T00:43"),
 				fields:   []string{"ambientTemp"},
-				readerFunc: func(path string) (output string, found bool, err error) {
-					return `{"changeTime": "9999-01-01T00:43:00.001064", "before": {"ambientTemp": 80.0}}`, true, nil
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`{"changeTime": "9999-01-01T00:43:00.001064", "before": {"ambientTemp": 80.0}}`)), true, nil
 				},
 			},
-			expectedOutput: getStateOutput{
-				State: map[string]interface{}{
+			expectedOutput: State{
+				Fields: map[string]interface{}{
 					"ambientTemp": 80.0,
 				},
+				Ts: "2016-01-01T00:43:00",
 			},
 		},
 		{
 			testCase: "floats_dont_truncate",
 			input: getStateInput{
-				dateTime: "2016-01-01T00:43",
+				dateTime: testTime("2016-01-01T00:43"),
 				fields:   []string{"ambientTemp"},
-				readerFunc: func(path string) (output string, found bool, err error) {
-					return `{"changeTime": "9999-01-01T00:43:00.001064", "before": {"ambientTemp": 80.888}}`, true, nil
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`{"changeTime": "9999-01-01T00:43:00.001064", "before": {"ambientTemp": 80.888}}`)), true, nil
 				},
 			},
-			expectedOutput: getStateOutput{
-				State: map[string]interface{}{
+			expectedOutput: State{
+				Fields: map[string]interface{}{
 					"ambientTemp": 80.888,
 				},
+				Ts: "2016-01-01T00:43:00",
 			},
 		},
 		{
 			testCase: "bad_input_field",
 			input: getStateInput{
-				dateTime: "2016-01-01T00:43",
+				dateTime: testTime("2016-01-01T00:43"),
 				fields:   []string{"BAD INPUT FIELD"},
-				readerFunc: func(path string) (output string, found bool, err error) {
-					return `{"changeTime": "9999-01-01T00:43:00.001064", "before": {"ambientTemp": 80.0}}`, true, nil
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`{"changeTime": "9999-01-01T00:43:00.001064", "before": {"ambientTemp": 80.0}}`)), true, nil
 				},
 			},
-			expectedAnError: true,
+			expectedAnError:   true,
+			expectedErrorKind: ErrBadInput,
 		},
 		{
 			testCase: "simple_case__one_line__before",
 			input: getStateInput{
-				dateTime: "2016-01-01T00:43",
+				dateTime: testTime("2016-01-01T00:43"),
 				fields:   []string{"ambientTemp"},
-				readerFunc: func(path string) (output string, found bool, err error) {
-					return `{"changeTime": "1111-01-01T00:43:00.001064", "after": {"ambientTemp": 80.0}}`, true, nil
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`{"changeTime": "1111-01-01T00:43:00.001064", "after": {"ambientTemp": 80.0}}`)), true, nil
 				},
 			},
-			expectedOutput: getStateOutput{
-				State: map[string]interface{}{
+			expectedOutput: State{
+				Fields: map[string]interface{}{
 					"ambientTemp": 80.0,
 				},
+				Ts: "2016-01-01T00:43:00",
 			},
 		},
 		{
 			testCase: "simple_case__two_lines__both_after",
 			input: getStateInput{
-				dateTime: "2016-01-01T00:43",
+				dateTime: testTime("2016-01-01T00:43"),
 				fields:   []string{"ambientTemp"},
-				readerFunc: func(path string) (output string, found bool, err error) {
-					return `
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
 						{"changeTime": "1111-01-01T00:43:00.001064", "after": {"ambientTemp": 11.0}}
 						{"changeTime": "9999-01-01T00:43:00.001064", "after": {"ambientTemp": 99.0}}
-					`, true, nil
+					`)), true, nil
 				},
 			},
-			expectedOutput: getStateOutput{
-				State: map[string]interface{}{
+			expectedOutput: State{
+				Fields: map[string]interface{}{
 					"ambientTemp": 11.0,
 				},
+				Ts: "2016-01-01T00:43:00",
 			},
 		},
 		{
 			testCase: "simple_case__two_lines__both_before",
 			input: getStateInput{
-				dateTime: "2016-01-01T00:43",
+				dateTime: testTime("2016-01-01T00:43"),
 				fields:   []string{"ambientTemp"},
-				readerFunc: func(path string) (output string, found bool, err error) {
-					return `
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
 						{"changeTime": "1111-01-01T00:43:00.001064", "before": {"ambientTemp": 11.0}}
 						{"changeTime": "9999-01-01T00:43:00.001064", "before": {"ambientTemp": 99.0}}
-					`, true, nil
+					`)), true, nil
 				},
 			},
-			expectedOutput: getStateOutput{
-				State: map[string]interface{}{
+			expectedOutput: State{
+				Fields: map[string]interface{}{
 					"ambientTemp": 99.0,
 				},
+				Ts: "2016-01-01T00:43:00",
+			},
+		},
+		{
+			testCase: "lines_longer_than_a_default_buffer",
+			input: getStateInput{
+				dateTime: testTime("2016-01-01T00:43"),
+				fields:   []string{"ambientTemp"},
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
+						{"changeTime": "1111-01-01T00:43:00.001064", "after": {"ambientTemp": 11.0, "notes": "` + strings.Repeat("x", 1<<20) + `"}}
+					`)), true, nil
+				},
+			},
+			expectedOutput: State{
+				Fields: map[string]interface{}{
+					"ambientTemp": 11.0,
+				},
+				Ts: "2016-01-01T00:43:00",
 			},
 		},
 		{
 			testCase: "data_error__before_and_after_mismatched",
 			input: getStateInput{
-				dateTime: "2016-01-01T00:43",
+				dateTime: testTime("2016-01-01T00:43"),
 				fields:   []string{"ambientTemp"},
-				readerFunc: func(path string) (output string, found bool, err error) {
-					return `
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
 						{"changeTime": "1111-01-01T00:43:00.001064", "before": {"ambientTemp": 11.0}, "after": {"ambientTemp": 11.0}}
 						{"changeTime": "9999-01-01T00:43:00.001064", "before": {"ambientTemp": 99.0}, "after": {"ambientTemp": 99.0}}
-					`, true, nil
+					`)), true, nil
 				},
 			},
-			expectedAnError: true,
+			expectedAnError:   true,
+			expectedErrorKind: ErrDataError,
 		},
 		{
 			testCase: "case_from_example_prompt",
 			input: getStateInput{
-				dateTime: "2016-01-01T03:00",
+				dateTime: testTime("2016-01-01T03:00"),
 				fields:   []string{"ambientTemp", "schedule"},
-				readerFunc: func(path string) (output string, found bool, err error) {
-					return `
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
 						{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}
 						{"changeTime": "2016-01-01T00:43:00.001064", "after": {"ambientTemp": 80.0}, "before": {"ambientTemp": 79.0}}
 						{"changeTime": "2016-01-01T01:32:00.009816", "after": {"ambientTemp": 81.0}, "before": {"ambientTemp": 80.0}}
@@ -170,21 +203,302 @@ func TestGetState(t *testing.T) {
 						{"changeTime": "2016-01-01T03:12:30.008936", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 80.0}}
 						{"changeTime": "2016-01-01T03:18:30.001950", "after": {"schedule": true}, "before": {"schedule": false}}
 						{"changeTime": "2016-01-01T03:24:30.001180", "after": {"setpoint": {"heatTemp": 67.0}}, "before": {"setpoint": {"heatTemp": 69.0}}}
-					`, true, nil
+					`)), true, nil
 				},
 			},
-			expectedOutput: getStateOutput{
-				State: map[string]interface{}{
+			expectedOutput: State{
+				Fields: map[string]interface{}{
 					"ambientTemp": 77.0,
 					"schedule":    false,
 				},
+				Ts: "2016-01-01T03:00:00",
+			},
+		},
+		{
+			testCase: "nested_field_path",
+			input: getStateInput{
+				dateTime: testTime("2016-01-01T03:00"),
+				fields:   []string{"setpoint.heatTemp", "periods[0].start"},
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
+						{"changeTime": "2016-01-01T02:00:00.000000", "after": {"periods": [{"start": "06:00"}]}, "before": {"periods": [{"start": "07:00"}]}}
+						{"changeTime": "2016-01-01T03:24:30.001180", "after": {"setpoint": {"heatTemp": 67.0}}, "before": {"setpoint": {"heatTemp": 69.0}}}
+					`)), true, nil
+				},
+			},
+			expectedOutput: State{
+				Fields: map[string]interface{}{
+					"setpoint.heatTemp": 69.0,
+					"periods[0].start":  "06:00",
+				},
+				Ts: "2016-01-01T03:00:00",
+			},
+		},
+		{
+			testCase: "bad_field_path",
+			input: getStateInput{
+				dateTime: testTime("2016-01-01T03:00"),
+				fields:   []string{"setpoint..heatTemp"},
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
+						{"changeTime": "2016-01-01T03:24:30.001180", "after": {"setpoint": {"heatTemp": 67.0}}, "before": {"setpoint": {"heatTemp": 69.0}}}
+					`)), true, nil
+				},
+			},
+			expectedAnError:   true,
+			expectedErrorKind: ErrBadInput,
+		},
+		{
+			testCase: "object_field__rebuilt_from_partial_updates",
+			input: getStateInput{
+				dateTime: testTime("2016-01-01T03:00"),
+				fields:   []string{"setpoint"},
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
+						{"changeTime": "2016-01-01T01:00:00.000000", "after": {"setpoint": {"heatTemp": 67.0, "coolTemp": 75.0, "mode": "auto"}}}
+						{"changeTime": "2016-01-01T02:00:00.000000", "after": {"setpoint": {"heatTemp": 68.0, "mode": null}}, "before": {"setpoint": {"heatTemp": 67.0, "mode": "auto"}}}
+						{"changeTime": "2016-01-01T04:00:00.000000", "after": {"setpoint": {"fan": "on"}}, "before": {"setpoint": {"fan": "off"}}}
+					`)), true, nil
+				},
+			},
+			expectedOutput: State{
+				Fields: map[string]interface{}{
+					"setpoint": map[string]interface{}{
+						"heatTemp": 68.0,
+						"coolTemp": 75.0,
+						"fan":      "off",
+					},
+				},
+				Ts: "2016-01-01T03:00:00",
+			},
+		},
+		{
+			testCase: "object_field__out_of_order_lines",
+			input: getStateInput{
+				dateTime: testTime("2016-01-01T03:00"),
+				fields:   []string{"setpoint"},
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
+						{"changeTime": "2016-01-01T02:00:00.000000", "after": {"setpoint": {"heatTemp": 68.0}}}
+						{"changeTime": "2016-01-01T01:00:00.000000", "after": {"setpoint": {"heatTemp": 67.0, "coolTemp": 75.0}}}
+					`)), true, nil
+				},
+			},
+			expectedOutput: State{
+				Fields: map[string]interface{}{
+					"setpoint": map[string]interface{}{
+						"heatTemp": 68.0,
+						"coolTemp": 75.0,
+					},
+				},
+				Ts: "2016-01-01T03:00:00",
+			},
+		},
+		{
+			testCase: "object_field__mismatched_before_and_after",
+			input: getStateInput{
+				dateTime: testTime("2016-01-01T03:00"),
+				fields:   []string{"setpoint"},
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
+						{"changeTime": "2016-01-01T02:00:00.000000", "after": {"setpoint": {"heatTemp": 68.0}}}
+						{"changeTime": "2016-01-01T04:00:00.000000", "before": {"setpoint": {"heatTemp": 60.0}}}
+					`)), true, nil
+				},
+			},
+			expectedAnError:   true,
+			expectedErrorKind: ErrDataError,
+		},
+		{
+			testCase: "array_field__mismatched_before_and_after",
+			input: getStateInput{
+				dateTime: testTime("2016-01-01T03:00"),
+				fields:   []string{"periods"},
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
+						{"changeTime": "2016-01-01T02:00:00.000000", "after": {"periods": ["06:00"]}}
+						{"changeTime": "2016-01-01T04:00:00.000000", "before": {"periods": ["07:00"]}}
+					`)), true, nil
+				},
+			},
+			expectedAnError:   true,
+			expectedErrorKind: ErrDataError,
+		},
+		{
+			testCase: "array_field__matching_before_and_after",
+			input: getStateInput{
+				dateTime: testTime("2016-01-01T03:00"),
+				fields:   []string{"periods"},
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
+						{"changeTime": "2016-01-01T02:00:00.000000", "after": {"periods": ["06:00"]}}
+						{"changeTime": "2016-01-01T04:00:00.000000", "before": {"periods": ["06:00"]}}
+					`)), true, nil
+				},
+			},
+			expectedOutput: State{
+				Fields: map[string]interface{}{
+					"periods": []interface{}{"06:00"},
+				},
+				Ts: "2016-01-01T03:00:00",
+			},
+		},
+		{
+			testCase: "epoch_change_times",
+			input: getStateInput{
+				dateTime: testTime("2016-01-01T03:30"),
+				fields:   []string{"ambientTemp"},
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
+						{"changeTime": 1451613600, "after": {"ambientTemp": 77.0}, "before": {"ambientTemp": 76.0}}
+						{"changeTime": "1451617200000", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+						{"changeTime": 1451620800.5, "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 78.0}}
+					`)), true, nil
+				},
+			},
+			expectedOutput: State{
+				Fields: map[string]interface{}{
+					"ambientTemp": 78.0,
+				},
+				Ts: "2016-01-01T03:30:00",
+			},
+		},
+		{
+			testCase: "change_at_exactly_dateTime_has_already_happened",
+			input: getStateInput{
+				dateTime: testTime("2016-01-01T03:00"),
+				fields:   []string{"ambientTemp"},
+				readerFunc: func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+					return ioutil.NopCloser(strings.NewReader(`
+						{"changeTime": "2016-01-01T02:00:00.000000", "after": {"ambientTemp": 77.0}, "before": {"ambientTemp": 76.0}}
+						{"changeTime": "2016-01-01T03:00:00.000000", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+						{"changeTime": "2016-01-01T04:00:00.000000", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 78.0}}
+					`)), true, nil
+				},
+			},
+			expectedOutput: State{
+				Fields: map[string]interface{}{
+					"ambientTemp": 78.0,
+				},
+				Ts: "2016-01-01T03:00:00",
 			},
 		},
+		{
+			testCase: "lookback__field_unchanged_on_the_day",
+			input: getStateInput{
+				dateTime:    testTime("2016-01-03T03:00"),
+				fields:      []string{"ambientTemp", "schedule"},
+				maxLookback: 7,
+				readerFunc: filesReader(map[string]string{
+					"/2016/01/01.jsonl.gz": `
+						{"changeTime": "2016-01-01T01:00:00.000000", "after": {"schedule": true}, "before": {"schedule": false}}
+					`,
+					"/2016/01/03.jsonl.gz": `
+						{"changeTime": "2016-01-03T02:00:00.000000", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}
+					`,
+				}),
+			},
+			expectedOutput: State{
+				Fields: map[string]interface{}{
+					"ambientTemp": 79.0,
+					"schedule":    true,
+				},
+				Ts: "2016-01-03T03:00:00",
+			},
+		},
+		{
+			testCase: "lookforward__field_unchanged_until_a_later_day",
+			input: getStateInput{
+				dateTime:    testTime("2016-01-01T03:00"),
+				fields:      []string{"schedule"},
+				maxLookback: 7,
+				readerFunc: filesReader(map[string]string{
+					"/2016/01/01.jsonl.gz": `
+						{"changeTime": "2016-01-01T02:00:00.000000", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}
+					`,
+					"/2016/01/04.jsonl.gz": `
+						{"changeTime": "2016-01-04T01:00:00.000000", "after": {"schedule": true}, "before": {"schedule": false}}
+					`,
+				}),
+			},
+			expectedOutput: State{
+				Fields: map[string]interface{}{
+					"schedule": false,
+				},
+				Ts: "2016-01-01T03:00:00",
+			},
+		},
+		{
+			testCase: "lookback__day_of_dateTime_is_missing",
+			input: getStateInput{
+				dateTime:    testTime("2016-01-02T03:00"),
+				fields:      []string{"schedule"},
+				maxLookback: 1,
+				readerFunc: filesReader(map[string]string{
+					"/2016/01/01.jsonl.gz": `
+						{"changeTime": "2016-01-01T01:00:00.000000", "after": {"schedule": true}, "before": {"schedule": false}}
+					`,
+				}),
+			},
+			expectedOutput: State{
+				Fields: map[string]interface{}{
+					"schedule": true,
+				},
+				Ts: "2016-01-02T03:00:00",
+			},
+		},
+		{
+			testCase: "lookback__object_keys_from_other_days",
+			input: getStateInput{
+				dateTime:    testTime("2016-01-02T03:00"),
+				fields:      []string{"setpoint"},
+				maxLookback: 7,
+				readerFunc: filesReader(map[string]string{
+					"/2016/01/01.jsonl.gz": `
+						{"changeTime": "2016-01-01T01:00:00.000000", "after": {"setpoint": {"heatTemp": 67.0}}, "before": {"setpoint": {"heatTemp": 69.0}}}
+					`,
+					"/2016/01/02.jsonl.gz": `
+						{"changeTime": "2016-01-02T01:00:00.000000", "after": {"setpoint": {"coolTemp": 75.0}}, "before": {"setpoint": {"coolTemp": 76.0}}}
+					`,
+					"/2016/01/03.jsonl.gz": `
+						{"changeTime": "2016-01-03T01:00:00.000000", "after": {"setpoint": {"fan": "on"}}, "before": {"setpoint": {"fan": "off"}}}
+					`,
+				}),
+			},
+			expectedOutput: State{
+				Fields: map[string]interface{}{
+					"setpoint": map[string]interface{}{
+						"heatTemp": 67.0,
+						"coolTemp": 75.0,
+						"fan":      "off",
+					},
+				},
+				Ts: "2016-01-02T03:00:00",
+			},
+		},
+		{
+			testCase: "lookback__is_bounded_by_maxLookback",
+			input: getStateInput{
+				dateTime:    testTime("2016-01-04T03:00"),
+				fields:      []string{"schedule"},
+				maxLookback: 2,
+				readerFunc: filesReader(map[string]string{
+					"/2016/01/01.jsonl.gz": `
+						{"changeTime": "2016-01-01T01:00:00.000000", "after": {"schedule": true}, "before": {"schedule": false}}
+					`,
+					"/2016/01/04.jsonl.gz": `
+						{"changeTime": "2016-01-04T02:00:00.000000", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}
+					`,
+				}),
+			},
+			expectedAnError:   true,
+			expectedErrorKind: ErrBadInput,
+		},
 	}
 	for _, test := range tdata {
 		t.Run(test.testCase, func(t *testing.T) {
 			// logic under test
-			output, err := getState(test.input)
+			output, err := getState(context.Background(), test.input)
 
 			// assertions
 			if !reflect.DeepEqual(test.expectedOutput, output) {
@@ -196,6 +510,56 @@ func TestGetState(t *testing.T) {
 			if !test.expectedAnError && err != nil {
 				t.Error(err)
 			}
+			if test.expectedErrorKind != nil && !errors.Is(err, test.expectedErrorKind) {
+				t.Errorf("expected the error (%v) to be a %v error", err, test.expectedErrorKind)
+			}
 		})
 	}
 }
+
+// testTime parses a dateTime for a test table, an empty dateTime is the zero time
+func testTime(dateTime string) time.Time {
+	if dateTime == "" {
+		return time.Time{}
+	}
+	output, err := stringToTime(dateTime)
+	if err != nil {
+		panic(err)
+	}
+	return output
+}
+
+// filesReader is a readerFunc over an in memory set of files, keyed by path
+func filesReader(files map[string]string) readerFunc {
+	return func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+		for _, extension := range DefaultExtensions {
+			fileData, found := files[path+extension]
+			if found {
+				return ioutil.NopCloser(strings.NewReader(fileData)), true, nil
+			}
+		}
+		return nil, false, nil
+	}
+}
+
+func TestStateAtPartialObjects(t *testing.T) {
+	source := NewMemSource()
+	source.WriteFile("mem://audit-data/2016/01/01.jsonl", []byte(`{"changeTime": "2016-01-01T01:00:00", "after": {"setpoint": {"coolTemp": 76.0}}, "before": {"setpoint": {"coolTemp": 75.0}}}`))
+	source.WriteFile("mem://audit-data/2016/01/03.jsonl", []byte(`{"changeTime": "2016-01-03T01:00:00", "after": {"setpoint": {"heatTemp": 69.0}}, "before": {"setpoint": {"heatTemp": 68.0}}}`))
+	client, err := NewClient("mem://audit-data", WithSource("mem", source), WithMaxLookback(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// logic under test
+	output, err := client.StateAt(context.Background(), testTime("2016-01-03T12:00"), []string{"setpoint"})
+
+	// assertions
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOutput := map[string]interface{}{"setpoint": map[string]interface{}{"coolTemp": 76.0, "heatTemp": 69.0}} // <= the 3rd only has the heatTemp
+	if !reflect.DeepEqual(expectedOutput, output.Fields) {
+		t.Errorf("expected %v to equal %v", output.Fields, expectedOutput)
+	}
+}
@@ -0,0 +1,135 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// The kinds of differences between two states
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+type getDiffInput struct {
+	fields         []string
+	dataSource     string
+	layout         layout
+	from           time.Time
+	to             time.Time
+	readerFunc     readerFunc
+	checkpointFunc checkpointFunc
+	maxLookback    int
+	badLinePolicy  string
+}
+
+// Diff is the fields that were added, removed or changed between two points in time
+type Diff struct {
+	From        string        `json:"from"`
+	To          string        `json:"to"`
+	Differences []FieldDiff   `json:"differences"`
+	Skipped     *SkippedLines `json:"skippedLines,omitempty"` // only when bad lines were skipped, see WithBadLinePolicy
+}
+
+// FieldDiff is the difference in a single field between two points in time
+type FieldDiff struct {
+	Field  string      `json:"field"`
+	Change string      `json:"change"` // one of DiffAdded, DiffRemoved or DiffChanged
+	Old    interface{} `json:"old"`
+	New    interface{} `json:"new"`
+}
+
+// getDiff reconstructs the state of the fields at two times and reports the
+// fields that were added, removed or changed between them. Objects are
+// compared key by key, so a change inside of an object is reported with the
+// path to the key that changed.
+func getDiff(ctx context.Context, input getDiffInput) (output Diff, err error) {
+	// check from and to inputs, to is allowed to be before from here
+	fromTime, toTime := input.from, input.to
+	if fromTime.IsZero() || toTime.IsZero() {
+		err = withKind(ErrBadInput, errors.New("both dateTimes are required"))
+		return Diff{}, err
+	}
+
+	// parse the fields, these can be paths into nested values
+	fields, err := parseFieldPaths(input.fields)
+	if err != nil {
+		err = withKind(ErrBadInput, fmt.Errorf("error parsing field: %w", err))
+		return Diff{}, err
+	}
+
+	// get the state at both times, these can be on different days
+	states := make([]map[string]interface{}, 2)
+	badLines := newBadLines(input.badLinePolicy)
+	for index, inputDateTime := range []time.Time{fromTime, toTime} {
+		states[index], err = stateAt(ctx, stateAtInput{
+			fields:         fields,
+			dataSource:     input.dataSource,
+			layout:         input.layout,
+			readerFunc:     input.readerFunc,
+			checkpointFunc: input.checkpointFunc,
+			maxLookback:    input.maxLookback,
+			inputDateTime:  inputDateTime,
+			badLines:       badLines,
+		})
+		if err != nil {
+			return Diff{}, err
+		}
+	}
+
+	output.From = formatTime(fromTime, "2006-01-02T15:04:05")
+	output.To = formatTime(toTime, "2006-01-02T15:04:05")
+	output.Differences = []FieldDiff{}
+	for _, field := range fields {
+		oldValue, oldFound := states[0][field.raw]
+		newValue, newFound := states[1][field.raw]
+		output.Differences = diffValues(output.Differences, field.raw, oldValue, oldFound, newValue, newFound)
+	}
+	output.Skipped = badLines.summary()
+
+	return output, nil
+}
+
+// diffValues appends the differences between two values to output
+func diffValues(output []FieldDiff, path string, oldValue interface{}, oldFound bool, newValue interface{}, newFound bool) []FieldDiff {
+	switch {
+	case !oldFound && !newFound:
+		return output
+	case !oldFound:
+		return append(output, FieldDiff{Field: path, Change: DiffAdded, New: newValue})
+	case !newFound:
+		return append(output, FieldDiff{Field: path, Change: DiffRemoved, Old: oldValue})
+	}
+
+	oldObject, oldIsObject := oldValue.(map[string]interface{})
+	newObject, newIsObject := newValue.(map[string]interface{})
+	if !oldIsObject || !newIsObject {
+		if !reflect.DeepEqual(oldValue, newValue) {
+			output = append(output, FieldDiff{Field: path, Change: DiffChanged, Old: oldValue, New: newValue})
+		}
+		return output
+	}
+
+	// walk the keys of both objects in a stable order
+	keys := []string{}
+	for key := range oldObject {
+		keys = append(keys, key)
+	}
+	for key := range newObject {
+		if _, ok := oldObject[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		oldNested, oldFound := oldObject[key]
+		newNested, newFound := newObject[key]
+		output = diffValues(output, path+"."+key, oldNested, oldFound, newNested, newFound)
+	}
+	return output
+}
@@ -0,0 +1,101 @@
+package replay
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestGetDiff(t *testing.T) {
+	files := map[string]string{
+		"/2016/01/01.jsonl.gz": `
+			{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}
+			{"changeTime": "2016-01-01T02:30:00.000000", "after": {"setpoint": {"heatTemp": 67.0, "fan": "on"}}, "before": {"setpoint": {"heatTemp": 69.0}}}
+			{"changeTime": "2016-01-01T03:18:30.001950", "after": {"schedule": true}, "before": {"schedule": false}}
+		`,
+		"/2016/01/02.jsonl.gz": `
+			{"changeTime": "2016-01-02T01:00:00.000000", "after": {"ambientTemp": 70.0}, "before": {"ambientTemp": 79.0}}
+			{"changeTime": "2016-01-02T02:00:00.000000", "after": {"setpoint": {"heatTemp": 68.0, "fan": null}}, "before": {"setpoint": {"heatTemp": 67.0, "fan": "on"}}}
+		`,
+	}
+
+	tdata := []struct {
+		testCase        string
+		input           getDiffInput
+		expectedOutput  Diff
+		expectedAnError bool
+	}{
+		{
+			testCase:        "empty",
+			expectedAnError: true,
+		},
+		{
+			testCase: "same_day",
+			input: getDiffInput{
+				fields:      []string{"ambientTemp", "schedule"},
+				from:        testTime("2016-01-01T03:00"),
+				to:          testTime("2016-01-01T04:00"),
+				readerFunc:  filesReader(files),
+				maxLookback: 1,
+			},
+			expectedOutput: Diff{
+				From: "2016-01-01T03:00:00",
+				To:   "2016-01-01T04:00:00",
+				Differences: []FieldDiff{
+					{Field: "schedule", Change: DiffChanged, Old: false, New: true},
+				},
+			},
+		},
+		{
+			testCase: "different_days__objects_are_compared_by_key",
+			input: getDiffInput{
+				fields:      []string{"ambientTemp", "setpoint"},
+				from:        testTime("2016-01-01T02:00"),
+				to:          testTime("2016-01-02T03:00"),
+				readerFunc:  filesReader(files),
+				maxLookback: 1,
+			},
+			expectedOutput: Diff{
+				From: "2016-01-01T02:00:00",
+				To:   "2016-01-02T03:00:00",
+				Differences: []FieldDiff{
+					{Field: "ambientTemp", Change: DiffChanged, Old: 79.0, New: 70.0},
+					{Field: "setpoint.fan", Change: DiffRemoved, Old: "on"},
+					{Field: "setpoint.heatTemp", Change: DiffChanged, Old: 69.0, New: 68.0},
+				},
+			},
+		},
+		{
+			testCase: "nothing_changed",
+			input: getDiffInput{
+				fields:      []string{"ambientTemp"},
+				from:        testTime("2016-01-01T01:00"),
+				to:          testTime("2016-01-01T23:00"),
+				readerFunc:  filesReader(files),
+				maxLookback: 1,
+			},
+			expectedOutput: Diff{
+				From:        "2016-01-01T01:00:00",
+				To:          "2016-01-01T23:00:00",
+				Differences: []FieldDiff{},
+			},
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			output, err := getDiff(context.Background(), test.input)
+
+			// assertions
+			if !reflect.DeepEqual(test.expectedOutput, output) {
+				t.Errorf("expected %+v to equal %+v", test.expectedOutput, output)
+			}
+			if test.expectedAnError && err == nil {
+				t.Error("expected an error, but there was none!")
+			}
+			if !test.expectedAnError && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package replay
+
+import (
+	"errors"
+)
+
+// These are the kinds of errors that a Client can return. Use errors.Is to
+// check which kind of error you have, ex: `errors.Is(err, replay.ErrNotFound)`.
+// Errors that don't match any of these kinds are unexpected, like a failure
+// to talk to s3.
+var (
+	ErrNotFound  = errors.New("not found")  // the data for the requested time doesn't exist
+	ErrBadInput  = errors.New("bad input")  // the requested times or fields are invalid
+	ErrDataError = errors.New("data error") // the data exists, but it is inconsistent or unreadable
+)
+
+// kindError tags an error with its kind, without changing its message
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e kindError) Unwrap() error {
+	return e.err
+}
+
+func (e kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+// withKind tags err as being one of the kinds of errors above
+func withKind(kind error, err error) error {
+	return kindError{kind: kind, err: err}
+}
@@ -0,0 +1,372 @@
+package replay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// indexVersion is bumped whenever the format of dayFileIndex changes, so that
+// older indexes are ignored instead of misread
+const indexVersion = 2
+
+// indexExtension is added to the path of a day file for the path of its
+// sidecar index, ex: `2016/01/01.jsonl.gz.idx`
+const indexExtension = ".idx"
+
+// dayFileIndex is the sidecar index of a day file. For every top level field
+// it has the lines that changed the field, sorted by changeTime, so that the
+// nearest changes to a point in time can be found with a binary search. The
+// index is only used while the day file has the same size and mod time that
+// it had when it was indexed.
+//
+// The offsets are into the decompressed file, since compressed files can't
+// be read from the middle. The lines before an offset are still read, but
+// they aren't decoded, which is where most of the time goes.
+type dayFileIndex struct {
+	Version int                   `json:"version"`
+	Size    int64                 `json:"size"`
+	ModTime time.Time             `json:"modTime"`
+	Lines   int                   `json:"lines"`
+	Fields  map[string]fieldIndex `json:"fields"` // keyed by the top level field
+}
+
+// fieldIndex is the lines that have a top level field in their after and
+// before values
+type fieldIndex struct {
+	After   []indexEntry `json:"after"`
+	Before  []indexEntry `json:"before"`
+	Objects bool         `json:"objects"` // any of the values are objects, which are partial (see mergePatch)
+}
+
+// indexEntry is a line of a day file
+type indexEntry struct {
+	ChangeTime int64 `json:"t"` // in unix nanoseconds
+	Offset     int64 `json:"o"`
+	LineNumber int   `json:"l"`
+}
+
+// indexedDayFile is what a readerFunc returns for a day file that has a fresh
+// index, see readStateLines
+type indexedDayFile struct {
+	io.ReadCloser
+	path  string // the path of the day file, with its extension
+	index dayFileIndex
+}
+
+// indexPath returns the path of the sidecar index of a day file
+func indexPath(path string) string {
+	return path + indexExtension
+}
+
+// openIndex reads the sidecar index of the day file at path, found is false
+// when there is no index or it is stale
+func openIndex(ctx context.Context, source Source, path string) (output dayFileIndex, found bool, err error) {
+	// most day files don't have an index, which is cheaper to check for with
+	// a stat than by opening it
+	_, found, err = source.Stat(ctx, indexPath(path))
+	if err != nil || !found {
+		return dayFileIndex{}, false, err
+	}
+	found, err = readGzipJSON(ctx, source, indexPath(path), &output)
+	if err != nil || !found {
+		return dayFileIndex{}, false, err
+	}
+
+	info, found, err := source.Stat(ctx, path)
+	if err != nil || !found {
+		return dayFileIndex{}, false, err
+	}
+	if output.Version != indexVersion || output.Size != info.Size || !output.ModTime.Equal(info.ModTime) {
+		logrus.Debugf("the index %s is stale, so it was ignored", indexPath(path))
+		return dayFileIndex{}, false, nil
+	}
+	return output, true, nil
+}
+
+// buildIndex reads every line of the day file at path into an index,
+// changeTimes without a timezone are in location
+func buildIndex(ctx context.Context, source Source, path string, location *time.Location) (output dayFileIndex, err error) {
+	// the file is described before it's read, so that a change while reading
+	// it leaves the index stale instead of wrong
+	info, found, err := source.Stat(ctx, path)
+	if err == nil && !found {
+		err = withKind(ErrNotFound, fmt.Errorf("the day file %s was not found", path))
+	}
+	if err != nil {
+		return dayFileIndex{}, err
+	}
+	raw, found, err := source.Open(ctx, path)
+	if err == nil && !found {
+		err = withKind(ErrNotFound, fmt.Errorf("the day file %s was not found", path))
+	}
+	if err != nil {
+		return dayFileIndex{}, err
+	}
+	fileData, err := decompress(raw, path)
+	if err != nil {
+		return dayFileIndex{}, err
+	}
+	defer fileData.Close()
+
+	output = dayFileIndex{
+		Version: indexVersion,
+		Size:    info.Size,
+		ModTime: info.ModTime,
+		Fields:  map[string]fieldIndex{},
+	}
+	addEntries := func(fieldData map[string]interface{}, entry indexEntry, side func(field *fieldIndex) *[]indexEntry) {
+		for field, value := range fieldData {
+			index := output.Fields[field]
+			entries := side(&index)
+			*entries = append(*entries, entry)
+			if _, ok := value.(map[string]interface{}); ok {
+				index.Objects = true
+			}
+			output.Fields[field] = index
+		}
+	}
+
+	scanner := newLineScanner(path, location, fileData)
+	for {
+		err = ctx.Err()
+		if err != nil {
+			return dayFileIndex{}, err
+		}
+		line, done, err := scanner.next()
+		if err != nil {
+			return dayFileIndex{}, err
+		}
+		if done {
+			break
+		}
+		output.Lines++
+
+		entry := indexEntry{ChangeTime: line.changeTime.UnixNano(), Offset: line.offset, LineNumber: line.lineNumber}
+		addEntries(line.data.After, entry, func(field *fieldIndex) *[]indexEntry { return &field.After })
+		addEntries(line.data.Before, entry, func(field *fieldIndex) *[]indexEntry { return &field.Before })
+	}
+
+	// day files are written in order, but nothing guarantees it. Lines with
+	// the same changeTime stay in the order of the file.
+	for _, index := range output.Fields {
+		for _, entries := range [][]indexEntry{index.After, index.Before} {
+			sort.SliceStable(entries, func(i, j int) bool {
+				return entries[i].ChangeTime < entries[j].ChangeTime
+			})
+		}
+	}
+	return output, nil
+}
+
+// writeIndex writes the sidecar index of the day file at path
+func writeIndex(ctx context.Context, source WritableSource, path string, index dayFileIndex) error {
+	return writeGzipJSON(ctx, source, indexPath(path), index)
+}
+
+// readGzipJSON decodes the gzipped json file at path into output, found is
+// false when it doesn't exist
+func readGzipJSON(ctx context.Context, source Source, path string, output interface{}) (found bool, err error) {
+	raw, found, err := source.Open(ctx, path)
+	if err != nil || !found {
+		return false, err
+	}
+	defer raw.Close()
+
+	decompressed, err := gzip.NewReader(raw)
+	if err == nil {
+		err = json.NewDecoder(decompressed).Decode(output)
+	}
+	if err != nil {
+		err = fmt.Errorf("error reading file (%s): %w", path, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// writeGzipJSON writes value to path as gzipped json
+func writeGzipJSON(ctx context.Context, source WritableSource, path string, value interface{}) error {
+	compressed := bytes.Buffer{}
+	gzWriter := gzip.NewWriter(&compressed)
+	err := json.NewEncoder(gzWriter).Encode(value)
+	if err == nil {
+		err = gzWriter.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("error encoding file (%s): %w", path, err)
+		return err
+	}
+	return source.Write(ctx, path, compressed.Bytes())
+}
+
+// lines returns the lines that can change the state of the fields at
+// dateTime, in the order of the file. Those are the latest lines at or before
+// dateTime that have the field in their after, and the earliest lines after
+// dateTime that have the field in their before. Nested fields and objects
+// are built up from every line that has them (see mergePatch), so every one
+// of those lines is returned.
+func (i dayFileIndex) lines(fields []fieldPath, dateTime time.Time) []indexEntry {
+	at := dateTime.UnixNano()
+	selected := map[int64]indexEntry{}
+	for _, field := range fields {
+		index, ok := i.Fields[field.segments[0].key]
+		if !ok {
+			continue
+		}
+		if len(field.segments) > 1 || index.Objects {
+			for _, entry := range append(append([]indexEntry{}, index.After...), index.Before...) {
+				selected[entry.Offset] = entry
+			}
+			continue
+		}
+
+		// every line at the latest changeTime is kept, since the first one in
+		// the file wins a tie
+		after := sort.Search(len(index.After), func(j int) bool { return index.After[j].ChangeTime > at })
+		for j := after - 1; j >= 0 && index.After[j].ChangeTime == index.After[after-1].ChangeTime; j-- {
+			selected[index.After[j].Offset] = index.After[j]
+		}
+		before := sort.Search(len(index.Before), func(j int) bool { return index.Before[j].ChangeTime > at })
+		for j := before; j < len(index.Before) && index.Before[j].ChangeTime == index.Before[before].ChangeTime; j++ {
+			selected[index.Before[j].Offset] = index.Before[j]
+		}
+	}
+
+	output := make([]indexEntry, 0, len(selected))
+	for _, entry := range selected {
+		output = append(output, entry)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		return output[i].Offset < output[j].Offset
+	})
+	return output
+}
+
+// readStateLines is readDayFile for the state of the fields at dateTime. When
+// the day file has a fresh index, only the lines that can change the state of
+// the fields are decoded, otherwise every line is. Every line is decoded when
+// bad lines are skipped as well, since a bad line that isn't decoded wouldn't
+// be counted in the summary.
+func readStateLines(ctx context.Context, path string, location *time.Location, badLines *badLines, readerFunc readerFunc, fields []fieldPath, dateTime time.Time, onLine func(line dayFileLine) error) (found bool, err error) {
+	fileData, found, err := openDayFile(ctx, path, readerFunc)
+	if err != nil || !found {
+		return found, err
+	}
+	defer fileData.Close()
+
+	indexed, ok := fileData.(*indexedDayFile)
+	if !ok || badLines.skips() {
+		return true, scanLines(path, location, badLines, fileData, onLine)
+	}
+
+	entries := indexed.index.lines(fields, dateTime)
+	logrus.Debugf("read %d of the %d lines of %s from its index", len(entries), indexed.index.Lines, indexed.path)
+	scanner := newLineScanner(indexed.path, location, fileData)
+	badLines.watch(scanner)
+	for _, entry := range entries {
+		err = scanner.skipTo(entry.Offset, entry.LineNumber)
+		if err != nil {
+			return true, err
+		}
+		line, done, err := scanner.next()
+		if err == nil && done {
+			err = fmt.Errorf("the index of file (%s) has a line past the end of the file", indexed.path)
+		}
+		if err != nil {
+			return true, err
+		}
+		err = onLine(line)
+		if err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+type getIndexInput struct {
+	dataSource string
+	layout     layout
+	source     Source
+	extensions []string
+	from       time.Time
+	to         time.Time
+}
+
+// IndexResult is the sidecar indexes that were written by Client.Index
+type IndexResult struct {
+	From  string        `json:"from"`
+	To    string        `json:"to"`
+	Files []IndexedFile `json:"files"`
+}
+
+// IndexedFile is a day file that was indexed
+type IndexedFile struct {
+	Path   string `json:"path"`
+	Index  string `json:"index"`
+	Lines  int    `json:"lines"`
+	Fields int    `json:"fields"`
+}
+
+// getIndex writes a sidecar index next to every day file between from and to
+// (inclusive), replacing the indexes that are already there
+func getIndex(ctx context.Context, input getIndexInput) (output IndexResult, err error) {
+	err = checkTimeRange(input.from, input.to)
+	if err != nil {
+		return IndexResult{}, err
+	}
+	source, ok := input.source.(WritableSource)
+	if !ok {
+		err = withKind(ErrBadInput, fmt.Errorf("indexes can't be written to the dataSource (%s), only to local directories", input.dataSource))
+		return IndexResult{}, err
+	}
+
+	output = IndexResult{
+		From:  formatTime(input.from, "2006-01-02T15:04:05"),
+		To:    formatTime(input.to, "2006-01-02T15:04:05"),
+		Files: []IndexedFile{},
+	}
+	previous := ""
+	for partition := input.layout.partitionStart(input.from); !partition.After(input.to); partition = input.layout.addPartitions(partition, 1) {
+		partitionPath := input.layout.path(input.dataSource, partition)
+		if partitionPath == previous {
+			continue // <= the hour that is repeated when the clocks go back is in the same file
+		}
+		previous = partitionPath
+
+		paths, err := partitionFiles(ctx, source, input.dataSource, partitionPath, input.extensions)
+		if err != nil {
+			return IndexResult{}, err
+		}
+		for _, path := range paths {
+			index, err := buildIndex(ctx, source, path, input.layout.timeZone())
+			if err != nil {
+				err = fmt.Errorf("error indexing file (%s): %w", path, err)
+				return IndexResult{}, err
+			}
+			err = writeIndex(ctx, source, path, index)
+			if err != nil {
+				return IndexResult{}, err
+			}
+			logrus.Debugf("indexed %d lines of %s", index.Lines, path)
+			output.Files = append(output.Files, IndexedFile{
+				Path:   path,
+				Index:  indexPath(path),
+				Lines:  index.Lines,
+				Fields: len(index.Fields),
+			})
+		}
+	}
+
+	if len(output.Files) == 0 {
+		err = withKind(ErrNotFound, fmt.Errorf("no day files were found between %s and %s", output.From, output.To))
+		return IndexResult{}, err
+	}
+	return output, nil
+}
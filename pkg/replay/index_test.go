@@ -0,0 +1,202 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestIndexedStateAt(t *testing.T) {
+	dayFile := `
+		{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0, "setpoint": {"heatTemp": 68.0, "coolTemp": 76.0}}, "before": {"ambientTemp": 77.0, "setpoint": {"heatTemp": 67.0, "coolTemp": 75.0}}}
+		{"changeTime": "2016-01-01T02:00:00", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 78.0}}
+
+		{"changeTime": "2016-01-01T02:00:00", "after": {"schedule": true}, "before": {"schedule": false}}
+		{"changeTime": "2016-01-01T04:00:00", "after": {"setpoint": {"heatTemp": 69.0}}, "before": {"setpoint": {"heatTemp": 68.0}}}
+		{"changeTime": "2016-01-01T03:00:00", "after": {"ambientTemp": 80.0}, "before": {"ambientTemp": 79.0}}
+		{"changeTime": "2016-01-01T05:00:00", "after": {"ambientTemp": 81.0, "schedule": false}, "before": {"ambientTemp": 80.0, "schedule": true}}
+	`
+	fields := []string{"ambientTemp", "schedule", "setpoint", "setpoint.heatTemp"}
+
+	source := NewMemSource()
+	source.WriteFile("mem://indexed/2016/01/01.jsonl", []byte(dayFile))
+	source.WriteFile("mem://plain/2016/01/01.jsonl", []byte(dayFile))
+	indexed, err := NewClient("mem://indexed", WithSource("mem", source), WithMaxLookback(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := NewClient("mem://plain", WithSource("mem", source), WithMaxLookback(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := indexed.Index(context.Background(), testTime("2016-01-01T00:00"), testTime("2016-01-01T00:00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 1 || result.Files[0].Lines != 6 {
+		t.Fatalf("expected 1 file with 6 lines to be indexed, but the result was %+v", result)
+	}
+
+	for _, dateTime := range []string{"2016-01-01T00:30", "2016-01-01T01:00", "2016-01-01T02:00", "2016-01-01T02:30", "2016-01-01T03:00", "2016-01-01T04:30", "2016-01-01T06:00"} {
+		t.Run(dateTime, func(t *testing.T) {
+			// logic under test
+			output, err := indexed.StateAt(context.Background(), testTime(dateTime), fields)
+			expectedOutput, expectedErr := plain.StateAt(context.Background(), testTime(dateTime), fields)
+
+			// assertions
+			if (err != nil) != (expectedErr != nil) {
+				t.Fatalf("expected the error (%v) to match the error without an index (%v)", err, expectedErr)
+			}
+			if !reflect.DeepEqual(expectedOutput, output) {
+				t.Errorf("expected %v to equal %v", output, expectedOutput)
+			}
+		})
+	}
+}
+
+func TestIndexLines(t *testing.T) {
+	index := dayFileIndex{
+		Fields: map[string]fieldIndex{
+			"ambientTemp": {
+				After:  []indexEntry{{ChangeTime: 1, Offset: 0}, {ChangeTime: 2, Offset: 10}, {ChangeTime: 2, Offset: 20}, {ChangeTime: 3, Offset: 30}},
+				Before: []indexEntry{{ChangeTime: 1, Offset: 0}, {ChangeTime: 2, Offset: 10}, {ChangeTime: 2, Offset: 20}, {ChangeTime: 3, Offset: 30}},
+			},
+			"setpoint": {
+				After:   []indexEntry{{ChangeTime: 1, Offset: 0}, {ChangeTime: 4, Offset: 40}},
+				Before:  []indexEntry{{ChangeTime: 1, Offset: 0}, {ChangeTime: 4, Offset: 40}},
+				Objects: true,
+			},
+		},
+	}
+
+	tdata := []struct {
+		testCase        string
+		fields          []string
+		dateTime        int64
+		expectedOffsets []int64
+	}{
+		{testCase: "before_the_first_change", fields: []string{"ambientTemp"}, dateTime: 0, expectedOffsets: []int64{0}},
+		{testCase: "ties", fields: []string{"ambientTemp"}, dateTime: 2, expectedOffsets: []int64{10, 20, 30}},
+		{testCase: "after_the_last_change", fields: []string{"ambientTemp"}, dateTime: 5, expectedOffsets: []int64{30}},
+		{testCase: "objects", fields: []string{"setpoint"}, dateTime: 2, expectedOffsets: []int64{0, 40}},
+		{testCase: "nested", fields: []string{"ambientTemp", "setpoint.heatTemp"}, dateTime: 1, expectedOffsets: []int64{0, 10, 20, 40}},
+		{testCase: "unknown_field", fields: []string{"fan"}, dateTime: 2, expectedOffsets: []int64{}},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			fields, err := parseFieldPaths(test.fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// logic under test
+			output := index.lines(fields, time.Unix(0, test.dateTime))
+
+			// assertions
+			offsets := []int64{}
+			for _, entry := range output {
+				offsets = append(offsets, entry.Offset)
+			}
+			if !reflect.DeepEqual(test.expectedOffsets, offsets) {
+				t.Errorf("expected %v to equal %v", offsets, test.expectedOffsets)
+			}
+		})
+	}
+}
+
+func TestStaleIndex(t *testing.T) {
+	source := NewMemSource()
+	source.WriteFile("mem://audit-data/2016/01/01.jsonl", []byte(`{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}`))
+	client, err := NewClient("mem://audit-data", WithSource("mem", source), WithMaxLookback(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Index(context.Background(), testTime("2016-01-01T00:00"), testTime("2016-01-01T00:00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// logic under test
+	source.WriteFile("mem://audit-data/2016/01/01.jsonl", []byte(`
+		{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+		{"changeTime": "2016-01-01T02:00:00", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 78.0}}
+	`))
+	_, found, err := openIndex(context.Background(), source, "mem://audit-data/2016/01/01.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := client.StateAt(context.Background(), testTime("2016-01-01T03:00"), []string{"ambientTemp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// assertions
+	if found {
+		t.Errorf("expected the index to be stale")
+	}
+	if output.Fields["ambientTemp"] != 79.0 {
+		t.Errorf("expected the ambientTemp (%v) to be 79.0", output.Fields["ambientTemp"])
+	}
+}
+
+func TestIndexedBadLines(t *testing.T) {
+	path := "mem://audit-data/2016/01/01.jsonl"
+	source := NewMemSource()
+	source.WriteFile(path, []byte(`{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}`))
+	index, err := buildIndex(context.Background(), source, path, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.WriteFile(path, []byte(`{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+		not an event
+	`))
+	info, _, err := source.Stat(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Size, index.ModTime = info.Size, info.ModTime // <= an index that is still fresh, but doesn't have the bad line
+	err = writeGzipJSON(context.Background(), source, indexPath(path), index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient("mem://audit-data", WithSource("mem", source), WithMaxLookback(0), WithBadLinePolicy(BadLineSkip))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// logic under test
+	output, err := client.StateAt(context.Background(), testTime("2016-01-01T03:00"), []string{"ambientTemp"})
+
+	// assertions
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.Fields["ambientTemp"] != 78.0 {
+		t.Errorf("expected the ambientTemp (%v) to be 78.0", output.Fields["ambientTemp"])
+	}
+	if output.Skipped == nil || output.Skipped.Total != 1 {
+		t.Errorf("expected 1 skipped line, but the summary was %+v", output.Skipped)
+	}
+}
+
+func TestIndexErrors(t *testing.T) {
+	client, err := NewClient("mem://audit-data", WithSource("mem", &countingSource{MemSource: NewMemSource()}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Index(context.Background(), testTime("2016-01-01T00:00"), testTime("2016-01-02T00:00"))
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the error (%v) to be a %v error", err, ErrNotFound)
+	}
+
+	client, err = NewClient("mem://audit-data.tar", WithSource("mem", NewMemSource()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Index(context.Background(), testTime("2016-01-01T00:00"), testTime("2016-01-02T00:00"))
+	if !errors.Is(err, ErrBadInput) {
+		t.Errorf("expected the error (%v) to be a %v error", err, ErrBadInput)
+	}
+}
@@ -0,0 +1,193 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultLayout is the layout of the dev exercise data, one file per day like
+// `2016/01/01.jsonl.gz`
+const DefaultLayout = "{year}/{month}/{day}"
+
+// granularity is how much time each partition of a layout covers
+type granularity int
+
+const (
+	granularityYear granularity = iota
+	granularityMonth
+	granularityDay
+	granularityHour
+)
+
+// layoutPlaceholder matches the placeholders in a layout template
+var layoutPlaceholder = regexp.MustCompile(`\{[a-z]*\}`)
+
+// layout is a parsed layout template, which describes where the partition for
+// a point in time is in a data source. The zero value is DefaultLayout.
+//
+// The template is a path relative to the data source with placeholders for
+// `{year}`, `{month}`, `{day}`, `{hour}` and `{device}`, ex:
+// `{year}/{month}/{day}/{hour}` for hourly files. It can have glob patterns
+// as well (see path.Match), like `dt={year}-{month}-{day}/part-*` for hive
+// style partitions, in which case every matching file is read. The
+// extensions are added to the end of the template, unless it already ends
+// with an extension.
+//
+// When chunks is set, each partition is split into numbered chunks like
+// `01.0000.jsonl.gz` and `01.0001.jsonl.gz`, which is the same as adding `.*`
+// to the end of the template.
+//
+// The partitions are in the wall clock time of location, which is UTC when
+// it's nil, so a time is converted to location before its partition is
+// picked. Changes whose changeTime doesn't have a timezone are in location
+// as well.
+type layout struct {
+	template    string
+	device      string
+	granularity granularity
+	chunks      bool
+	location    *time.Location
+}
+
+// parseLayout checks a layout template and works out its granularity from the
+// smallest unit of time in it. device replaces `{device}`, and is required
+// when the template has it.
+func parseLayout(template string, device string) (layout, error) {
+	if template == "" {
+		template = DefaultLayout
+	}
+
+	found := map[string]bool{}
+	for _, placeholder := range layoutPlaceholder.FindAllString(template, -1) {
+		switch placeholder {
+		case "{year}", "{month}", "{day}", "{hour}", "{device}":
+			found[placeholder] = true
+		default:
+			err := fmt.Errorf("the layout (%s) has an unknown placeholder %s, the placeholders are {year}, {month}, {day}, {hour} and {device}", template, placeholder)
+			return layout{}, err
+		}
+	}
+
+	output := layout{template: template, device: device}
+	switch {
+	case !found["{year}"]:
+		err := fmt.Errorf("the layout (%s) needs at least a {year}", template)
+		return layout{}, err
+	case found["{hour}"] && found["{day}"] && found["{month}"]:
+		output.granularity = granularityHour
+	case found["{hour}"]:
+		err := fmt.Errorf("the layout (%s) needs a {month} and {day} to have an {hour}", template)
+		return layout{}, err
+	case found["{day}"] && found["{month}"]:
+		output.granularity = granularityDay
+	case found["{day}"]:
+		err := fmt.Errorf("the layout (%s) needs a {month} to have a {day}", template)
+		return layout{}, err
+	case found["{month}"]:
+		output.granularity = granularityMonth
+	default:
+		output.granularity = granularityYear
+	}
+
+	if found["{device}"] && device == "" {
+		return layout{}, errors.New("the layout has a {device}, so a device is required")
+	}
+	if !found["{device}"] && device != "" {
+		return layout{}, errors.New("a device can only be used with a layout that has a {device}")
+	}
+
+	return output, nil
+}
+
+// path returns the path of the partition that starts at partition, without
+// an extension unless the template has one
+func (l layout) path(dataSource string, partition time.Time) string {
+	template := l.template
+	if template == "" {
+		template = DefaultLayout
+	}
+	year, month, day := partition.Date()
+	output := strings.NewReplacer(
+		"{year}", fmt.Sprintf("%04d", year),
+		"{month}", fmt.Sprintf("%02d", month),
+		"{day}", fmt.Sprintf("%02d", day),
+		"{hour}", fmt.Sprintf("%02d", partition.Hour()),
+		"{device}", l.device,
+	).Replace(template)
+	if l.chunks {
+		output += ".*"
+	}
+	return strings.TrimSuffix(dataSource, "/") + "/" + strings.TrimPrefix(output, "/")
+}
+
+// partitionStart returns the start of the partition that t is in
+func (l layout) partitionStart(t time.Time) time.Time {
+	t = t.In(l.timeZone())
+	year, month, day := t.Date()
+	switch l.effectiveGranularity() {
+	case granularityYear:
+		return time.Date(year, 1, 1, 0, 0, 0, 0, t.Location())
+	case granularityMonth:
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case granularityHour:
+		// the hour is repeated when the clocks go back, so the start is worked
+		// out from t rather than from the wall clock
+		return t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// addPartitions returns the start of the partition that is count partitions
+// after the one that t is in, or before it when count is negative
+func (l layout) addPartitions(t time.Time, count int) time.Time {
+	start := l.partitionStart(t)
+	switch l.effectiveGranularity() {
+	case granularityYear:
+		return start.AddDate(count, 0, 0)
+	case granularityMonth:
+		return start.AddDate(0, count, 0)
+	case granularityHour:
+		// hours are added to the absolute time rather than the wall clock,
+		// since the wall clock skips or repeats an hour when the clocks change
+		return l.partitionStart(start.Add(time.Duration(count) * time.Hour))
+	default:
+		return start.AddDate(0, 0, count)
+	}
+}
+
+// lookbackPartitions converts a max lookback in days into the number of
+// partitions to walk in each direction, rounding up for partitions that are
+// longer than a day
+func (l layout) lookbackPartitions(maxLookback int) int {
+	switch l.effectiveGranularity() {
+	case granularityYear:
+		return (maxLookback + 364) / 365
+	case granularityMonth:
+		return (maxLookback + 27) / 28
+	case granularityHour:
+		return maxLookback * 24
+	default:
+		return maxLookback
+	}
+}
+
+// timeZone is the location of the layout, with the zero value being UTC
+func (l layout) timeZone() *time.Location {
+	if l.location == nil {
+		return time.UTC
+	}
+	return l.location
+}
+
+// effectiveGranularity is the granularity of the layout, with the zero value
+// being DefaultLayout
+func (l layout) effectiveGranularity() granularity {
+	if l.template == "" {
+		return granularityDay
+	}
+	return l.granularity
+}
@@ -0,0 +1,256 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseLayout(t *testing.T) {
+	tdata := []struct {
+		testCase            string
+		template            string
+		device              string
+		expectedGranularity granularity
+		expectedError       bool
+	}{
+		{testCase: "default", template: "", expectedGranularity: granularityDay},
+		{testCase: "daily", template: "{year}/{month}/{day}", expectedGranularity: granularityDay},
+		{testCase: "hourly", template: "{year}/{month}/{day}/{hour}", expectedGranularity: granularityHour},
+		{testCase: "monthly", template: "{year}-{month}", expectedGranularity: granularityMonth},
+		{testCase: "yearly", template: "{year}", expectedGranularity: granularityYear},
+		{testCase: "hive", template: "dt={year}-{month}-{day}/part-*", expectedGranularity: granularityDay},
+		{testCase: "device", template: "{device}/{year}/{month}/{day}", device: "thermostat-1", expectedGranularity: granularityDay},
+		{testCase: "unknown_placeholder", template: "{year}/{week}", expectedError: true},
+		{testCase: "no_year", template: "{month}/{day}", expectedError: true},
+		{testCase: "hour_without_day", template: "{year}/{month}/{hour}", expectedError: true},
+		{testCase: "day_without_month", template: "{year}/{day}", expectedError: true},
+		{testCase: "missing_device", template: "{device}/{year}/{month}/{day}", expectedError: true},
+		{testCase: "unused_device", template: "{year}/{month}/{day}", device: "thermostat-1", expectedError: true},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			output, err := parseLayout(test.template, test.device)
+
+			// assertions
+			if test.expectedError != (err != nil) {
+				t.Fatalf("expected an error: %v, but the error was: %v", test.expectedError, err)
+			}
+			if err == nil && output.granularity != test.expectedGranularity {
+				t.Errorf("expected the granularity (%v) to be %v", output.granularity, test.expectedGranularity)
+			}
+		})
+	}
+}
+
+func TestLayoutPartitions(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tdata := []struct {
+		testCase          string
+		template          string
+		device            string
+		location          *time.Location
+		dateTime          time.Time
+		count             int
+		expectedPath      string
+		expectedPartition time.Time
+	}{
+		{
+			testCase:          "default",
+			dateTime:          testTime("2016-01-01T03:00"),
+			count:             -1,
+			expectedPath:      "/tmp/ehub_data/2016/01/01",
+			expectedPartition: testTime("2015-12-31T00:00"),
+		},
+		{
+			testCase:          "hourly",
+			template:          "{year}/{month}/{day}/{hour}",
+			dateTime:          testTime("2016-01-01T03:15"),
+			count:             -4,
+			expectedPath:      "/tmp/ehub_data/2016/01/01/03",
+			expectedPartition: testTime("2015-12-31T23:00"),
+		},
+		{
+			testCase:          "hourly_dst",
+			template:          "{year}/{month}/{day}/{hour}",
+			location:          newYork,
+			dateTime:          time.Date(2016, 3, 13, 1, 30, 0, 0, newYork),
+			count:             1,
+			expectedPath:      "/tmp/ehub_data/2016/03/13/01",
+			expectedPartition: time.Date(2016, 3, 13, 3, 0, 0, 0, newYork),
+		},
+		{
+			testCase:          "monthly",
+			template:          "{year}-{month}.jsonl",
+			dateTime:          testTime("2016-01-31T03:00"),
+			count:             1,
+			expectedPath:      "/tmp/ehub_data/2016-01.jsonl",
+			expectedPartition: testTime("2016-02-01T00:00"),
+		},
+		{
+			testCase:          "device",
+			template:          "{device}/dt={year}-{month}-{day}/part-*",
+			device:            "thermostat-1",
+			dateTime:          testTime("2016-01-01T03:00"),
+			count:             0,
+			expectedPath:      "/tmp/ehub_data/thermostat-1/dt=2016-01-01/part-*",
+			expectedPartition: testTime("2016-01-01T00:00"),
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			layout, err := parseLayout(test.template, test.device)
+			if err != nil {
+				t.Fatal(err)
+			}
+			layout.location = test.location
+
+			// logic under test
+			path := layout.path("/tmp/ehub_data/", layout.partitionStart(test.dateTime))
+			partition := layout.addPartitions(test.dateTime, test.count)
+
+			// assertions
+			if path != test.expectedPath {
+				t.Errorf("expected %q to equal %q", path, test.expectedPath)
+			}
+			if !partition.Equal(test.expectedPartition) {
+				t.Errorf("expected %v to equal %v", partition, test.expectedPartition)
+			}
+		})
+	}
+}
+
+func TestClientLayouts(t *testing.T) {
+	source := NewMemSource()
+	source.WriteFile("mem://hourly/2016/01/01/02.jsonl.gz", gzipBytes(t, `{"changeTime": "2016-01-01T02:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`))
+	source.WriteFile("mem://hourly/2016/01/01/04.jsonl.gz", gzipBytes(t, `{"changeTime": "2016-01-01T04:30:00.001059", "after": {"ambientTemp": 81.0}, "before": {"ambientTemp": 79.0}}`))
+	source.WriteFile("mem://devices/thermostat-1/2016/01/01.jsonl", []byte(`{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 75.0}, "before": {"ambientTemp": 74.0}}`))
+	source.WriteFile("mem://devices/thermostat-2/2016/01/01.jsonl", []byte(`{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 65.0}, "before": {"ambientTemp": 64.0}}`))
+	source.WriteFile("mem://hive/dt=2016-01-01/part-0000.jsonl.gz", gzipBytes(t, `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`))
+	source.WriteFile("mem://hive/dt=2016-01-01/part-0001.jsonl.gz", gzipBytes(t, `{"changeTime": "2016-01-01T01:30:00.001059", "after": {"heatTemp": 68.0}, "before": {"heatTemp": 66.0}}`))
+	source.WriteFile("mem://hive/dt=2016-01-01/_SUCCESS", []byte{})
+	source.WriteFile("mem://hive[1]/dt=2016-01-01/part-0000.jsonl.gz", gzipBytes(t, `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 75.0}, "before": {"ambientTemp": 77.0}}`))
+
+	tdata := []struct {
+		testCase          string
+		dataSource        string
+		template          string
+		device            string
+		fields            []string
+		expectedOutput    map[string]interface{}
+		expectedErrorKind error
+	}{
+		{
+			testCase:       "hourly",
+			dataSource:     "mem://hourly",
+			template:       "{year}/{month}/{day}/{hour}",
+			fields:         []string{"ambientTemp"},
+			expectedOutput: map[string]interface{}{"ambientTemp": 79.0},
+		},
+		{
+			testCase:       "device",
+			dataSource:     "mem://devices",
+			template:       "{device}/{year}/{month}/{day}",
+			device:         "thermostat-2",
+			fields:         []string{"ambientTemp"},
+			expectedOutput: map[string]interface{}{"ambientTemp": 65.0},
+		},
+		{
+			testCase:       "glob",
+			dataSource:     "mem://hive",
+			template:       "dt={year}-{month}-{day}/part-*",
+			fields:         []string{"ambientTemp", "heatTemp"},
+			expectedOutput: map[string]interface{}{"ambientTemp": 79.0, "heatTemp": 68.0},
+		},
+		{
+			testCase:       "glob_characters_in_the_data_source",
+			dataSource:     "mem://hive[1]",
+			template:       "dt={year}-{month}-{day}/part-*",
+			fields:         []string{"ambientTemp"},
+			expectedOutput: map[string]interface{}{"ambientTemp": 75.0},
+		},
+		{
+			testCase:          "bad_layout",
+			dataSource:        "mem://hourly",
+			template:          "{year}/{week}",
+			expectedErrorKind: ErrBadInput,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			client, err := NewClient(test.dataSource, WithSource("mem", source), WithLayout(test.template, test.device), WithMaxLookback(1))
+			if test.expectedErrorKind != nil {
+				if !errors.Is(err, test.expectedErrorKind) {
+					t.Errorf("expected the error (%v) to be a %v error", err, test.expectedErrorKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			output, err := client.StateAt(context.Background(), testTime("2016-01-01T03:00"), test.fields)
+
+			// assertions
+			if err != nil {
+				t.Fatal(err)
+			}
+			for field, expected := range test.expectedOutput {
+				if output.Fields[field] != expected {
+					t.Errorf("expected the %s (%v) to be %v", field, output.Fields[field], expected)
+				}
+			}
+		})
+	}
+}
+
+func TestClientRepeatedHour(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewMemSource()
+	source.WriteFile("mem://hourly/2016/11/06/00.jsonl", []byte(`{"changeTime": "2016-11-06T00:30:00-04:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}`))
+	source.WriteFile("mem://hourly/2016/11/06/01.jsonl", []byte(`{"changeTime": "2016-11-06T01:30:00-04:00", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 78.0}}
+{"changeTime": "2016-11-06T01:30:00-05:00", "after": {"ambientTemp": 80.0}, "before": {"ambientTemp": 79.0}}`))
+	client, err := NewClient("mem://hourly", WithSource("mem", source), WithLayout("{year}/{month}/{day}/{hour}", ""), WithPartitionTimeZone(newYork), WithMaxLookback(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2016, 11, 6, 0, 0, 0, 0, newYork)
+	to := time.Date(2016, 11, 6, 3, 0, 0, 0, newYork) // <= 4 hours later, with 01:00 twice
+
+	// logic under test
+	timeline, rangeErr := client.Range(context.Background(), from, to, []string{"ambientTemp"})
+	verified, verifyErr := client.Verify(context.Background(), from, to)
+	checkpoints, checkpointErr := client.Checkpoint(context.Background(), from, to)
+
+	// assertions
+	for _, err := range []error{rangeErr, verifyErr, checkpointErr} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(timeline.Changes) != 3 {
+		t.Errorf("expected every change to be in the range once, but there were %+v", timeline.Changes)
+	}
+	if verified.Lines != 3 || len(verified.Breaks) != 0 {
+		t.Errorf("expected 3 lines without breaks, but there were %d lines and the breaks %+v", verified.Lines, verified.Breaks)
+	}
+	paths := map[string]bool{}
+	for _, checkpoint := range checkpoints.Checkpoints {
+		if paths[checkpoint.Path] {
+			t.Errorf("expected the checkpoint %s to only be written once", checkpoint.Path)
+		}
+		paths[checkpoint.Path] = true
+	}
+	if len(paths) != 4 { // <= the repeated hour is in the checkpoint of the hour before it
+		t.Errorf("expected a checkpoint for each of the 4 partitions, but there were %+v", checkpoints.Checkpoints)
+	}
+}
@@ -0,0 +1,91 @@
+package replay
+
+import (
+	"reflect"
+)
+
+// mergePatch combines two partial updates to the same json value, using the
+// semantics of a JSON merge patch (https://tools.ietf.org/html/rfc7386).
+//
+// Each line of a day file only holds the keys of an object that changed, so
+// a line that changes `setpoint.heatTemp` has an `after` of
+// `{"setpoint": {"heatTemp": 67.0}}` regardless of what the rest of `setpoint`
+// looks like. Merging these partial objects together over time rebuilds the
+// full value. Values in patch take precedence over values in target. Nulls
+// are kept in the output, so that a key that was removed by a patch stays
+// removed when the output is merged again later. Use removeNulls once all of
+// the patches have been merged.
+//
+// Neither input is modified.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		return copyValue(patchObject)
+	}
+
+	output := copyValue(targetObject).(map[string]interface{})
+	for key, value := range patchObject {
+		output[key] = mergePatch(output[key], value)
+	}
+	return output
+}
+
+// removeNulls removes the keys of an object, and its nested objects, that
+// were set to null by a merge patch. A null that isn't inside of an object is
+// a real value and is left alone.
+func removeNulls(value interface{}) interface{} {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	output := make(map[string]interface{}, len(object))
+	for key, nested := range object {
+		if nested == nil {
+			continue
+		}
+		output[key] = removeNulls(nested)
+	}
+	return output
+}
+
+// valuesConflict compares two json values for equality without panicking on
+// maps and slices. Objects can be partial (see mergePatch) so only the keys
+// that both objects have are compared.
+func valuesConflict(a interface{}, b interface{}) bool {
+	aObject, aIsObject := a.(map[string]interface{})
+	bObject, bIsObject := b.(map[string]interface{})
+	if !aIsObject || !bIsObject {
+		return !reflect.DeepEqual(a, b)
+	}
+	for key, aValue := range aObject {
+		bValue, ok := bObject[key]
+		if ok && valuesConflict(aValue, bValue) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyValue makes a deep copy of a json value
+func copyValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		output := make(map[string]interface{}, len(typed))
+		for key, nested := range typed {
+			output[key] = copyValue(nested)
+		}
+		return output
+	case []interface{}:
+		output := make([]interface{}, len(typed))
+		for index, nested := range typed {
+			output[index] = copyValue(nested)
+		}
+		return output
+	default:
+		return value
+	}
+}
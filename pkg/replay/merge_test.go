@@ -0,0 +1,128 @@
+package replay
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	tdata := []struct {
+		testCase       string
+		target         interface{}
+		patch          interface{}
+		expectedOutput interface{}
+	}{
+		{
+			testCase:       "scalars__patch_wins",
+			target:         77.0,
+			patch:          79.0,
+			expectedOutput: 79.0,
+		},
+		{
+			testCase:       "empty_target",
+			patch:          map[string]interface{}{"heatTemp": 67.0},
+			expectedOutput: map[string]interface{}{"heatTemp": 67.0},
+		},
+		{
+			testCase:       "objects__keys_are_merged",
+			target:         map[string]interface{}{"heatTemp": 67.0, "coolTemp": 75.0},
+			patch:          map[string]interface{}{"heatTemp": 68.0},
+			expectedOutput: map[string]interface{}{"heatTemp": 68.0, "coolTemp": 75.0},
+		},
+		{
+			testCase:       "objects__nested_keys_are_merged",
+			target:         map[string]interface{}{"a": map[string]interface{}{"b": 1.0, "c": 2.0}},
+			patch:          map[string]interface{}{"a": map[string]interface{}{"b": 3.0}},
+			expectedOutput: map[string]interface{}{"a": map[string]interface{}{"b": 3.0, "c": 2.0}},
+		},
+		{
+			testCase:       "objects__nulls_are_kept",
+			target:         map[string]interface{}{"heatTemp": 67.0, "coolTemp": 75.0},
+			patch:          map[string]interface{}{"coolTemp": nil},
+			expectedOutput: map[string]interface{}{"heatTemp": 67.0, "coolTemp": nil},
+		},
+		{
+			testCase:       "arrays_are_replaced",
+			target:         map[string]interface{}{"periods": []interface{}{1.0, 2.0}},
+			patch:          map[string]interface{}{"periods": []interface{}{3.0}},
+			expectedOutput: map[string]interface{}{"periods": []interface{}{3.0}},
+		},
+		{
+			testCase:       "object_replaces_scalar",
+			target:         77.0,
+			patch:          map[string]interface{}{"heatTemp": 67.0},
+			expectedOutput: map[string]interface{}{"heatTemp": 67.0},
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			output := mergePatch(test.target, test.patch)
+
+			// assertions
+			if !reflect.DeepEqual(test.expectedOutput, output) {
+				t.Errorf("expected %+v to equal %+v", test.expectedOutput, output)
+			}
+		})
+	}
+}
+
+func TestValuesConflict(t *testing.T) {
+	tdata := []struct {
+		testCase         string
+		a                interface{}
+		b                interface{}
+		expectedConflict bool
+	}{
+		{
+			testCase: "equal_scalars",
+			a:        77.0,
+			b:        77.0,
+		},
+		{
+			testCase:         "different_scalars",
+			a:                77.0,
+			b:                79.0,
+			expectedConflict: true,
+		},
+		{
+			testCase: "equal_arrays",
+			a:        []interface{}{1.0, 2.0},
+			b:        []interface{}{1.0, 2.0},
+		},
+		{
+			testCase:         "different_arrays",
+			a:                []interface{}{1.0, 2.0},
+			b:                []interface{}{1.0},
+			expectedConflict: true,
+		},
+		{
+			testCase: "partial_objects_that_agree",
+			a:        map[string]interface{}{"heatTemp": 67.0},
+			b:        map[string]interface{}{"heatTemp": 67.0, "coolTemp": 75.0},
+		},
+		{
+			testCase:         "partial_objects_that_disagree",
+			a:                map[string]interface{}{"heatTemp": 67.0},
+			b:                map[string]interface{}{"heatTemp": 68.0, "coolTemp": 75.0},
+			expectedConflict: true,
+		},
+		{
+			testCase:         "object_and_scalar",
+			a:                map[string]interface{}{"heatTemp": 67.0},
+			b:                67.0,
+			expectedConflict: true,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			conflict := valuesConflict(test.a, test.b)
+
+			// assertions
+			if test.expectedConflict != conflict {
+				t.Errorf("expected conflict to be %t", test.expectedConflict)
+			}
+		})
+	}
+}
@@ -0,0 +1,648 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// outputFormats are the formats that the output of a command can be shown in
+var outputFormats = []string{"json", "pretty", "table", "csv", "yaml", "ndjson"}
+
+// outputOptions is how to show the output of a command, see writeOutput
+type outputOptions struct {
+	format   string // one of outputFormats
+	decimals int    // the number of decimal places of floats, or -1 for the shortest representation
+}
+
+// parseOutputOptions checks an output format and a float format. The float
+// format is `shortest`, for the fewest digits that read back as the same
+// number (the default), or `fixed:{decimals}` like `fixed:1`.
+func parseOutputOptions(format string, floatFormat string) (outputOptions, error) {
+	output := outputOptions{format: format, decimals: -1}
+	if format == "" {
+		output.format = "json"
+	}
+	known := false
+	for _, outputFormat := range outputFormats {
+		known = known || output.format == outputFormat
+	}
+	if !known {
+		err := fmt.Errorf("the output format (%s) must be one of %s", format, strings.Join(outputFormats, ", "))
+		return outputOptions{}, err
+	}
+
+	switch {
+	case floatFormat == "" || floatFormat == "shortest":
+	case strings.HasPrefix(floatFormat, "fixed:"):
+		decimals, err := strconv.Atoi(strings.TrimPrefix(floatFormat, "fixed:"))
+		if err != nil || decimals < 0 || decimals > 17 {
+			err = fmt.Errorf("the float format (%s) must have between 0 and 17 decimals, like fixed:1", floatFormat)
+			return outputOptions{}, err
+		}
+		output.decimals = decimals
+	default:
+		err := fmt.Errorf("the float format (%s) must be shortest or fixed:{decimals}, like fixed:1", floatFormat)
+		return outputOptions{}, err
+	}
+	return output, nil
+}
+
+// orderedObject is a json object that keeps the order of its keys. Structs
+// keep the order of their fields, and maps are sorted by key, so that the
+// output is the same every time.
+type orderedObject []orderedField
+
+type orderedField struct {
+	key   string
+	value interface{}
+}
+
+// outputTable is the rows of an output, for the formats that have a row per
+// record (table, csv and ndjson)
+type outputTable struct {
+	columns []string
+	rows    [][]interface{}
+}
+
+// tabular is implemented by the outputs of the commands, to lay themselves
+// out as a table. Outputs that don't implement it are a table with one row.
+type tabular interface {
+	table() outputTable
+}
+
+// emptyCell is a cell of a table that doesn't have a value, like a field that
+// wasn't known yet. It is blank in tables and csv, and left out of ndjson.
+type emptyCell struct{}
+
+// writeOutput shows the output of a command in w
+func writeOutput(w io.Writer, output interface{}, options outputOptions) error {
+	buffer := bytes.Buffer{}
+	switch options.format {
+	case "table", "csv", "ndjson":
+		table, err := tableOf(output)
+		if err != nil {
+			return err
+		}
+		switch options.format {
+		case "table":
+			err = writeTable(&buffer, table, options)
+		case "csv":
+			err = writeCSV(&buffer, table, options)
+		default:
+			err = writeNDJSON(&buffer, table, options)
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		value, err := orderValue(reflect.ValueOf(output))
+		if err != nil {
+			return err
+		}
+		switch options.format {
+		case "pretty":
+			writeJSONValue(&buffer, value, options, "  ", 0)
+			buffer.WriteString("\n")
+		case "yaml":
+			writeYAML(&buffer, value, options, 0)
+		default:
+			writeJSONValue(&buffer, value, options, "", 0)
+			buffer.WriteString("\n")
+		}
+	}
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+// tableOf lays out an output as a table
+func tableOf(output interface{}) (outputTable, error) {
+	if tabular, ok := output.(tabular); ok {
+		return tabular.table(), nil
+	}
+	value, err := orderValue(reflect.ValueOf(output))
+	if err != nil {
+		return outputTable{}, err
+	}
+	object, ok := value.(orderedObject)
+	if !ok {
+		return outputTable{columns: []string{"value"}, rows: [][]interface{}{{value}}}, nil
+	}
+	table := outputTable{rows: [][]interface{}{{}}}
+	for _, field := range object {
+		table.columns = append(table.columns, field.key)
+		table.rows[0] = append(table.rows[0], field.value)
+	}
+	return table, nil
+}
+
+// orderValue converts a value into the json values that encoding/json would
+// give it, with orderedObject for objects and float64 for floats only, so
+// that ints are never shown with decimals.
+func orderValue(value reflect.Value) (interface{}, error) {
+	if !value.IsValid() {
+		return nil, nil
+	}
+	if marshaler, ok := value.Interface().(json.Marshaler); ok && value.Kind() != reflect.Interface {
+		return orderMarshaler(marshaler)
+	}
+	if number, ok := value.Interface().(json.Number); ok {
+		return number, nil // <= from orderMarshaler
+	}
+
+	switch value.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if value.IsNil() {
+			return nil, nil
+		}
+		return orderValue(value.Elem())
+	case reflect.Struct:
+		output := orderedObject{}
+		for index := 0; index < value.NumField(); index++ {
+			field := value.Type().Field(index)
+			name, omitEmpty := jsonFieldName(field)
+			if name == "" || (omitEmpty && value.Field(index).IsZero()) {
+				continue
+			}
+			fieldValue, err := orderValue(value.Field(index))
+			if err != nil {
+				return nil, err
+			}
+			output = append(output, orderedField{key: name, value: fieldValue})
+		}
+		return output, nil
+	case reflect.Map:
+		if value.IsNil() {
+			return nil, nil
+		}
+		if value.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("can't show a map with %s keys", value.Type().Key())
+		}
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		output := make(orderedObject, 0, len(keys))
+		for _, key := range keys {
+			fieldValue, err := orderValue(value.MapIndex(key))
+			if err != nil {
+				return nil, err
+			}
+			output = append(output, orderedField{key: key.String(), value: fieldValue})
+		}
+		return output, nil
+	case reflect.Slice, reflect.Array:
+		if value.Kind() == reflect.Slice && value.IsNil() {
+			return nil, nil
+		}
+		output := make([]interface{}, value.Len())
+		for index := range output {
+			item, err := orderValue(value.Index(index))
+			if err != nil {
+				return nil, err
+			}
+			output[index] = item
+		}
+		return output, nil
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return json.Number(strconv.FormatInt(value.Int(), 10)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return json.Number(strconv.FormatUint(value.Uint(), 10)), nil
+	case reflect.String:
+		return value.String(), nil
+	case reflect.Bool:
+		return value.Bool(), nil
+	default:
+		return nil, fmt.Errorf("can't show a value of type %s", value.Type())
+	}
+}
+
+// orderMarshaler converts a value with its own json encoding, with the
+// numbers kept as they were encoded
+func orderMarshaler(marshaler json.Marshaler) (interface{}, error) {
+	encoded, err := json.Marshal(marshaler)
+	if err != nil {
+		err = fmt.Errorf("error with json.Marshal: %w", err)
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var decoded interface{}
+	err = decoder.Decode(&decoded)
+	if err != nil {
+		return nil, err
+	}
+	return orderValue(reflect.ValueOf(decoded))
+}
+
+// jsonFieldName returns the name of a struct field in json, which is empty
+// for fields that aren't encoded
+func jsonFieldName(field reflect.StructField) (name string, omitEmpty bool) {
+	if field.PkgPath != "" {
+		return "", false // <= unexported
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = field.Name
+	}
+	for _, option := range parts[1:] {
+		omitEmpty = omitEmpty || option == "omitempty"
+	}
+	return name, omitEmpty
+}
+
+// formatFloat formats a float with the decimals of the options. The shortest
+// representation is the same as encoding/json's.
+func formatFloat(value float64, options outputOptions) string {
+	if options.decimals >= 0 {
+		return strconv.FormatFloat(value, 'f', options.decimals, 64)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return strconv.FormatFloat(value, 'g', -1, 64) // <= NaN and infinities, which json can't have
+	}
+	return string(encoded)
+}
+
+// writeJSONValue writes a value from orderValue as json. Objects and arrays
+// are spread over several lines when indent isn't empty.
+func writeJSONValue(buffer *bytes.Buffer, value interface{}, options outputOptions, indent string, depth int) {
+	newline := func(depth int) {
+		if indent != "" {
+			buffer.WriteString("\n" + strings.Repeat(indent, depth))
+		}
+	}
+
+	switch value := value.(type) {
+	case orderedObject:
+		if len(value) == 0 {
+			buffer.WriteString("{}")
+			return
+		}
+		buffer.WriteString("{")
+		for index, field := range value {
+			if index > 0 {
+				buffer.WriteString(",")
+			}
+			newline(depth + 1)
+			buffer.WriteString(jsonString(field.key))
+			buffer.WriteString(":")
+			if indent != "" {
+				buffer.WriteString(" ")
+			}
+			writeJSONValue(buffer, field.value, options, indent, depth+1)
+		}
+		newline(depth)
+		buffer.WriteString("}")
+	case []interface{}:
+		if len(value) == 0 {
+			buffer.WriteString("[]")
+			return
+		}
+		buffer.WriteString("[")
+		for index, item := range value {
+			if index > 0 {
+				buffer.WriteString(",")
+			}
+			newline(depth + 1)
+			writeJSONValue(buffer, item, options, indent, depth+1)
+		}
+		newline(depth)
+		buffer.WriteString("]")
+	default:
+		buffer.WriteString(scalarString(value, options))
+	}
+}
+
+// scalarString formats a value from orderValue that isn't an object or an
+// array, with strings as json strings
+func scalarString(value interface{}, options outputOptions) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case float64:
+		return formatFloat(value, options)
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	case string:
+		return jsonString(value)
+	default:
+		return jsonString(fmt.Sprint(value))
+	}
+}
+
+// jsonString encodes a string as json, the same way as encoding/json
+func jsonString(value string) string {
+	encoded, _ := json.Marshal(value) // <= strings can always be encoded
+	return string(encoded)
+}
+
+// yamlPlainKey matches the keys that don't need quotes in yaml
+var yamlPlainKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// writeYAML writes a value from orderValue as a yaml block. Strings are
+// always quoted, since yaml reads some unquoted strings as other types, like
+// `on` as true.
+func writeYAML(buffer *bytes.Buffer, value interface{}, options outputOptions, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch value := value.(type) {
+	case orderedObject:
+		if len(value) == 0 {
+			buffer.WriteString(indent + "{}\n")
+			return
+		}
+		for _, field := range value {
+			key := field.key
+			if !yamlPlainKey.MatchString(key) {
+				key = jsonString(key)
+			}
+			buffer.WriteString(indent + key + ":")
+			writeYAMLChild(buffer, field.value, options, depth+1)
+		}
+	case []interface{}:
+		if len(value) == 0 {
+			buffer.WriteString(indent + "[]\n")
+			return
+		}
+		for _, item := range value {
+			// the first key of an object goes on the same line as its `-`
+			if object, ok := item.(orderedObject); ok && len(object) > 0 {
+				child := bytes.Buffer{}
+				writeYAML(&child, object, options, depth+1)
+				buffer.WriteString(indent + "- " + strings.TrimPrefix(child.String(), indent+"  "))
+				continue
+			}
+			buffer.WriteString(indent + "-")
+			writeYAMLChild(buffer, item, options, depth+1)
+		}
+	default:
+		buffer.WriteString(indent + scalarString(value, options) + "\n")
+	}
+}
+
+// writeYAMLChild writes the value of a key or of an item of a list, after
+// its `key:` or `-`
+func writeYAMLChild(buffer *bytes.Buffer, value interface{}, options outputOptions, depth int) {
+	switch child := value.(type) {
+	case orderedObject:
+		if len(child) == 0 {
+			buffer.WriteString(" {}\n")
+			return
+		}
+	case []interface{}:
+		if len(child) == 0 {
+			buffer.WriteString(" []\n")
+			return
+		}
+	default:
+		buffer.WriteString(" " + scalarString(value, options) + "\n")
+		return
+	}
+	buffer.WriteString("\n")
+	writeYAML(buffer, value, options, depth)
+}
+
+// cellString formats a cell of a table. Strings are shown as they are, and
+// objects and arrays as json.
+func cellString(value interface{}, options outputOptions) string {
+	switch value := value.(type) {
+	case emptyCell:
+		return ""
+	case string:
+		return value
+	case orderedObject, []interface{}:
+		buffer := bytes.Buffer{}
+		writeJSONValue(&buffer, value, options, "", 0)
+		return buffer.String()
+	default:
+		return scalarString(value, options)
+	}
+}
+
+// tableCells orders the values of a row for cellString
+func tableCells(row []interface{}, options outputOptions) ([]string, error) {
+	output := make([]string, len(row))
+	for index, cell := range row {
+		if _, ok := cell.(emptyCell); !ok {
+			ordered, err := orderValue(reflect.ValueOf(cell))
+			if err != nil {
+				return nil, err
+			}
+			cell = ordered
+		}
+		output[index] = cellString(cell, options)
+	}
+	return output, nil
+}
+
+// writeTable writes a table with aligned columns and a header of the column
+// names
+func writeTable(buffer *bytes.Buffer, table outputTable, options outputOptions) error {
+	writer := tabwriter.NewWriter(buffer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(table.columns, "\t"))
+	for _, row := range table.rows {
+		cells, err := tableCells(row, options)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(writer, strings.Join(cells, "\t"))
+	}
+	return writer.Flush()
+}
+
+// writeCSV writes a table as csv, with a header of the column names
+func writeCSV(buffer *bytes.Buffer, table outputTable, options outputOptions) error {
+	writer := csv.NewWriter(buffer)
+	err := writer.Write(table.columns)
+	if err != nil {
+		return err
+	}
+	for _, row := range table.rows {
+		cells, err := tableCells(row, options)
+		if err != nil {
+			return err
+		}
+		err = writer.Write(cells)
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeNDJSON writes each row of a table as a json object on its own line,
+// keyed by the column names
+func writeNDJSON(buffer *bytes.Buffer, table outputTable, options outputOptions) error {
+	for _, row := range table.rows {
+		if len(row) != len(table.columns) {
+			return errors.New("a row of the table doesn't have a value for every column")
+		}
+		object := orderedObject{}
+		for index, cell := range row {
+			if _, ok := cell.(emptyCell); ok {
+				continue
+			}
+			value, err := orderValue(reflect.ValueOf(cell))
+			if err != nil {
+				return err
+			}
+			object = append(object, orderedField{key: table.columns[index], value: value})
+		}
+		writeJSONValue(buffer, object, options, "", 0)
+		buffer.WriteString("\n")
+	}
+	return nil
+}
+
+// stateColumns returns the sorted union of the fields of several states, as
+// the columns of a table
+func stateColumns(states ...map[string]interface{}) []string {
+	found := map[string]bool{}
+	output := []string{}
+	for _, state := range states {
+		for field := range state {
+			if !found[field] {
+				found[field] = true
+				output = append(output, field)
+			}
+		}
+	}
+	sort.Strings(output)
+	return output
+}
+
+// stateRow returns the row of a table for a state, with a cell for each of
+// the fields
+func stateRow(ts string, state map[string]interface{}, fields []string) []interface{} {
+	output := []interface{}{ts}
+	for _, field := range fields {
+		value, found := state[field]
+		if !found {
+			output = append(output, emptyCell{})
+			continue
+		}
+		output = append(output, value)
+	}
+	return output
+}
+
+// table has a column for each field
+func (s State) table() outputTable {
+	if s.Provenance != nil {
+		return s.provenanceTable()
+	}
+	fields := stateColumns(s.Fields)
+	return outputTable{
+		columns: append([]string{"ts"}, fields...),
+		rows:    [][]interface{}{stateRow(s.Ts, s.Fields, fields)},
+	}
+}
+
+// provenanceTable has a row for each field, with where its value came from
+func (s State) provenanceTable() outputTable {
+	output := outputTable{columns: []string{"field", "value", "changeTime", "path", "line", "side", "age"}}
+	for _, field := range stateColumns(s.Fields) {
+		provenance := s.Provenance[field]
+		var line interface{} = provenance.Line
+		if provenance.Line == 0 {
+			line = emptyCell{}
+		}
+		output.rows = append(output.rows, []interface{}{field, provenance.Value, provenance.ChangeTime, provenance.Path, line, provenance.Side, provenance.Age})
+	}
+	return output
+}
+
+// table has the state at from, and then the state after each change
+func (t Timeline) table() outputTable {
+	states := []map[string]interface{}{t.Fields}
+	for _, change := range t.Changes {
+		states = append(states, change.Fields)
+	}
+	fields := stateColumns(states...)
+	output := outputTable{columns: append([]string{"ts"}, fields...)}
+	output.rows = append(output.rows, stateRow(t.From, t.Fields, fields))
+	for _, change := range t.Changes {
+		output.rows = append(output.rows, stateRow(change.Ts, change.Fields, fields))
+	}
+	return output
+}
+
+// table has the state at each sample
+func (s Samples) table() outputTable {
+	states := []map[string]interface{}{}
+	for _, sample := range s.Samples {
+		states = append(states, sample.Fields)
+	}
+	fields := stateColumns(states...)
+	output := outputTable{columns: append([]string{"ts"}, fields...)}
+	for _, sample := range s.Samples {
+		output.rows = append(output.rows, stateRow(sample.Ts, sample.Fields, fields))
+	}
+	return output
+}
+
+// table has a row for each difference, with the old and new values in
+// columns named after from and to
+func (d Diff) table() outputTable {
+	output := outputTable{columns: []string{"field", "change", d.From, d.To}}
+	for _, difference := range d.Differences {
+		var oldValue, newValue interface{} = difference.Old, difference.New
+		if difference.Change == DiffAdded {
+			oldValue = emptyCell{}
+		}
+		if difference.Change == DiffRemoved {
+			newValue = emptyCell{}
+		}
+		output.rows = append(output.rows, []interface{}{difference.Field, difference.Change, oldValue, newValue})
+	}
+	return output
+}
+
+// table has a row for each file that was indexed
+func (r IndexResult) table() outputTable {
+	output := outputTable{columns: []string{"path", "index", "lines", "fields"}}
+	for _, file := range r.Files {
+		output.rows = append(output.rows, []interface{}{file.Path, file.Index, file.Lines, file.Fields})
+	}
+	return output
+}
+
+// table has a row for each checkpoint that was written
+func (r CheckpointResult) table() outputTable {
+	output := outputTable{columns: []string{"partition", "path", "fields"}}
+	for _, checkpoint := range r.Checkpoints {
+		output.rows = append(output.rows, []interface{}{checkpoint.Partition, checkpoint.Path, checkpoint.Fields})
+	}
+	return output
+}
+
+// table has a row for each break, the before and after are only filled in for
+// a mismatch
+func (r VerifyResult) table() outputTable {
+	output := outputTable{columns: []string{"kind", "path", "line", "changeTime", "field", "before", "after", "message"}}
+	for _, verifyBreak := range r.Breaks {
+		var before, after interface{} = emptyCell{}, emptyCell{}
+		if verifyBreak.Kind == BreakMismatch {
+			before, after = verifyBreak.Before, verifyBreak.After
+		}
+		output.rows = append(output.rows, []interface{}{verifyBreak.Kind, verifyBreak.Path, verifyBreak.Line, verifyBreak.ChangeTime, verifyBreak.Field, before, after, verifyBreak.Message})
+	}
+	return output
+}
@@ -0,0 +1,220 @@
+package replay
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteOutput(t *testing.T) {
+	state := State{
+		Fields: map[string]interface{}{
+			"schedule":    true,
+			"ambientTemp": 77.0,
+			"setpoint":    map[string]interface{}{"heatTemp": 68.5, "coolTemp": 76.0},
+			"fan":         "auto",
+		},
+		Ts: "2016-01-01T03:00:00",
+	}
+	diff := Diff{
+		From: "2016-01-01T02:00:00",
+		To:   "2016-01-01T03:00:00",
+		Differences: []FieldDiff{
+			{Field: "ambientTemp", Change: DiffChanged, Old: 76.0, New: 77.0},
+			{Field: "fan", Change: DiffAdded, New: "auto"},
+		},
+	}
+	samples := Samples{
+		From:  "2016-01-01T00:00:00",
+		To:    "2016-01-01T01:00:00",
+		Every: "1h0m0s",
+		Samples: []State{
+			{Fields: map[string]interface{}{"ambientTemp": 76.0}, Ts: "2016-01-01T00:00:00"},
+			{Fields: map[string]interface{}{"ambientTemp": 77.0, "fan": "auto"}, Ts: "2016-01-01T01:00:00"},
+		},
+	}
+
+	tdata := []struct {
+		testCase       string
+		output         interface{}
+		format         string
+		floatFormat    string
+		expectedOutput string
+	}{
+		{
+			testCase:       "json",
+			output:         state,
+			format:         "json",
+			expectedOutput: `{"state":{"ambientTemp":77,"fan":"auto","schedule":true,"setpoint":{"coolTemp":76,"heatTemp":68.5}},"ts":"2016-01-01T03:00:00"}` + "\n",
+		},
+		{
+			testCase:       "json_fixed_floats",
+			output:         state,
+			format:         "json",
+			floatFormat:    "fixed:1",
+			expectedOutput: `{"state":{"ambientTemp":77.0,"fan":"auto","schedule":true,"setpoint":{"coolTemp":76.0,"heatTemp":68.5}},"ts":"2016-01-01T03:00:00"}` + "\n",
+		},
+		{
+			testCase:    "pretty",
+			output:      Diff{From: "2016-01-01T02:00:00", To: "2016-01-01T03:00:00", Differences: []FieldDiff{}},
+			format:      "pretty",
+			floatFormat: "fixed:1",
+			expectedOutput: `{
+  "from": "2016-01-01T02:00:00",
+  "to": "2016-01-01T03:00:00",
+  "differences": []
+}
+`,
+		},
+		{
+			testCase:    "yaml",
+			output:      state,
+			format:      "yaml",
+			floatFormat: "fixed:2",
+			expectedOutput: `state:
+  ambientTemp: 77.00
+  fan: "auto"
+  schedule: true
+  setpoint:
+    coolTemp: 76.00
+    heatTemp: 68.50
+ts: "2016-01-01T03:00:00"
+`,
+		},
+		{
+			testCase: "yaml_lists",
+			output:   samples,
+			format:   "yaml",
+			expectedOutput: `from: "2016-01-01T00:00:00"
+to: "2016-01-01T01:00:00"
+every: "1h0m0s"
+samples:
+  - state:
+      ambientTemp: 76
+    ts: "2016-01-01T00:00:00"
+  - state:
+      ambientTemp: 77
+      fan: "auto"
+    ts: "2016-01-01T01:00:00"
+`,
+		},
+		{
+			testCase:    "table",
+			output:      diff,
+			format:      "table",
+			floatFormat: "fixed:1",
+			expectedOutput: `field        change   2016-01-01T02:00:00  2016-01-01T03:00:00
+ambientTemp  changed  76.0                 77.0
+fan          added                         auto
+`,
+		},
+		{
+			testCase: "csv",
+			output:   samples,
+			format:   "csv",
+			expectedOutput: `ts,ambientTemp,fan
+2016-01-01T00:00:00,76,
+2016-01-01T01:00:00,77,auto
+`,
+		},
+		{
+			testCase: "csv_objects",
+			output:   state,
+			format:   "csv",
+			expectedOutput: `ts,ambientTemp,fan,schedule,setpoint
+2016-01-01T03:00:00,77,auto,true,"{""coolTemp"":76,""heatTemp"":68.5}"
+`,
+		},
+		{
+			testCase:    "ndjson",
+			output:      samples,
+			format:      "ndjson",
+			floatFormat: "fixed:1",
+			expectedOutput: `{"ts":"2016-01-01T00:00:00","ambientTemp":76.0}
+{"ts":"2016-01-01T01:00:00","ambientTemp":77.0,"fan":"auto"}
+`,
+		},
+		{
+			testCase: "ints_are_not_floats",
+			output:   IndexResult{Files: []IndexedFile{{Path: "2016/01/01.jsonl", Index: "2016/01/01.jsonl.idx", Lines: 6, Fields: 2}}},
+			format:   "csv",
+			// the float format doesn't apply to counts
+			floatFormat: "fixed:1",
+			expectedOutput: `path,index,lines,fields
+2016/01/01.jsonl,2016/01/01.jsonl.idx,6,2
+`,
+		},
+		{
+			testCase: "table_provenance",
+			output: State{
+				Fields: map[string]interface{}{"ambientTemp": 79.0, "schedule": false},
+				Ts:     "2016-01-01T03:00:00",
+				Provenance: map[string]Provenance{
+					"ambientTemp": {Value: 79.0, ChangeTime: "2016-01-01T00:30:00", Path: "/tmp/ehub_data/_checkpoints/2016-01-01T00.json.gz", Side: "nearestBefore", Age: "2h30m0s"},
+					"schedule":    {Value: false, ChangeTime: "2016-01-01T03:18:30", Path: "/tmp/ehub_data/2016/01/01.jsonl.gz", Line: 3, Side: "nearestAfter", Age: "-18m30s"},
+				},
+			},
+			format: "table",
+			expectedOutput: `field        value  changeTime           path                                               line  side           age
+ambientTemp  79     2016-01-01T00:30:00  /tmp/ehub_data/_checkpoints/2016-01-01T00.json.gz        nearestBefore  2h30m0s
+schedule     false  2016-01-01T03:18:30  /tmp/ehub_data/2016/01/01.jsonl.gz                 3     nearestAfter   -18m30s
+`,
+		},
+		{
+			testCase:       "untabular",
+			output:         cacheStats{Dir: "/tmp/cache", Entries: 2, Bytes: 10, MaxBytes: 100},
+			format:         "ndjson",
+			expectedOutput: `{"dir":"/tmp/cache","entries":2,"bytes":10,"maxBytes":100}` + "\n",
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			options, err := parseOutputOptions(test.format, test.floatFormat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			output := bytes.Buffer{}
+
+			// logic under test
+			err = writeOutput(&output, test.output, options)
+
+			// assertions
+			if err != nil {
+				t.Fatal(err)
+			}
+			if output.String() != test.expectedOutput {
+				t.Errorf("expected:\n%s\nto equal:\n%s", output.String(), test.expectedOutput)
+			}
+		})
+	}
+}
+
+func TestParseOutputOptions(t *testing.T) {
+	tdata := []struct {
+		testCase         string
+		format           string
+		floatFormat      string
+		expectedDecimals int
+		expectedAnError  bool
+	}{
+		{testCase: "defaults", expectedDecimals: -1},
+		{testCase: "shortest", format: "table", floatFormat: "shortest", expectedDecimals: -1},
+		{testCase: "fixed", format: "csv", floatFormat: "fixed:3", expectedDecimals: 3},
+		{testCase: "unknown_format", format: "xml", expectedAnError: true},
+		{testCase: "unknown_float_format", format: "json", floatFormat: "%.1f", expectedAnError: true},
+		{testCase: "negative_decimals", format: "json", floatFormat: "fixed:-1", expectedAnError: true},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			output, err := parseOutputOptions(test.format, test.floatFormat)
+
+			// assertions
+			if test.expectedAnError != (err != nil) {
+				t.Fatalf("expected an error: %v, but the error was: %v", test.expectedAnError, err)
+			}
+			if err == nil && output.decimals != test.expectedDecimals {
+				t.Errorf("expected %d decimals to equal %d", output.decimals, test.expectedDecimals)
+			}
+		})
+	}
+}
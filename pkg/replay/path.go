@@ -0,0 +1,124 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// fieldPath is a parsed `--field` argument.
+//
+// Fields can address nested values with dots for object keys and brackets
+// for array indexes, for example
+//
+//	ambientTemp
+//	setpoint.heatTemp
+//	schedule.periods[0].start
+type fieldPath struct {
+	raw      string
+	segments []pathSegment
+}
+
+// pathSegment is one step into a json value, either an object key or an array index
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// parseFieldPaths parses every field, failing on the first one that is invalid
+func parseFieldPaths(fields []string) (output []fieldPath, err error) {
+	for _, field := range fields {
+		path, err := parseFieldPath(field)
+		if err != nil {
+			return nil, err
+		}
+		output = append(output, path)
+	}
+	return output, nil
+}
+
+func parseFieldPath(field string) (output fieldPath, err error) {
+	output.raw = field
+	if field == "" {
+		err = errors.New("the field was empty")
+		return fieldPath{}, err
+	}
+
+	// a key has to come at the start and after each dot, which is what
+	// expectKey tracks as we walk through the field
+	rest := field
+	expectKey := true
+	for rest != "" {
+		switch {
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				err = fmt.Errorf("the field (%s) has an unclosed \"[\"", field)
+				return fieldPath{}, err
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				err = fmt.Errorf("the field (%s) has an invalid array index (%s)", field, rest[1:end])
+				return fieldPath{}, err
+			}
+			if expectKey {
+				err = fmt.Errorf("the field (%s) has an empty key", field)
+				return fieldPath{}, err
+			}
+			output.segments = append(output.segments, pathSegment{index: index, isIndex: true})
+			rest = rest[end+1:]
+			expectKey = false
+		case rest[0] == '.':
+			if expectKey {
+				err = fmt.Errorf("the field (%s) has an empty key", field)
+				return fieldPath{}, err
+			}
+			rest = rest[1:]
+			expectKey = true
+			if rest == "" {
+				err = fmt.Errorf("the field (%s) has an empty key", field)
+				return fieldPath{}, err
+			}
+		default:
+			if !expectKey {
+				err = fmt.Errorf("the field (%s) is missing a \".\" before (%s)", field, rest)
+				return fieldPath{}, err
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			output.segments = append(output.segments, pathSegment{key: rest[:end]})
+			rest = rest[end:]
+			expectKey = false
+		}
+	}
+
+	return output, nil
+}
+
+// lookup finds the value at this path inside of the given data
+func (p fieldPath) lookup(data map[string]interface{}) (value interface{}, found bool) {
+	value = data
+	for _, segment := range p.segments {
+		if segment.isIndex {
+			array, ok := value.([]interface{})
+			if !ok || segment.index >= len(array) {
+				return nil, false
+			}
+			value = array[segment.index]
+			continue
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = object[segment.key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
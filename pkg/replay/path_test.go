@@ -0,0 +1,177 @@
+package replay
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFieldPath(t *testing.T) {
+	tdata := []struct {
+		testCase         string
+		input            string
+		expectedSegments []pathSegment
+		expectedAnError  bool
+	}{
+		{
+			testCase:        "empty",
+			expectedAnError: true,
+		},
+		{
+			testCase:         "top_level_key",
+			input:            "ambientTemp",
+			expectedSegments: []pathSegment{{key: "ambientTemp"}},
+		},
+		{
+			testCase:         "nested_key",
+			input:            "setpoint.heatTemp",
+			expectedSegments: []pathSegment{{key: "setpoint"}, {key: "heatTemp"}},
+		},
+		{
+			testCase: "array_indexes",
+			input:    "schedule.periods[1][0].start",
+			expectedSegments: []pathSegment{
+				{key: "schedule"},
+				{key: "periods"},
+				{index: 1, isIndex: true},
+				{index: 0, isIndex: true},
+				{key: "start"},
+			},
+		},
+		{
+			testCase:        "leading_dot",
+			input:           ".setpoint",
+			expectedAnError: true,
+		},
+		{
+			testCase:        "trailing_dot",
+			input:           "setpoint.",
+			expectedAnError: true,
+		},
+		{
+			testCase:        "double_dot",
+			input:           "setpoint..heatTemp",
+			expectedAnError: true,
+		},
+		{
+			testCase:        "leading_index",
+			input:           "[0]",
+			expectedAnError: true,
+		},
+		{
+			testCase:        "index_after_dot",
+			input:           "periods.[0]",
+			expectedAnError: true,
+		},
+		{
+			testCase:        "unclosed_index",
+			input:           "periods[0",
+			expectedAnError: true,
+		},
+		{
+			testCase:        "negative_index",
+			input:           "periods[-1]",
+			expectedAnError: true,
+		},
+		{
+			testCase:        "key_directly_after_index",
+			input:           "periods[0]start",
+			expectedAnError: true,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			output, err := parseFieldPath(test.input)
+
+			// assertions
+			if !reflect.DeepEqual(test.expectedSegments, output.segments) {
+				t.Errorf("expected %+v to equal %+v", test.expectedSegments, output.segments)
+			}
+			if test.expectedAnError && err == nil {
+				t.Error("expected an error, but there was none!")
+			}
+			if !test.expectedAnError && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestFieldPathLookup(t *testing.T) {
+	data := map[string]interface{}{
+		"ambientTemp": 77.0,
+		"setpoint": map[string]interface{}{
+			"heatTemp": 67.0,
+		},
+		"periods": []interface{}{
+			map[string]interface{}{"start": "06:00"},
+		},
+	}
+
+	tdata := []struct {
+		testCase      string
+		input         string
+		expectedValue interface{}
+		expectedFound bool
+	}{
+		{
+			testCase:      "top_level_key",
+			input:         "ambientTemp",
+			expectedValue: 77.0,
+			expectedFound: true,
+		},
+		{
+			testCase:      "nested_key",
+			input:         "setpoint.heatTemp",
+			expectedValue: 67.0,
+			expectedFound: true,
+		},
+		{
+			testCase:      "whole_object",
+			input:         "setpoint",
+			expectedValue: map[string]interface{}{"heatTemp": 67.0},
+			expectedFound: true,
+		},
+		{
+			testCase:      "array_index",
+			input:         "periods[0].start",
+			expectedValue: "06:00",
+			expectedFound: true,
+		},
+		{
+			testCase: "array_index_out_of_range",
+			input:    "periods[1].start",
+		},
+		{
+			testCase: "missing_key",
+			input:    "setpoint.coolTemp",
+		},
+		{
+			testCase: "key_into_a_number",
+			input:    "ambientTemp.value",
+		},
+		{
+			testCase: "index_into_an_object",
+			input:    "setpoint[0]",
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			path, err := parseFieldPath(test.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// logic under test
+			value, found := path.lookup(data)
+
+			// assertions
+			if !reflect.DeepEqual(test.expectedValue, value) {
+				t.Errorf("expected %+v to equal %+v", test.expectedValue, value)
+			}
+			if test.expectedFound != found {
+				t.Errorf("expected found to be %t", test.expectedFound)
+			}
+		})
+	}
+}
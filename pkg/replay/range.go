@@ -0,0 +1,215 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// changeTimeLayout is used to show the time of a change. It keeps the
+// fractional seconds, since several changes can happen in the same second.
+const changeTimeLayout = "2006-01-02T15:04:05.999999"
+
+type getRangeInput struct {
+	fields         []string
+	dataSource     string
+	layout         layout
+	from           time.Time
+	to             time.Time
+	readerFunc     readerFunc
+	checkpointFunc checkpointFunc
+	maxLookback    int
+	badLinePolicy  string
+}
+
+// Timeline is every change to the requested fields between two points in time
+type Timeline struct {
+	From    string                 `json:"from"`
+	To      string                 `json:"to"`
+	Fields  map[string]interface{} `json:"state"` // the state at `from`, before any of the changes
+	Changes []Change               `json:"changes"`
+	Skipped *SkippedLines          `json:"skippedLines,omitempty"` // only when bad lines were skipped, see WithBadLinePolicy
+}
+
+// Change is a single change to the requested fields
+type Change struct {
+	Ts     string                 `json:"ts"`     // the changeTime, formatted as 2006-01-02T15:04:05.999999
+	Before map[string]interface{} `json:"before"` // the requested fields that this change has a "before" for
+	After  map[string]interface{} `json:"after"`  // the requested fields that this change has an "after" for
+	Fields map[string]interface{} `json:"state"`  // the state after this change
+}
+
+// pendingChange is a line of a day file that changed one of our fields
+type pendingChange struct {
+	changeTime time.Time
+	before     map[string]interface{}
+	after      map[string]interface{}
+}
+
+// getRange builds a timeline of every change to the fields after from, up to
+// and including to, along with the reconstructed state after each change. A
+// change at exactly from is already part of the state at from.
+func getRange(ctx context.Context, input getRangeInput) (output Timeline, err error) {
+	// check from and to inputs
+	fromTime, toTime := input.from, input.to
+	err = checkTimeRange(fromTime, toTime)
+	if err != nil {
+		return Timeline{}, err
+	}
+
+	// parse the fields, these can be paths into nested values
+	fields, err := parseFieldPaths(input.fields)
+	if err != nil {
+		err = withKind(ErrBadInput, fmt.Errorf("error parsing field: %w", err))
+		return Timeline{}, err
+	}
+
+	// the timeline starts from whatever the state was at `from`
+	badLines := newBadLines(input.badLinePolicy)
+	current, err := stateAt(ctx, stateAtInput{
+		fields:         fields,
+		dataSource:     input.dataSource,
+		layout:         input.layout,
+		readerFunc:     input.readerFunc,
+		checkpointFunc: input.checkpointFunc,
+		maxLookback:    input.maxLookback,
+		inputDateTime:  fromTime,
+		badLines:       badLines,
+	})
+	if err != nil {
+		return Timeline{}, err
+	}
+	output.Fields = snapshotState(current)
+	output.From = formatTime(fromTime, "2006-01-02T15:04:05")
+	output.To = formatTime(toTime, "2006-01-02T15:04:05")
+	output.Changes = []Change{}
+
+	err = walkChanges(ctx, walkChangesInput{
+		fields:     fields,
+		dataSource: input.dataSource,
+		layout:     input.layout,
+		readerFunc: input.readerFunc,
+		from:       fromTime,
+		to:         toTime,
+		badLines:   badLines,
+	}, func(change pendingChange) error {
+		for field, value := range change.after {
+			current[field] = mergePatch(current[field], value)
+		}
+		output.Changes = append(output.Changes, Change{
+			Ts:     formatTime(change.changeTime.In(fromTime.Location()), changeTimeLayout),
+			Before: change.before,
+			After:  change.after,
+			Fields: snapshotState(current),
+		})
+		return nil
+	})
+	if err != nil {
+		return Timeline{}, err
+	}
+	output.Skipped = badLines.summary()
+
+	return output, nil
+}
+
+type walkChangesInput struct {
+	fields     []fieldPath
+	dataSource string
+	layout     layout
+	readerFunc readerFunc
+	from       time.Time
+	to         time.Time
+	badLines   *badLines
+}
+
+// walkChanges calls onChange for every change to the fields after from, up to
+// and including to, in changeTime order. Only the changes from one day file are
+// held in memory at a time.
+func walkChanges(ctx context.Context, input walkChangesInput, onChange func(change pendingChange) error) error {
+	previous := ""
+	for partition := input.layout.partitionStart(input.from); !partition.After(input.to); partition = input.layout.addPartitions(partition, 1) {
+		path := input.layout.path(input.dataSource, partition)
+		if path == previous {
+			continue // <= the hour that is repeated when the clocks go back is in the same file
+		}
+		previous = path
+
+		changes, err := readChanges(ctx, path, input.layout.timeZone(), input.badLines, input.readerFunc, input.fields, input.from, input.to)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			err = onChange(change)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readChanges finds every line of the file at path that changed one of the
+// fields after from, up to and including to, sorted by changeTime.
+func readChanges(ctx context.Context, path string, location *time.Location, badLines *badLines, readerFunc readerFunc, fields []fieldPath, from time.Time, to time.Time) (output []pendingChange, err error) {
+	_, err = readDayFile(ctx, path, location, badLines, readerFunc, func(line dayFileLine) error {
+		if !line.changeTime.After(from) || line.changeTime.After(to) {
+			return nil
+		}
+
+		change := pendingChange{
+			changeTime: line.changeTime,
+			before:     make(map[string]interface{}),
+			after:      make(map[string]interface{}),
+		}
+		for _, field := range fields {
+			if value, found := field.lookup(line.data.Before); found {
+				change.before[field.raw] = value
+			}
+			if value, found := field.lookup(line.data.After); found {
+				change.after[field.raw] = value
+			}
+		}
+		if len(change.before) == 0 && len(change.after) == 0 {
+			return nil
+		}
+
+		output = append(output, change)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// day files are written in order, but nothing guarantees it
+	sort.SliceStable(output, func(i, j int) bool {
+		return output[i].changeTime.Before(output[j].changeTime)
+	})
+
+	return output, nil
+}
+
+// snapshotState copies a state so that later changes don't modify it, with
+// the nulls left over from merging partial objects removed
+func snapshotState(state map[string]interface{}) map[string]interface{} {
+	output := make(map[string]interface{}, len(state))
+	for field, value := range state {
+		output[field] = removeNulls(value)
+	}
+	return output
+}
+
+// checkTimeRange checks that from and to are set, and in the right order
+func checkTimeRange(from time.Time, to time.Time) error {
+	if from.IsZero() {
+		return withKind(ErrBadInput, errors.New("from was empty"))
+	}
+	if to.IsZero() {
+		return withKind(ErrBadInput, errors.New("to was empty"))
+	}
+	if to.Before(from) {
+		return withKind(ErrBadInput, errors.New("to must not be before from"))
+	}
+	return nil
+}
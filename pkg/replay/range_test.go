@@ -0,0 +1,139 @@
+package replay
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestGetRange(t *testing.T) {
+	files := map[string]string{
+		"/2016/01/01.jsonl.gz": `
+			{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}
+			{"changeTime": "2016-01-01T03:18:30.001950", "after": {"schedule": true}, "before": {"schedule": false}}
+			{"changeTime": "2016-01-01T03:02:30.001424", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 79.0}}
+			{"changeTime": "2016-01-01T03:24:30.001180", "after": {"setpoint": {"heatTemp": 67.0}}, "before": {"setpoint": {"heatTemp": 69.0}}}
+		`,
+		"/2016/01/02.jsonl.gz": `
+			{"changeTime": "2016-01-02T01:00:00.000001", "after": {"ambientTemp": 70.0}, "before": {"ambientTemp": 78.0}}
+			{"changeTime": "2016-01-02T05:00:00.000001", "after": {"ambientTemp": 71.0}, "before": {"ambientTemp": 70.0}}
+		`,
+	}
+
+	tdata := []struct {
+		testCase        string
+		input           getRangeInput
+		expectedOutput  Timeline
+		expectedAnError bool
+	}{
+		{
+			testCase:        "empty",
+			expectedAnError: true,
+		},
+		{
+			testCase: "to_before_from",
+			input: getRangeInput{
+				fields:     []string{"ambientTemp"},
+				from:       testTime("2016-01-02T00:00"),
+				to:         testTime("2016-01-01T00:00"),
+				readerFunc: filesReader(files),
+			},
+			expectedAnError: true,
+		},
+		{
+			testCase: "across_days__sorted_by_changeTime",
+			input: getRangeInput{
+				fields:     []string{"ambientTemp", "schedule"},
+				from:       testTime("2016-01-01T03:00"),
+				to:         testTime("2016-01-02T02:00"),
+				readerFunc: filesReader(files),
+			},
+			expectedOutput: Timeline{
+				From: "2016-01-01T03:00:00",
+				To:   "2016-01-02T02:00:00",
+				Fields: map[string]interface{}{
+					"ambientTemp": 79.0,
+					"schedule":    false,
+				},
+				Changes: []Change{
+					{
+						Ts:     "2016-01-01T03:02:30.001424",
+						Before: map[string]interface{}{"ambientTemp": 79.0},
+						After:  map[string]interface{}{"ambientTemp": 78.0},
+						Fields: map[string]interface{}{"ambientTemp": 78.0, "schedule": false},
+					},
+					{
+						Ts:     "2016-01-01T03:18:30.00195",
+						Before: map[string]interface{}{"schedule": false},
+						After:  map[string]interface{}{"schedule": true},
+						Fields: map[string]interface{}{"ambientTemp": 78.0, "schedule": true},
+					},
+					{
+						Ts:     "2016-01-02T01:00:00.000001",
+						Before: map[string]interface{}{"ambientTemp": 78.0},
+						After:  map[string]interface{}{"ambientTemp": 70.0},
+						Fields: map[string]interface{}{"ambientTemp": 70.0, "schedule": true},
+					},
+				},
+			},
+		},
+		{
+			testCase: "no_changes_in_window",
+			input: getRangeInput{
+				fields:     []string{"schedule"},
+				from:       testTime("2016-01-01T04:00"),
+				to:         testTime("2016-01-01T05:00"),
+				readerFunc: filesReader(files),
+			},
+			expectedOutput: Timeline{
+				From: "2016-01-01T04:00:00",
+				To:   "2016-01-01T05:00:00",
+				Fields: map[string]interface{}{
+					"schedule": true,
+				},
+				Changes: []Change{},
+			},
+		},
+		{
+			testCase: "nested_field",
+			input: getRangeInput{
+				fields:     []string{"setpoint.heatTemp"},
+				from:       testTime("2016-01-01T03:00"),
+				to:         testTime("2016-01-01T04:00"),
+				readerFunc: filesReader(files),
+			},
+			expectedOutput: Timeline{
+				From: "2016-01-01T03:00:00",
+				To:   "2016-01-01T04:00:00",
+				Fields: map[string]interface{}{
+					"setpoint.heatTemp": 69.0,
+				},
+				Changes: []Change{
+					{
+						Ts:     "2016-01-01T03:24:30.00118",
+						Before: map[string]interface{}{"setpoint.heatTemp": 69.0},
+						After:  map[string]interface{}{"setpoint.heatTemp": 67.0},
+						Fields: map[string]interface{}{"setpoint.heatTemp": 67.0},
+					},
+				},
+			},
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			output, err := getRange(context.Background(), test.input)
+
+			// assertions
+			if !reflect.DeepEqual(test.expectedOutput, output) {
+				t.Errorf("expected %+v to equal %+v", test.expectedOutput, output)
+			}
+			if test.expectedAnError && err == nil {
+				t.Error("expected an error, but there was none!")
+			}
+			if !test.expectedAnError && err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -1,86 +1,486 @@
 package replay
 
 import (
-	"bytes"
-	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/sirupsen/logrus"
 )
 
-type readerFunc func(path string) (output string, found bool, err error)
+// readerFunc opens the day file at path, which doesn't have an extension
+// (see layout.path), and returns a stream of its decompressed contents. When
+// path is a glob pattern that matches several files, the output is a
+// *dayFileChunks with a stream per file. The caller is responsible for
+// closing the output. The Client builds one from its Source with
+// sourceReader.
+type readerFunc func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error)
 
-func localReader(path string) (output string, found bool, err error) {
+// fileSource reads from the local filesystem, paths can start with `file://`
+type fileSource struct{}
+
+func (fileSource) Open(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+	path = strings.TrimPrefix(path, "file://")
 	fileObject, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
 	if err != nil {
 		err = fmt.Errorf("error reading file (%s): %w", path, err)
-		return "", false, err
+		return nil, false, err
 	}
-	defer fileObject.Close()
+	return fileObject, true, nil
+}
 
-	gzReader, err := gzip.NewReader(fileObject)
+func (fileSource) Stat(ctx context.Context, path string) (output FileInfo, found bool, err error) {
+	path = strings.TrimPrefix(path, "file://")
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return FileInfo{}, false, nil
+	}
 	if err != nil {
-		err = fmt.Errorf("error setting up gzip reader for file (%s): %w", path, err)
-		return "", false, err
+		err = fmt.Errorf("error reading file (%s): %w", path, err)
+		return FileInfo{}, false, err
 	}
+	return FileInfo{Size: info.Size(), ModTime: info.ModTime()}, true, nil
+}
 
-	buffer := new(bytes.Buffer)
-	buffer.ReadFrom(gzReader)
-	output = output + buffer.String()
-
-	logrus.Debug(output)
+// Write writes to a temp file next to path first, and then renames it into
+// place, so that a reader never sees a partial file. Missing dirs are created.
+func (fileSource) Write(ctx context.Context, path string, data []byte) error {
+	path = strings.TrimPrefix(path, "file://")
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		err = fmt.Errorf("error writing file (%s): %w", path, err)
+		return err
+	}
+	tempFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		err = fmt.Errorf("error writing file (%s): %w", path, err)
+		return err
+	}
+	_, err = tempFile.Write(data)
+	if err == nil {
+		err = tempFile.Chmod(0644) // <= temp files are only readable by their owner
+	}
+	closeErr := tempFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		err = fmt.Errorf("error writing file (%s): %w", path, err)
+		return err
+	}
+	return nil
+}
 
-	return output, true, nil
+func (fileSource) List(ctx context.Context, dir string) ([]string, error) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	infos, err := ioutil.ReadDir(strings.TrimPrefix(prefix, "file://"))
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("error reading dir (%s): %w", dir, err)
+		return nil, err
+	}
+	output := []string{}
+	for _, info := range infos {
+		if info.IsDir() {
+			output = append(output, prefix+info.Name()+"/")
+		} else {
+			output = append(output, prefix+info.Name())
+		}
+	}
+	return output, nil
 }
 
-func s3Reader(path string) (output string, found bool, err error) {
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String("us-east-1"), // the bucket is in us-east-1
-	})
+// defaultS3Region is used when no region is configured, since it is where
+// the bucket with the dev exercise data is
+const defaultS3Region = "us-east-1"
+
+// newS3Session sets up the one aws session that every s3 read shares
+func newS3Session(config S3Config) (*session.Session, error) {
+	options := session.Options{
+		Profile:           config.Profile,
+		SharedConfigState: session.SharedConfigEnable, // <= read the region from ~/.aws/config as well
+		Config: aws.Config{
+			S3ForcePathStyle: aws.Bool(config.PathStyle),
+		},
+	}
+	if config.Region != "" {
+		options.Config.Region = aws.String(config.Region)
+	}
+	if config.Endpoint != "" {
+		options.Config.Endpoint = aws.String(config.Endpoint)
+	}
+	if config.NoSignRequest {
+		options.Config.Credentials = credentials.AnonymousCredentials
+	}
+
+	sess, err := session.NewSessionWithOptions(options)
 	if err != nil {
 		err = fmt.Errorf("setting up aws session: %w", err)
-		return "", false, err
+		return nil, err
+	}
+	if aws.StringValue(sess.Config.Region) == "" {
+		sess.Config.Region = aws.String(defaultS3Region)
 	}
-	svc := s3.New(sess)
+	return sess, nil
+}
+
+// s3Source reads from s3 paths like `s3://bucket/key`. When cache isn't nil,
+// objects are downloaded into it once per ETag and read from the local
+// filesystem after that.
+type s3Source struct {
+	svc   *s3.S3
+	cache *diskCache
+}
+
+func newS3Source(sess *session.Session, cache *diskCache) s3Source {
+	return s3Source{svc: s3.New(sess), cache: cache}
+}
 
-	// format s3 inputs
-	path = strings.Replace(path, "s3://", "", 1)
-	pathSplit := strings.Split(path, "/")
+// splitS3Path splits an s3 path into its bucket and key
+func splitS3Path(path string) (bucket string, key string, err error) {
+	pathSplit := strings.SplitN(strings.TrimPrefix(path, "s3://"), "/", 2)
 	if len(pathSplit) < 2 {
 		err = fmt.Errorf("the file (%s) path was invalid", path)
-		return "", false, err
+		return "", "", err
+	}
+	return pathSplit[0], pathSplit[1], nil
+}
+
+func (s s3Source) Open(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+	bucket, key, err := splitS3Path(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if s.cache == nil {
+		output, found, err = getS3Object(ctx, s.svc, bucket, key, "")
+	} else {
+		output, found, err = getCachedS3Object(ctx, s.svc, s.cache, bucket, key)
+	}
+	if err != nil {
+		err = fmt.Errorf("error with s3 GetObject for path (%s): %w", path, err)
+		return nil, false, err
+	}
+	return output, found, nil
+}
+
+func (s s3Source) Stat(ctx context.Context, path string) (output FileInfo, found bool, err error) {
+	bucket, key, err := splitS3Path(path)
+	if err != nil {
+		return FileInfo{}, false, err
 	}
-	bucket := pathSplit[0]
-	key := strings.Join(pathSplit[1:], "/")
 
-	// get from s3
-	result, err := svc.GetObject(&s3.GetObjectInput{
+	head, err := s.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
 		Bucket: &bucket,
 		Key:    &key,
 	})
+	if isS3NotFound(err) {
+		return FileInfo{}, false, nil
+	}
 	if err != nil {
-		err = fmt.Errorf("error with s3 GetObject for path (%s): %w", path, err)
-		return "", false, err
+		err = fmt.Errorf("error with s3 HeadObject for path (%s): %w", path, err)
+		return FileInfo{}, false, err
+	}
+	return FileInfo{
+		Size:    aws.Int64Value(head.ContentLength),
+		ModTime: aws.TimeValue(head.LastModified),
+		ETag:    aws.StringValue(head.ETag),
+	}, true, nil
+}
+
+func (s s3Source) List(ctx context.Context, dir string) ([]string, error) {
+	bucket, key, err := splitS3Path(strings.TrimSuffix(dir, "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	output := []string{}
+	err = s.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    &bucket,
+		Prefix:    &key,
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, commonPrefix := range page.CommonPrefixes {
+			output = append(output, "s3://"+bucket+"/"+aws.StringValue(commonPrefix.Prefix))
+		}
+		for _, object := range page.Contents {
+			output = append(output, "s3://"+bucket+"/"+aws.StringValue(object.Key))
+		}
+		return true
+	})
+	if err != nil {
+		err = fmt.Errorf("error with s3 ListObjectsV2 for path (%s): %w", dir, err)
+		return nil, err
+	}
+	sort.Strings(output)
+	return output, nil
+}
+
+// getS3Object streams an object from s3, only if it has the given ETag when
+// ifMatch isn't empty
+func getS3Object(ctx context.Context, svc *s3.S3, bucket string, key string, ifMatch string) (output io.ReadCloser, found bool, err error) {
+	input := &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	}
+	if ifMatch != "" {
+		input.IfMatch = &ifMatch
 	}
+	result, err := svc.GetObjectWithContext(ctx, input)
+	if isS3NotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return result.Body, true, nil
+}
+
+// getCachedS3Object looks up the current ETag of an object, and reads that
+// version of it from the cache, downloading it into the cache first if needed
+func getCachedS3Object(ctx context.Context, svc *s3.S3, cache *diskCache, bucket string, key string) (output io.ReadCloser, found bool, err error) {
+	head, err := svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if isS3NotFound(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	eTag := aws.StringValue(head.ETag)
+	if eTag == "" {
+		return getS3Object(ctx, svc, bucket, key, "")
+	}
+
+	entryKey := cacheKey("s3", bucket, key, eTag)
+	output, found, err = cache.open(entryKey)
+	if err != nil {
+		return nil, false, err
+	}
+	if found {
+		logrus.Debugf("reading s3://%s/%s from the cache", bucket, key)
+		return output, true, nil
+	}
+
+	body, found, err := getS3Object(ctx, svc, bucket, key, eTag)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	err = cache.store(entryKey, body)
+	body.Close()
+	if err != nil {
+		// a cache that can't be written to shouldn't stop the read
+		logrus.Warnf("error caching s3://%s/%s: %s", bucket, key, err)
+		return getS3Object(ctx, svc, bucket, key, "")
+	}
+	output, found, err = cache.open(entryKey)
+	if err != nil || found {
+		return output, found, err
+	}
+	// another process evicted the entry since it was stored
+	logrus.Debugf("s3://%s/%s was evicted from the cache before it was read", bucket, key)
+	return getS3Object(ctx, svc, bucket, key, "")
+}
+
+// httpSource reads from urls like
+// `https://example.com/audit-data/2016/01/01.jsonl.gz`. When cache isn't
+// nil, files are kept in it along with their ETag, and are only downloaded
+// again when the server says that they've changed.
+type httpSource struct {
+	httpClient *http.Client
+	config     HTTPConfig
+	cache      *diskCache
+}
+
+func newHTTPSourceFactory(config SourceConfig) (Source, error) {
+	if config.HTTP.BearerToken != "" && config.HTTP.Username != "" {
+		err := withKind(ErrBadInput, errors.New("only one of a bearer token or a username can be used"))
+		return nil, err
+	}
+	return newHTTPSource(config.HTTP, config.cache()), nil
+}
+
+func newHTTPSource(config HTTPConfig, cache *diskCache) httpSource {
+	return httpSource{
+		httpClient: &http.Client{Timeout: config.Timeout},
+		config:     config,
+		cache:      cache,
+	}
+}
+
+func (h httpSource) Open(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+	if h.cache == nil {
+		output, found, _, err = h.get(ctx, path, "")
+	} else {
+		output, found, err = h.getCached(ctx, path)
+	}
+	if err != nil {
+		err = fmt.Errorf("error with http GET for url (%s): %w", path, err)
+		return nil, false, err
+	}
+	return output, found, nil
+}
+
+func (h httpSource) Stat(ctx context.Context, path string) (output FileInfo, found bool, err error) {
+	response, err := h.do(ctx, http.MethodHead, path, "")
+	if err != nil {
+		err = fmt.Errorf("error with http HEAD for url (%s): %w", path, err)
+		return FileInfo{}, false, err
+	}
+	response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return FileInfo{}, false, nil
+	default:
+		err = fmt.Errorf("error with http HEAD for url (%s): unexpected status (%s)", path, response.Status)
+		return FileInfo{}, false, err
+	}
+
+	output = FileInfo{Size: response.ContentLength, ETag: response.Header.Get("ETag")}
+	output.ModTime, _ = http.ParseTime(response.Header.Get("Last-Modified")) // <= zero when the server doesn't say
+	return output, true, nil
+}
+
+func (h httpSource) List(ctx context.Context, dir string) ([]string, error) {
+	return nil, ErrListNotSupported
+}
 
-	gzReader, err := gzip.NewReader(result.Body)
+// do sends a request with the auth headers from the config
+func (h httpSource) do(ctx context.Context, method string, url string, ifNoneMatch string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
-		err = fmt.Errorf("error setting up gzip reader for file (%s): %w", path, err)
-		return "", false, err
+		return nil, err
 	}
+	switch {
+	case h.config.BearerToken != "":
+		request.Header.Set("Authorization", "Bearer "+h.config.BearerToken)
+	case h.config.Username != "":
+		request.SetBasicAuth(h.config.Username, h.config.Password)
+	}
+	if ifNoneMatch != "" {
+		request.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	return h.httpClient.Do(request)
+}
 
-	buffer := new(bytes.Buffer)
-	buffer.ReadFrom(gzReader)
-	output = output + buffer.String()
+// get streams a file from an http server. When ifNoneMatch isn't empty and
+// the file still has that ETag, the output is nil and found is true, since
+// there's nothing new to read.
+func (h httpSource) get(ctx context.Context, url string, ifNoneMatch string) (output io.ReadCloser, found bool, eTag string, err error) {
+	response, err := h.do(ctx, http.MethodGet, url, ifNoneMatch)
+	if err != nil {
+		return nil, false, "", err
+	}
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		response.Body.Close()
+		return nil, false, "", nil
+	case response.StatusCode == http.StatusNotModified && ifNoneMatch != "":
+		response.Body.Close()
+		return nil, true, ifNoneMatch, nil
+	case response.StatusCode != http.StatusOK:
+		response.Body.Close()
+		err = fmt.Errorf("unexpected status (%s)", response.Status)
+		return nil, false, "", err
+	}
+	return response.Body, true, response.Header.Get("ETag"), nil
+}
 
-	logrus.Debug(output)
+// getCached reads a file from the cache when the server says that
+// its ETag hasn't changed, downloading it into the cache otherwise. The last
+// ETag of each url is kept in the cache as well.
+func (h httpSource) getCached(ctx context.Context, url string) (output io.ReadCloser, found bool, err error) {
+	eTagKey := cacheKey("http", url)
+	lastETag := ""
+	eTagFile, found, err := h.cache.open(eTagKey)
+	if err != nil {
+		return nil, false, err
+	}
+	if found {
+		eTagBytes, err := ioutil.ReadAll(eTagFile)
+		eTagFile.Close()
+		if err != nil {
+			return nil, false, err
+		}
+		lastETag = string(eTagBytes)
+	}
 
-	// TODO: cache to local filesystem
+	body, found, eTag, err := h.get(ctx, url, lastETag)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	if body == nil {
+		output, found, err = h.cache.open(cacheKey("http", url, eTag))
+		if err != nil || found {
+			logrus.Debugf("reading %s from the cache", url)
+			return output, found, err
+		}
+		// the file was evicted since its ETag was stored
+		body, found, eTag, err = h.get(ctx, url, "")
+		if err != nil || !found {
+			return nil, found, err
+		}
+	}
+	if eTag == "" {
+		return body, true, nil
+	}
 
+	// the entry is opened before the ETag is stored, since storing the ETag
+	// can evict it
+	entryKey := cacheKey("http", url, eTag)
+	err = h.cache.store(entryKey, body)
+	body.Close()
+	if err == nil {
+		output, found, err = h.cache.open(entryKey)
+	}
+	if err == nil && found {
+		err = h.cache.store(eTagKey, strings.NewReader(eTag))
+		if err != nil {
+			output.Close()
+		}
+	}
+	if err != nil {
+		// a cache that can't be written to shouldn't stop the read
+		logrus.Warnf("error caching %s: %s", url, err)
+		body, found, _, err = h.get(ctx, url, "")
+		return body, found, err
+	}
+	if !found {
+		// another process evicted the entry since it was stored
+		logrus.Debugf("%s was evicted from the cache before it was read", url)
+		body, found, _, err = h.get(ctx, url, "")
+		return body, found, err
+	}
 	return output, true, nil
 }
+
+// isS3NotFound checks for the errors s3 returns for a missing key, which are
+// different for GetObject and HeadObject
+func isS3NotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound")
+}
@@ -0,0 +1,278 @@
+package replay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeS3 serves objects the way an s3 compatible store like MinIO does, with
+// path style urls and no authentication, counting the GetObject calls
+func fakeS3(objects map[string]string, gets *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contents, ok := objects[r.URL.Path]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+			}
+			return
+		}
+
+		compressed := bytes.Buffer{}
+		gzWriter := gzip.NewWriter(&compressed)
+		gzWriter.Write([]byte(contents))
+		gzWriter.Close()
+
+		w.Header().Set("ETag", `"`+cacheKey(contents)+`"`)
+		if r.Method == http.MethodGet {
+			atomic.AddInt32(gets, 1)
+			w.Write(compressed.Bytes())
+		}
+	}))
+}
+
+func TestS3Reader(t *testing.T) {
+	var gets int32
+	server := fakeS3(map[string]string{
+		"/bucket/2016/01/01.jsonl.gz": `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`,
+	}, &gets)
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "replay-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	sess, err := newS3Session(S3Config{
+		Endpoint:      server.URL,
+		PathStyle:     true,
+		NoSignRequest: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tdata := []struct {
+		testCase       string
+		cache          *diskCache
+		path           string
+		expectedFound  bool
+		expectedOutput string
+		expectedGets   int32
+	}{
+		{
+			testCase:       "no_cache",
+			path:           "s3://bucket/2016/01/01",
+			expectedFound:  true,
+			expectedOutput: `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`,
+			expectedGets:   1,
+		},
+		{
+			testCase:       "cache_miss",
+			cache:          newDiskCache(cacheDir, DefaultCacheMaxBytes),
+			path:           "s3://bucket/2016/01/01",
+			expectedFound:  true,
+			expectedOutput: `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`,
+			expectedGets:   1,
+		},
+		{
+			testCase:       "cache_hit",
+			cache:          newDiskCache(cacheDir, DefaultCacheMaxBytes),
+			path:           "s3://bucket/2016/01/01",
+			expectedFound:  true,
+			expectedOutput: `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`,
+			expectedGets:   0,
+		},
+		{
+			testCase:       "larger_than_the_cache",
+			cache:          newDiskCache(filepath.Join(cacheDir, "small"), 10),
+			path:           "s3://bucket/2016/01/01",
+			expectedFound:  true,
+			expectedOutput: `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`,
+			expectedGets:   1,
+		},
+		{
+			testCase:      "not_found",
+			path:          "s3://bucket/2016/01/02",
+			expectedFound: false,
+		},
+		{
+			testCase:      "not_found_with_cache",
+			cache:         newDiskCache(cacheDir, DefaultCacheMaxBytes),
+			path:          "s3://bucket/2016/01/02",
+			expectedFound: false,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			atomic.StoreInt32(&gets, 0)
+
+			// logic under test
+			output, found, err := sourceReader(newS3Source(sess, test.cache), "", DefaultExtensions)(context.Background(), test.path)
+
+			// assertions
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != test.expectedFound {
+				t.Fatalf("expected found to be %v", test.expectedFound)
+			}
+			if found {
+				defer output.Close()
+				contents, err := ioutil.ReadAll(output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(contents) != test.expectedOutput {
+					t.Errorf("expected %q to equal %q", contents, test.expectedOutput)
+				}
+			}
+			if gets := atomic.LoadInt32(&gets); gets != test.expectedGets {
+				t.Errorf("expected %d GetObject calls, but there were %d", test.expectedGets, gets)
+			}
+		})
+	}
+}
+
+// fakeFileServer serves gzipped files that require a bearer token, and
+// supports conditional requests, counting the responses with a body
+func fakeFileServer(files map[string]string, downloads *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		contents, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		eTag := `"` + cacheKey(contents) + `"`
+		if r.Header.Get("If-None-Match") == eTag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		compressed := bytes.Buffer{}
+		gzWriter := gzip.NewWriter(&compressed)
+		gzWriter.Write([]byte(contents))
+		gzWriter.Close()
+
+		atomic.AddInt32(downloads, 1)
+		w.Header().Set("ETag", eTag)
+		w.Write(compressed.Bytes())
+	}))
+}
+
+func TestHTTPReader(t *testing.T) {
+	var downloads int32
+	server := fakeFileServer(map[string]string{
+		"/audit-data/2016/01/01.jsonl.gz": `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`,
+	}, &downloads)
+	defer server.Close()
+
+	cacheDir, err := ioutil.TempDir("", "replay-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	tdata := []struct {
+		testCase          string
+		config            HTTPConfig
+		cache             *diskCache
+		path              string
+		expectedFound     bool
+		expectedError     bool
+		expectedOutput    string
+		expectedDownloads int32
+	}{
+		{
+			testCase:          "no_cache",
+			config:            HTTPConfig{BearerToken: "secret"},
+			path:              server.URL + "/audit-data/2016/01/01",
+			expectedFound:     true,
+			expectedOutput:    `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`,
+			expectedDownloads: 1,
+		},
+		{
+			testCase:          "cache_miss",
+			config:            HTTPConfig{BearerToken: "secret"},
+			cache:             newDiskCache(cacheDir, DefaultCacheMaxBytes),
+			path:              server.URL + "/audit-data/2016/01/01",
+			expectedFound:     true,
+			expectedOutput:    `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`,
+			expectedDownloads: 1,
+		},
+		{
+			testCase:          "not_modified",
+			config:            HTTPConfig{BearerToken: "secret"},
+			cache:             newDiskCache(cacheDir, DefaultCacheMaxBytes),
+			path:              server.URL + "/audit-data/2016/01/01",
+			expectedFound:     true,
+			expectedOutput:    `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`,
+			expectedDownloads: 0,
+		},
+		{
+			testCase:          "larger_than_the_cache",
+			config:            HTTPConfig{BearerToken: "secret"},
+			cache:             newDiskCache(filepath.Join(cacheDir, "small"), 10),
+			path:              server.URL + "/audit-data/2016/01/01",
+			expectedFound:     true,
+			expectedOutput:    `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`,
+			expectedDownloads: 1,
+		},
+		{
+			testCase:      "not_found",
+			config:        HTTPConfig{BearerToken: "secret"},
+			path:          server.URL + "/audit-data/2016/01/02",
+			expectedFound: false,
+		},
+		{
+			testCase:      "unauthorized",
+			config:        HTTPConfig{Username: "user", Password: "password"},
+			path:          server.URL + "/audit-data/2016/01/01",
+			expectedError: true,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			atomic.StoreInt32(&downloads, 0)
+
+			// logic under test
+			output, found, err := sourceReader(newHTTPSource(test.config, test.cache), "", DefaultExtensions)(context.Background(), test.path)
+
+			// assertions
+			if test.expectedError != (err != nil) {
+				t.Fatalf("expected an error: %v, but the error was: %v", test.expectedError, err)
+			}
+			if found != test.expectedFound {
+				t.Fatalf("expected found to be %v", test.expectedFound)
+			}
+			if found {
+				defer output.Close()
+				contents, err := ioutil.ReadAll(output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(contents) != test.expectedOutput {
+					t.Errorf("expected %q to equal %q", contents, test.expectedOutput)
+				}
+			}
+			if downloads := atomic.LoadInt32(&downloads); downloads != test.expectedDownloads {
+				t.Errorf("expected %d downloads, but there were %d", test.expectedDownloads, downloads)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package replay
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetSample(t *testing.T) {
+	files := map[string]string{
+		"/2016/01/01.jsonl.gz": `
+			{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}
+			{"changeTime": "2016-01-01T23:30:00.000000", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 79.0}}
+			{"changeTime": "2016-01-01T23:45:00.000000", "after": {"schedule": true}, "before": {"schedule": false}}
+		`,
+		"/2016/01/02.jsonl.gz": `
+			{"changeTime": "2016-01-02T00:00:00.000000", "after": {"ambientTemp": 70.0}, "before": {"ambientTemp": 78.0}}
+			{"changeTime": "2016-01-02T00:10:00.000000", "after": {"ambientTemp": 71.0}, "before": {"ambientTemp": 70.0}}
+		`,
+	}
+
+	tdata := []struct {
+		testCase        string
+		input           getSampleInput
+		expectedOutput  Samples
+		expectedAnError bool
+	}{
+		{
+			testCase:        "empty",
+			expectedAnError: true,
+		},
+		{
+			testCase: "every_is_zero",
+			input: getSampleInput{
+				fields:     []string{"ambientTemp"},
+				from:       testTime("2016-01-01T23:00"),
+				to:         testTime("2016-01-02T01:00"),
+				readerFunc: filesReader(files),
+			},
+			expectedAnError: true,
+		},
+		{
+			testCase: "too_many_samples",
+			input: getSampleInput{
+				fields:     []string{"ambientTemp"},
+				from:       testTime("2016-01-01T23:00"),
+				to:         testTime("2016-01-02T01:00"),
+				every:      time.Nanosecond,
+				readerFunc: filesReader(files),
+			},
+			expectedAnError: true,
+		},
+		{
+			testCase: "across_midnight",
+			input: getSampleInput{
+				fields:     []string{"ambientTemp", "schedule"},
+				from:       testTime("2016-01-01T23:00"),
+				to:         testTime("2016-01-02T00:30"),
+				every:      30 * time.Minute,
+				readerFunc: filesReader(files),
+			},
+			expectedOutput: Samples{
+				From:  "2016-01-01T23:00:00",
+				To:    "2016-01-02T00:30:00",
+				Every: "30m0s",
+				Samples: []State{
+					{
+						Fields: map[string]interface{}{"ambientTemp": 79.0, "schedule": false},
+						Ts:     "2016-01-01T23:00:00",
+					},
+					{
+						// the change at exactly this tick is already included
+						Fields: map[string]interface{}{"ambientTemp": 78.0, "schedule": false},
+						Ts:     "2016-01-01T23:30:00",
+					},
+					{
+						Fields: map[string]interface{}{"ambientTemp": 70.0, "schedule": true},
+						Ts:     "2016-01-02T00:00:00",
+					},
+					{
+						Fields: map[string]interface{}{"ambientTemp": 71.0, "schedule": true},
+						Ts:     "2016-01-02T00:30:00",
+					},
+				},
+			},
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			output, err := getSample(context.Background(), test.input)
+
+			// assertions
+			if !reflect.DeepEqual(test.expectedOutput, output) {
+				t.Errorf("expected %+v to equal %+v", test.expectedOutput, output)
+			}
+			if test.expectedAnError && err == nil {
+				t.Error("expected an error, but there was none!")
+			}
+			if !test.expectedAnError && err != nil {
+				t.Error(err)
+			}
+
+			// every sample should be the same as asking for that point in time directly
+			for _, sample := range output.Samples {
+				state, err := getState(context.Background(), getStateInput{
+					fields:      test.input.fields,
+					dateTime:    testTime(sample.Ts),
+					readerFunc:  test.input.readerFunc,
+					maxLookback: 1,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(state, sample) {
+					t.Errorf("expected sample %+v to equal getState %+v", sample, state)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type errorOutput struct {
+	Error string `json:"error"`
+}
+
+// newServer returns the HTTP API for a Client. Each endpoint takes the same
+// inputs as the matching CLI command as query parameters, and returns the
+// same JSON. dateTimes without a timezone are in location, and so is the
+// output, unless the request has a `tz` query parameter with the IANA name of
+// another zone.
+//
+//	GET /state?field=ambientTemp&field=schedule&at=2016-01-01T03:00
+//	GET /range?field=ambientTemp&from=2016-01-01T02:00&to=2016-01-01T04:00
+//	GET /sample?field=ambientTemp&every=5m&from=2016-01-01T00:00&to=2016-01-02T00:00
+//	GET /diff?field=ambientTemp&from=2016-01-01T02:00&to=2016-01-01T03:00
+//	GET /state?field=ambientTemp&at=2016-01-01T03:00&tz=America/New_York
+func newServer(client *Client, location *time.Location) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/state", handler(func(r *http.Request) (interface{}, error) {
+		at, err := queryTime(r, "at", location)
+		if err != nil {
+			return nil, err
+		}
+		return client.StateAt(r.Context(), at, r.URL.Query()["field"])
+	}))
+
+	mux.HandleFunc("/range", handler(func(r *http.Request) (interface{}, error) {
+		from, err := queryTime(r, "from", location)
+		if err != nil {
+			return nil, err
+		}
+		to, err := queryTime(r, "to", location)
+		if err != nil {
+			return nil, err
+		}
+		return client.Range(r.Context(), from, to, r.URL.Query()["field"])
+	}))
+
+	mux.HandleFunc("/sample", handler(func(r *http.Request) (interface{}, error) {
+		from, err := queryTime(r, "from", location)
+		if err != nil {
+			return nil, err
+		}
+		to, err := queryTime(r, "to", location)
+		if err != nil {
+			return nil, err
+		}
+		every, err := time.ParseDuration(r.URL.Query().Get("every"))
+		if err != nil {
+			err = withKind(ErrBadInput, fmt.Errorf("error parsing every: %w", err))
+			return nil, err
+		}
+		return client.Sample(r.Context(), from, to, every, r.URL.Query()["field"])
+	}))
+
+	mux.HandleFunc("/diff", handler(func(r *http.Request) (interface{}, error) {
+		from, err := queryTime(r, "from", location)
+		if err != nil {
+			return nil, err
+		}
+		to, err := queryTime(r, "to", location)
+		if err != nil {
+			return nil, err
+		}
+		return client.Diff(r.Context(), from, to, r.URL.Query()["field"])
+	}))
+
+	return mux
+}
+
+// queryTime parses the dateTime in a query parameter, in the zone of the `tz`
+// query parameter or else in location
+func queryTime(r *http.Request, name string, location *time.Location) (time.Time, error) {
+	if r.URL.Query().Get("tz") != "" {
+		var err error
+		location, err = LoadTimeZone(r.URL.Query().Get("tz"))
+		if err != nil {
+			err = fmt.Errorf("error parsing tz: %w", err)
+			return time.Time{}, err
+		}
+	}
+	output, err := ParseTimeIn(r.URL.Query().Get(name), location)
+	if err != nil {
+		err = fmt.Errorf("error parsing %s: %w", name, err)
+		return time.Time{}, err
+	}
+	return output, nil
+}
+
+// handler turns a controller call into an http.HandlerFunc, writing either
+// its output or its error as JSON
+func handler(controller func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSON(w, http.StatusMethodNotAllowed, errorOutput{Error: fmt.Sprintf("the method %s is not allowed", r.Method)})
+			return
+		}
+
+		output, err := controller(r)
+		if err != nil {
+			status := errorStatus(err)
+			logrus.Debugf("%s %s => %d: %s", r.Method, r.URL, status, err)
+			writeJSON(w, status, errorOutput{Error: err.Error()})
+			return
+		}
+
+		logrus.Debugf("%s %s => %d", r.Method, r.URL, http.StatusOK)
+		writeJSON(w, http.StatusOK, output)
+	}
+}
+
+// errorStatus maps the kinds of errors from the controller to status codes
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrBadInput):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrDataError):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, output interface{}) {
+	jsonOutput, err := json.Marshal(output)
+	if err != nil {
+		logrus.Errorf("error with json.Marshal: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonOutput)
+}
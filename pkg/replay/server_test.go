@@ -0,0 +1,132 @@
+package replay
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	server := httptest.NewServer(newServer(&Client{
+		readerFunc: filesReader(map[string]string{
+			"/2016/01/01.jsonl.gz": `
+				{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}
+				{"changeTime": "2016-01-01T03:18:30.001950", "after": {"schedule": true}, "before": {"schedule": false}}
+				{"changeTime": "2016-01-01T03:24:30.001180", "after": {"ambientTemp": 80.0}, "before": {"ambientTemp": 79.0}}
+				{"changeTime": "2016-01-01T04:00:00.000000", "after": {"fan": "on"}, "before": {"fan": "off"}}
+				{"changeTime": "2016-01-01T05:00:00.000000", "after": {"fan": "auto"}, "before": {"fan": "off"}}
+			`,
+		}),
+	}, time.UTC))
+	defer server.Close()
+
+	tdata := []struct {
+		testCase       string
+		method         string
+		url            string
+		expectedStatus int
+		expectedOutput map[string]interface{}
+	}{
+		{
+			testCase:       "state",
+			url:            "/state?field=ambientTemp&field=schedule&at=2016-01-01T03:00",
+			expectedStatus: http.StatusOK,
+			expectedOutput: map[string]interface{}{
+				"state": map[string]interface{}{"ambientTemp": 79.0, "schedule": false},
+				"ts":    "2016-01-01T03:00:00",
+			},
+		},
+		{
+			testCase:       "tz",
+			url:            "/state?field=ambientTemp&at=2015-12-31T22:00&tz=America/New_York",
+			expectedStatus: http.StatusOK,
+			expectedOutput: map[string]interface{}{
+				"state": map[string]interface{}{"ambientTemp": 79.0},
+				"ts":    "2015-12-31T22:00:00-05:00",
+			},
+		},
+		{
+			testCase:       "bad_tz",
+			url:            "/state?field=ambientTemp&at=2016-01-01T03:00&tz=Mars/Olympus_Mons",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			testCase:       "missing_day_file",
+			url:            "/state?field=ambientTemp&at=2017-01-01T03:00",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			testCase:       "bad_time",
+			url:            "/state?field=ambientTemp&at=yesterday-ish",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			testCase:       "bad_field",
+			url:            "/state?field=setpoint..heatTemp&at=2016-01-01T03:00",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			testCase:       "no_fields",
+			url:            "/state?at=2016-01-01T03:00",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			testCase:       "data_error",
+			url:            "/state?field=fan&at=2016-01-01T04:30",
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			testCase:       "bad_every",
+			url:            "/sample?field=ambientTemp&every=often&from=2016-01-01T00:00&to=2016-01-01T02:00",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			testCase:       "method_not_allowed",
+			method:         http.MethodPost,
+			url:            "/state?field=ambientTemp&at=2016-01-01T03:00",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			testCase:       "unknown_endpoint",
+			url:            "/nope",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			request, err := http.NewRequest(method, server.URL+test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// logic under test
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer response.Body.Close()
+
+			// assertions
+			if test.expectedStatus != response.StatusCode {
+				t.Errorf("expected status %d to equal %d", test.expectedStatus, response.StatusCode)
+			}
+			if test.expectedOutput != nil {
+				var output map[string]interface{}
+				err = json.NewDecoder(response.Body).Decode(&output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(test.expectedOutput, output) {
+					t.Errorf("expected %+v to equal %+v", test.expectedOutput, output)
+				}
+			}
+		})
+	}
+}
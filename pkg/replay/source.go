@@ -0,0 +1,444 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Source is a place that day files are read from, like a local directory or
+// an s3 bucket. Paths are the full path to a file, starting with the data
+// source that was passed to NewClient, ex: `s3://bucket/prefix/2016/01/01.jsonl.gz`.
+//
+// A Source must be safe for concurrent use.
+type Source interface {
+	// Open returns a stream of the raw contents of the file at path, which the
+	// caller is responsible for closing. found is false when the file doesn't
+	// exist, which isn't an error.
+	Open(ctx context.Context, path string) (output io.ReadCloser, found bool, err error)
+
+	// Stat describes the file at path, found is false when it doesn't exist
+	Stat(ctx context.Context, path string) (output FileInfo, found bool, err error)
+
+	// List returns the sorted paths of the files and directories directly
+	// inside of dir. The paths of directories end with a `/`. Sources that
+	// can't be listed return an error that matches ErrListNotSupported.
+	List(ctx context.Context, dir string) ([]string, error)
+}
+
+// WritableSource is a Source that files can be written to, which is needed
+// for writing indexes next to day files (see Client.Index)
+type WritableSource interface {
+	Source
+
+	// Write creates or replaces the file at path
+	Write(ctx context.Context, path string, data []byte) error
+}
+
+// FileInfo describes a file in a Source
+type FileInfo struct {
+	Size    int64
+	ModTime time.Time
+	ETag    string // empty when the Source doesn't have ETags
+}
+
+// ErrListNotSupported is returned by the List method of sources that can't
+// be listed, like a plain http server
+var ErrListNotSupported = errors.New("listing files is not supported by this source")
+
+// SourceConfig is the settings that a SourceFactory can use to create a
+// Source. Each of the built in sources only uses the settings for itself.
+type SourceConfig struct {
+	S3            S3Config
+	HTTP          HTTPConfig
+	CacheDir      string // where to cache remote files, not cached when empty
+	CacheMaxBytes int64
+}
+
+// SourceFactory creates a Source for a Client
+type SourceFactory func(config SourceConfig) (Source, error)
+
+// sourceFactories is the registry of sources, keyed by the scheme of the data
+// sources they read, ex: `s3` for `s3://bucket/prefix`. Data sources without
+// a scheme are local paths, and use the `file` source.
+var (
+	sourceFactoriesMutex sync.RWMutex
+	sourceFactories      = map[string]SourceFactory{
+		"file": func(config SourceConfig) (Source, error) {
+			return fileSource{}, nil
+		},
+		"s3": func(config SourceConfig) (Source, error) {
+			sess, err := newS3Session(config.S3)
+			if err != nil {
+				return nil, err
+			}
+			return newS3Source(sess, config.cache()), nil
+		},
+		"http":  newHTTPSourceFactory,
+		"https": newHTTPSourceFactory,
+		"mem": func(config SourceConfig) (Source, error) {
+			return DefaultMemSource, nil
+		},
+	}
+)
+
+// RegisterSource adds a Source to the registry for a scheme, replacing the
+// Source that was there before. Every Client created after this reads data
+// sources that start with `{scheme}://` from the Source that factory
+// creates. Use WithSource instead to change the Source of a single Client.
+func RegisterSource(scheme string, factory SourceFactory) {
+	sourceFactoriesMutex.Lock()
+	defer sourceFactoriesMutex.Unlock()
+	sourceFactories[scheme] = factory
+}
+
+// sourceScheme returns the scheme of a data source, ex: `s3` for
+// `s3://bucket/prefix`, and `file` for a local path
+func sourceScheme(dataSource string) string {
+	index := strings.Index(dataSource, "://")
+	if index < 0 {
+		return "file"
+	}
+	return dataSource[:index]
+}
+
+// newSource creates the Source for a data source, using the injected sources
+// before the ones in the registry
+func newSource(dataSource string, config SourceConfig, injected map[string]Source) (Source, error) {
+	scheme := sourceScheme(dataSource)
+	if source, ok := injected[scheme]; ok {
+		return source, nil
+	}
+
+	sourceFactoriesMutex.RLock()
+	factory, ok := sourceFactories[scheme]
+	sourceFactoriesMutex.RUnlock()
+	if !ok {
+		err := withKind(ErrBadInput, fmt.Errorf("there is no source for the scheme (%s) of the dataSource (%s)", scheme, dataSource))
+		return nil, err
+	}
+
+	source, err := factory(config)
+	if err != nil {
+		err = fmt.Errorf("error setting up the %s source: %w", scheme, err)
+		return nil, err
+	}
+	return source, nil
+}
+
+// cache returns the cache for the config, or nil when caching is off
+func (config SourceConfig) cache() *diskCache {
+	if config.CacheDir == "" {
+		return nil
+	}
+	return newDiskCache(config.CacheDir, config.CacheMaxBytes)
+}
+
+// sourceReader turns a Source into a readerFunc, which looks for the day
+// file with each of the extensions, and decompresses it. Paths that already
+// end with one of the extensions are read as is. Paths with glob patterns
+// (see path.Match) after the dataSource read every matching file, as a
+// dayFileChunks. Day files in a WritableSource that have a fresh index (see
+// Client.Index) are read as an indexedDayFile.
+func sourceReader(source Source, dataSource string, extensions []string) readerFunc {
+	_, writable := source.(WritableSource)
+	return func(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+		candidates := candidateExtensions(path, extensions)
+		if prefix, pattern := splitPattern(dataSource, path); hasGlob(pattern) {
+			return readGlob(ctx, source, prefix, pattern, candidates)
+		}
+		for _, extension := range candidates {
+			raw, found, err := source.Open(ctx, path+extension)
+			if err != nil {
+				return nil, false, err
+			}
+			if !found {
+				continue
+			}
+			output, err = decompress(raw, path+extension)
+			if err != nil {
+				return nil, false, err
+			}
+
+			// indexes can only be written to sources that can be written
+			// to, so they're only looked for there
+			if !writable {
+				return &namedDayFile{ReadCloser: output, path: path + extension}, true, nil
+			}
+			index, found, err := openIndex(ctx, source, path+extension)
+			if err != nil {
+				logrus.Warnf("the index of %s was ignored: %s", path+extension, err)
+			}
+			if err != nil || !found {
+				return &namedDayFile{ReadCloser: output, path: path + extension}, true, nil
+			}
+			return &indexedDayFile{ReadCloser: output, path: path + extension, index: index}, true, nil
+		}
+		return nil, false, nil
+	}
+}
+
+// candidateExtensions returns the extensions that the file at path can
+// have, which is none when it already ends with one of them
+func candidateExtensions(path string, extensions []string) []string {
+	for _, extension := range extensions {
+		if strings.HasSuffix(path, extension) {
+			return []string{""}
+		}
+	}
+	return extensions
+}
+
+// partitionFiles returns the paths of the files for the partition at path,
+// which is every file that matches a glob pattern after the dataSource, or
+// the first extension that exists otherwise
+func partitionFiles(ctx context.Context, source Source, dataSource string, path string, extensions []string) ([]string, error) {
+	candidates := candidateExtensions(path, extensions)
+	if prefix, pattern := splitPattern(dataSource, path); hasGlob(pattern) {
+		paths, err := globFiles(ctx, source, prefix, pattern, candidates)
+		if err != nil {
+			err = fmt.Errorf("error listing the files for the pattern (%s): %w", path, err)
+			return nil, err
+		}
+		return paths, nil
+	}
+	for _, extension := range candidates {
+		_, found, err := source.Stat(ctx, path+extension)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return []string{path + extension}, nil
+		}
+	}
+	return nil, nil
+}
+
+// readGlob opens every file in prefix that matches pattern with any of the
+// extensions, as a dayFileChunks in the order of their paths
+func readGlob(ctx context.Context, source Source, prefix string, pattern string, extensions []string) (io.ReadCloser, bool, error) {
+	paths, err := globFiles(ctx, source, prefix, pattern, extensions)
+	if err != nil {
+		err = fmt.Errorf("error listing the files for the pattern (%s): %w", prefix+pattern, err)
+		return nil, false, err
+	}
+
+	output := &dayFileChunks{}
+	for _, path := range paths {
+		raw, found, err := source.Open(ctx, path)
+		if err == nil && !found {
+			continue // <= removed since it was listed
+		}
+		if err == nil {
+			raw, err = decompress(raw, path)
+		}
+		if err != nil {
+			output.Close()
+			return nil, false, err
+		}
+		output.chunks = append(output.chunks, dayFileChunk{path: path, ReadCloser: raw})
+	}
+	if len(output.chunks) == 0 {
+		return nil, false, nil
+	}
+	return output, true, nil
+}
+
+// globFiles lists the files in prefix that match pattern with any of the
+// extensions, one segment of the pattern at a time, starting from the last
+// directory without a glob pattern. The prefix is never matched, so it can
+// have the special characters of path.Match in it.
+func globFiles(ctx context.Context, source Source, prefix string, pattern string, extensions []string) ([]string, error) {
+	segments := strings.Split(pattern, "/")
+	first := 0
+	for first < len(segments) && !hasGlob(segments[first]) {
+		first++
+	}
+	if first == len(segments) {
+		return nil, fmt.Errorf("the pattern (%s) doesn't have a glob", pattern)
+	}
+
+	dirs := []string{strings.TrimSuffix(prefix+strings.Join(segments[:first], "/"), "/")}
+	for index := first; index < len(segments); index++ {
+		isLast := index == len(segments)-1
+		matches := []string{}
+		for _, dir := range dirs {
+			entries, err := source.List(ctx, dir)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				isDir := strings.HasSuffix(entry, "/")
+				name := pathpkg.Base(entry)
+				switch {
+				case !isLast && isDir && matchGlob(segments[index], name, ""):
+					matches = append(matches, strings.TrimSuffix(entry, "/"))
+				case isLast && !isDir && matchGlob(segments[index], name, extensions...):
+					matches = append(matches, entry)
+				}
+			}
+		}
+		dirs = matches
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// splitPattern splits the path of a partition into the dataSource, with its
+// trailing `/`, and the part of the path from the layout, which is the only
+// part that can be a glob pattern
+func splitPattern(dataSource string, path string) (prefix string, pattern string) {
+	prefix = strings.TrimSuffix(dataSource, "/") + "/"
+	if dataSource == "" || !strings.HasPrefix(path, prefix) {
+		return "", path
+	}
+	return prefix, strings.TrimPrefix(path, prefix)
+}
+
+// hasGlob checks if a path has any of the special characters of path.Match
+func hasGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// matchGlob checks if name matches pattern with any of the extensions added
+// to it. A pattern that isn't valid doesn't match anything.
+func matchGlob(pattern string, name string, extensions ...string) bool {
+	for _, extension := range extensions {
+		if matched, _ := pathpkg.Match(pattern+extension, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// namedDayFile is what a readerFunc returns for a single day file without an
+// index, so that the lines read from it can say which file they're from
+type namedDayFile struct {
+	io.ReadCloser
+	path string // the path of the day file, with its extension
+}
+
+// dayFileChunks is what a readerFunc returns when a partition is split into
+// several files, like the chunks `01.0000.jsonl.gz` and `01.0001.jsonl.gz` of
+// a rotated log. Reading it reads the files one after the other, with a
+// newline in between in case a file doesn't end with one, but readDayFile
+// reads each chunk on its own and merges them by changeTime instead.
+type dayFileChunks struct {
+	chunks []dayFileChunk // in the order of their paths
+	reader io.Reader
+}
+
+// dayFileChunk is one of the files of a dayFileChunks
+type dayFileChunk struct {
+	path string
+	io.ReadCloser
+}
+
+func (d *dayFileChunks) Read(p []byte) (int, error) {
+	if d.reader == nil {
+		readers := []io.Reader{}
+		for _, chunk := range d.chunks {
+			readers = append(readers, chunk, strings.NewReader("\n"))
+		}
+		d.reader = io.MultiReader(readers...)
+	}
+	return d.reader.Read(p)
+}
+
+func (d *dayFileChunks) Close() error {
+	var err error
+	for _, chunk := range d.chunks {
+		if closeErr := chunk.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// DefaultMemSource is the Source for `mem://` data sources, unless another
+// one is registered. Files written to it can be read by every Client.
+var DefaultMemSource = NewMemSource()
+
+// MemSource is a Source that keeps files in memory, for tests and for data
+// that was generated by the program itself
+type MemSource struct {
+	mutex sync.RWMutex
+	files map[string]memFile
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+// NewMemSource creates an empty MemSource
+func NewMemSource() *MemSource {
+	return &MemSource{files: map[string]memFile{}}
+}
+
+// WriteFile adds a file to the source, replacing the file at path if there
+// already is one. The data is the raw file, which can be compressed.
+func (m *MemSource) WriteFile(path string, data []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.files[path] = memFile{data: append([]byte(nil), data...), modTime: time.Now()}
+}
+
+// Write is WriteFile for the WritableSource interface
+func (m *MemSource) Write(ctx context.Context, path string, data []byte) error {
+	m.WriteFile(path, data)
+	return nil
+}
+
+// Open returns the contents of the file at path
+func (m *MemSource) Open(ctx context.Context, path string) (io.ReadCloser, bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	file, ok := m.files[path]
+	if !ok {
+		return nil, false, nil
+	}
+	return ioutil.NopCloser(bytes.NewReader(file.data)), true, nil
+}
+
+// Stat describes the file at path
+func (m *MemSource) Stat(ctx context.Context, path string) (FileInfo, bool, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	file, ok := m.files[path]
+	if !ok {
+		return FileInfo{}, false, nil
+	}
+	return FileInfo{Size: int64(len(file.data)), ModTime: file.modTime}, true, nil
+}
+
+// List returns the files and directories directly inside of dir
+func (m *MemSource) List(ctx context.Context, dir string) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	seen := map[string]bool{}
+	output := []string{}
+	for path := range m.files {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		entry := prefix + strings.SplitAfter(path[len(prefix):], "/")[0] // <= directories keep their trailing `/`
+		if !seen[entry] {
+			seen[entry] = true
+			output = append(output, entry)
+		}
+	}
+	sort.Strings(output)
+	return output, nil
+}
@@ -0,0 +1,212 @@
+package replay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSourceScheme(t *testing.T) {
+	tdata := []struct {
+		dataSource     string
+		expectedOutput string
+	}{
+		{dataSource: "/tmp/ehub_data", expectedOutput: "file"},
+		{dataSource: "ehub_data", expectedOutput: "file"},
+		{dataSource: "file:///tmp/ehub_data", expectedOutput: "file"},
+		{dataSource: "s3://net.energyhub.assets/public/dev-exercises/audit-data/", expectedOutput: "s3"},
+		{dataSource: "https://files.example.com/audit-data", expectedOutput: "https"},
+		{dataSource: "mem://audit-data", expectedOutput: "mem"},
+	}
+	for _, test := range tdata {
+		t.Run(test.dataSource, func(t *testing.T) {
+			// logic under test
+			output := sourceScheme(test.dataSource)
+
+			// assertions
+			if output != test.expectedOutput {
+				t.Errorf("expected %q to equal %q", output, test.expectedOutput)
+			}
+		})
+	}
+}
+
+func TestSourceList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, path := range []string{"2016/01/01.jsonl.gz", "2016/01/02.jsonl.gz", "2016/02/01.jsonl.gz"} {
+		writeGzipFile(t, filepath.Join(dir, path), "")
+	}
+
+	memSource := NewMemSource()
+	for _, path := range []string{"2016/01/01.jsonl.gz", "2016/01/02.jsonl.gz", "2016/02/01.jsonl.gz"} {
+		memSource.WriteFile("mem://audit-data/"+path, []byte{})
+	}
+
+	tdata := []struct {
+		testCase       string
+		source         Source
+		dir            string
+		expectedOutput []string
+	}{
+		{
+			testCase:       "file_dirs",
+			source:         fileSource{},
+			dir:            dir + "/2016",
+			expectedOutput: []string{dir + "/2016/01/", dir + "/2016/02/"},
+		},
+		{
+			testCase:       "file_files",
+			source:         fileSource{},
+			dir:            "file://" + dir + "/2016/01/",
+			expectedOutput: []string{"file://" + dir + "/2016/01/01.jsonl.gz", "file://" + dir + "/2016/01/02.jsonl.gz"},
+		},
+		{
+			testCase:       "file_missing",
+			source:         fileSource{},
+			dir:            dir + "/2017",
+			expectedOutput: []string{},
+		},
+		{
+			testCase:       "mem_dirs",
+			source:         memSource,
+			dir:            "mem://audit-data/2016",
+			expectedOutput: []string{"mem://audit-data/2016/01/", "mem://audit-data/2016/02/"},
+		},
+		{
+			testCase:       "mem_files",
+			source:         memSource,
+			dir:            "mem://audit-data/2016/01/",
+			expectedOutput: []string{"mem://audit-data/2016/01/01.jsonl.gz", "mem://audit-data/2016/01/02.jsonl.gz"},
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			output, err := test.source.List(context.Background(), test.dir)
+
+			// assertions
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(test.expectedOutput, output) {
+				t.Errorf("expected %v to equal %v", test.expectedOutput, output)
+			}
+		})
+	}
+}
+
+// countingSource is a Source injected by a test, counting the files it opens,
+// describes and lists
+type countingSource struct {
+	*MemSource
+	opens int
+	stats int
+	lists int
+}
+
+func (c *countingSource) Open(ctx context.Context, path string) (output io.ReadCloser, found bool, err error) {
+	c.opens++
+	return c.MemSource.Open(ctx, path)
+}
+
+func (c *countingSource) Stat(ctx context.Context, path string) (output FileInfo, found bool, err error) {
+	c.stats++
+	return c.MemSource.Stat(ctx, path)
+}
+
+func (c *countingSource) List(ctx context.Context, dir string) ([]string, error) {
+	c.lists++
+	return c.MemSource.List(ctx, dir)
+}
+
+func TestClientSources(t *testing.T) {
+	dayFile := gzipBytes(t, `{"changeTime": "2016-01-01T00:30:00.001059", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 77.0}}`)
+
+	injected := &countingSource{MemSource: NewMemSource()}
+	injected.WriteFile("/tmp/ehub_data/2016/01/01.jsonl.gz", dayFile)
+	DefaultMemSource.WriteFile("mem://audit-data/2016/01/01.jsonl.gz", dayFile)
+	registered := NewMemSource()
+	registered.WriteFile("custom://audit-data/2016/01/01.jsonl.gz", dayFile)
+	RegisterSource("custom", func(config SourceConfig) (Source, error) {
+		return registered, nil
+	})
+
+	tdata := []struct {
+		testCase          string
+		dataSource        string
+		options           []Option
+		expectedErrorKind error
+	}{
+		{
+			testCase:   "injected",
+			dataSource: "/tmp/ehub_data",
+			options:    []Option{WithSource("file", injected)},
+		},
+		{
+			testCase:   "mem",
+			dataSource: "mem://audit-data",
+		},
+		{
+			testCase:   "registered",
+			dataSource: "custom://audit-data/",
+		},
+		{
+			testCase:          "unknown_scheme",
+			dataSource:        "ftp://audit-data",
+			expectedErrorKind: ErrBadInput,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			client, err := NewClient(test.dataSource, append(test.options, WithMaxLookback(0))...)
+			if test.expectedErrorKind != nil {
+				if !errors.Is(err, test.expectedErrorKind) {
+					t.Errorf("expected the error (%v) to be a %v error", err, test.expectedErrorKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			output, err := client.StateAt(context.Background(), testTime("2016-01-01T03:00"), []string{"ambientTemp"})
+
+			// assertions
+			if err != nil {
+				t.Fatal(err)
+			}
+			if output.Fields["ambientTemp"] != 79.0 {
+				t.Errorf("expected the ambientTemp (%v) to be 79.0", output.Fields["ambientTemp"])
+			}
+		})
+	}
+	if injected.opens != 1 {
+		t.Errorf("expected the injected source to be opened once, but it was opened %d times", injected.opens)
+	}
+}
+
+// gzipBytes compresses fileData the same way that day files are
+func gzipBytes(t *testing.T, fileData string) []byte {
+	compressed := bytes.Buffer{}
+	gzWriter := gzip.NewWriter(&compressed)
+	_, err := gzWriter.Write([]byte(fileData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gzWriter.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compressed.Bytes()
+}
@@ -2,9 +2,48 @@ package replay
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// timeFormats are the formats that stringToTime tries, for error messages
+var timeFormats = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"a unix epoch in seconds like 1451617200, or milliseconds, microseconds or nanoseconds like 1451617200000",
+}
+
+// relativeFormats are the formats that parseTimeExpression tries on top of
+// timeFormats, for error messages
+var relativeFormats = []string{
+	"now, today, yesterday or tomorrow",
+	"a time of day like yesterday 14:00",
+	"any of those followed by offsets like now-2h or 2016-01-01T03:00+1h30m",
+}
+
+// epochPattern matches a unix epoch, with an optional fraction
+var epochPattern = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// relativeOffsets matches the offsets at the end of a relative time, like
+// `-2h` or `+1d12h`
+var relativeOffsets = regexp.MustCompile(`([+-]([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h|d|w))+)+$`)
+
+// relativeOffset matches a single offset of a relative time
+var relativeOffset = regexp.MustCompile(`[+-]([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h|d|w))+`)
+
+// offsetUnit matches a number and its unit in an offset
+var offsetUnit = regexp.MustCompile(`([0-9]+(\.[0-9]+)?)(ns|us|µs|ms|s|m|h|d|w)`)
+
+// timeNow is time.Now, tests replace it to pin down relative times
+var timeNow = time.Now
+
 // stringToTime tries to parse a given string representation of a dateTime.
 //
 // This function returns early if there *was no error*, which contrasts the
@@ -16,13 +55,46 @@ import (
 // we know of (RFC3339Nano, which has nanoseconds and timezones) and
 // then gradually testing less specific times. This produces a `stringToTime`
 // function that can be used anywhere in our package without data loss,
-// so long as you use any of the 6 (!!!) time formats we try and parse.
+// so long as you use any of the 6 (!!!) time formats we try and parse, or a
+// unix epoch (see epochToTime).
+//
+// Formats without a timezone are in UTC, see stringToTimeIn for other zones.
 func stringToTime(dateTime string) (output time.Time, err error) {
+	return stringToTimeIn(dateTime, time.UTC)
+}
+
+// stringToTimeIn is stringToTime for dateTimes without a timezone that are in
+// location, which is UTC when it's nil. The dateTime can also end with the
+// IANA name of the zone that it's in, like `2016-01-01T03:00 America/New_York`
+// or `2016-01-01T03:00[America/New_York]`, which takes precedence over
+// location. The output is in that zone, or in the zone of its utc offset.
+func stringToTimeIn(dateTime string, location *time.Location) (output time.Time, err error) {
 
 	if dateTime == "" {
 		err = errors.New("the dateTime argument was empty")
 		return time.Time{}, err
 	}
+	if location == nil {
+		location = time.UTC
+	}
+
+	dateTime, zone, err := splitZoneName(dateTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if zone != nil {
+		location = zone
+		defer func() {
+			if err == nil {
+				output = output.In(zone) // <= for a dateTime that has a utc offset as well
+			}
+		}()
+	}
+
+	// a number is never any of the other formats
+	if epochPattern.MatchString(dateTime) {
+		return epochToTime(dateTime, location)
+	}
 
 	// this defines the "layout" of our dateTime argument
 	// see the following docs for details
@@ -52,7 +124,7 @@ func stringToTime(dateTime string) (output time.Time, err error) {
 	// 	nanoseconds: true
 	// 	seconds: true
 	layout = "2006-01-02T15:04:05.999999999"
-	output, err = time.Parse(layout, dateTime)
+	output, err = parseWallClock(layout, dateTime, location)
 	if err == nil {
 		return output, nil
 	}
@@ -76,7 +148,7 @@ func stringToTime(dateTime string) (output time.Time, err error) {
 	// 	nanoseconds: false
 	// 	seconds: true
 	layout = "2006-01-02T15:04:05"
-	output, err = time.Parse(layout, dateTime)
+	output, err = parseWallClock(layout, dateTime, location)
 	if err == nil {
 		return output, nil
 	}
@@ -87,7 +159,7 @@ func stringToTime(dateTime string) (output time.Time, err error) {
 	// 	timezone: true
 	// 	nanoseconds: false
 	// 	seconds: false
-	layout = "2006-01-02T15:04:05Z07:00"
+	layout = "2006-01-02T15:04Z07:00"
 	output, err = time.Parse(layout, dateTime)
 	if err == nil {
 		return output, nil
@@ -100,10 +172,295 @@ func stringToTime(dateTime string) (output time.Time, err error) {
 	// 	nanoseconds: false
 	// 	seconds: false
 	layout = "2006-01-02T15:04"
-	output, err = time.Parse(layout, dateTime)
+	output, err = parseWallClock(layout, dateTime, location)
 	if err == nil {
 		return output, nil
 	}
 
-	return output, err
+	err = fmt.Errorf("the dateTime (%s) isn't in any of the formats: %s", dateTime, strings.Join(timeFormats, "; "))
+	return time.Time{}, err
+}
+
+// epochUnits are the units of a unix epoch, by the most digits that an epoch
+// in the unit has up to the year 5000. Longer epochs are in nanoseconds.
+var epochUnits = []struct {
+	maxDigits int
+	unit      time.Duration
+}{
+	{maxDigits: 11, unit: time.Second},
+	{maxDigits: 14, unit: time.Millisecond},
+	{maxDigits: 17, unit: time.Microsecond},
+}
+
+// epochToTime converts a unix epoch in location. The unit is picked by the
+// number of digits (see epochUnits), and any of them can have a fraction.
+func epochToTime(epoch string, location *time.Location) (time.Time, error) {
+	whole, fraction := epoch, ""
+	if index := strings.Index(epoch, "."); index >= 0 {
+		whole, fraction = epoch[:index], epoch[index+1:]
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		err = fmt.Errorf("the epoch (%s) is out of range: %w", epoch, err)
+		return time.Time{}, err
+	}
+	sign := int64(1)
+	if strings.HasPrefix(whole, "-") {
+		sign = -1
+	}
+
+	unit := time.Nanosecond
+	for _, epochUnit := range epochUnits {
+		if len(strings.TrimPrefix(whole, "-")) <= epochUnit.maxDigits {
+			unit = epochUnit.unit
+			break
+		}
+	}
+	perSecond := int64(time.Second / unit)
+	seconds, nanoseconds := units/perSecond, (units%perSecond)*int64(unit)
+
+	// the fraction is padded or cut to the number of digits of nanoseconds
+	// in a unit
+	digits := len(strconv.FormatInt(int64(unit), 10)) - 1
+	if fraction != "" && digits > 0 {
+		fraction = (fraction + strings.Repeat("0", digits))[:digits]
+		fractionNanoseconds, _ := strconv.ParseInt(fraction, 10, 64) // <= only digits, per epochPattern
+		nanoseconds += sign * fractionNanoseconds
+	}
+	return time.Unix(seconds, nanoseconds).In(location), nil
+}
+
+// parseTimeExpression parses a dateTime that can be relative to now, on top
+// of the formats of stringToTimeIn:
+//
+//	now, today, yesterday or tomorrow, where the days start at midnight
+//	yesterday 14:00, for a time of day on one of those days
+//	now-2h or 2016-01-01T03:00+1h30m, for offsets from any of the others
+//
+// Offsets are in the units of time.ParseDuration, as well as d and w for
+// days and weeks. Days and weeks are added to the wall clock time in location,
+// so that a day is still a day when the clocks change. Days are in location
+// as well, unless the dateTime ends with the IANA name of another zone.
+func parseTimeExpression(expression string, location *time.Location) (output time.Time, err error) {
+	if location == nil {
+		location = time.UTC
+	}
+	expression = strings.TrimSpace(expression)
+	if expression == "" {
+		err = errors.New("the dateTime argument was empty")
+		return time.Time{}, err
+	}
+	rest, zone, err := splitZoneName(expression)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if zone != nil {
+		location = zone
+	}
+
+	base, offsets := rest, ""
+	if index := relativeOffsets.FindStringIndex(rest); index != nil && index[0] > 0 {
+		base, offsets = rest[:index[0]], rest[index[0]:]
+	}
+
+	output, found, err := relativeDay(base, location)
+	if err != nil {
+		err = fmt.Errorf("error parsing the dateTime (%s): %w", expression, err)
+		return time.Time{}, err
+	}
+	if !found {
+		output, err = stringToTimeIn(base, location)
+	}
+	if err != nil {
+		err = fmt.Errorf("the dateTime (%s) isn't in any of the formats: %s", expression, strings.Join(append(append([]string{}, timeFormats...), relativeFormats...), "; "))
+		return time.Time{}, err
+	}
+	if zone != nil {
+		output = output.In(zone) // <= for a dateTime that has a utc offset as well
+	}
+
+	for _, offset := range relativeOffset.FindAllString(offsets, -1) {
+		output, err = addOffset(output, offset)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	return output, nil
+}
+
+// relativeDay parses now, today, yesterday or tomorrow, with an optional time
+// of day for the days, found is false for anything else
+func relativeDay(base string, location *time.Location) (output time.Time, found bool, err error) {
+	day, timeOfDay := base, ""
+	if index := strings.Index(base, " "); index >= 0 {
+		day, timeOfDay = base[:index], strings.TrimSpace(base[index+1:])
+	}
+
+	now := timeNow().In(location)
+	var days int
+	switch day {
+	case "now":
+		if timeOfDay != "" {
+			return time.Time{}, false, fmt.Errorf("now can't have a time of day (%s)", timeOfDay)
+		}
+		return now, true, nil
+	case "today":
+		days = 0
+	case "yesterday":
+		days = -1
+	case "tomorrow":
+		days = 1
+	default:
+		return time.Time{}, false, nil
+	}
+
+	clock := time.Time{}
+	if timeOfDay != "" {
+		clock, err = time.Parse("15:04:05", timeOfDay)
+		if err != nil {
+			clock, err = time.Parse("15:04", timeOfDay)
+		}
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("the time of day (%s) isn't in the format 15:04 or 15:04:05", timeOfDay)
+		}
+	}
+	year, month, date := now.Date()
+	wallClock := time.Date(year, month, date+days, clock.Hour(), clock.Minute(), clock.Second(), 0, time.UTC)
+	return wallClockIn(wallClock, location), true, nil
+}
+
+// addOffset adds an offset like `-2h` or `+1d12h` to t
+func addOffset(t time.Time, offset string) (time.Time, error) {
+	sign := 1
+	if strings.HasPrefix(offset, "-") {
+		sign = -1
+	}
+	days, duration := 0, time.Duration(0)
+	for _, match := range offsetUnit.FindAllStringSubmatch(offset, -1) {
+		switch match[3] {
+		case "d", "w":
+			count, err := strconv.Atoi(match[1])
+			if err != nil {
+				err = fmt.Errorf("the offset (%s) has a fraction of a %s, days and weeks must be whole numbers", offset, match[3])
+				return time.Time{}, err
+			}
+			if match[3] == "w" {
+				count *= 7
+			}
+			days += count
+		default:
+			parsed, err := time.ParseDuration(match[0])
+			if err != nil {
+				err = fmt.Errorf("error parsing the offset (%s): %w", offset, err)
+				return time.Time{}, err
+			}
+			duration += parsed
+		}
+	}
+
+	if days != 0 {
+		year, month, day := t.Date()
+		hour, minute, second := t.Clock()
+		t = wallClockIn(time.Date(year, month, day+sign*days, hour, minute, second, t.Nanosecond(), time.UTC), t.Location())
+	}
+	return t.Add(time.Duration(sign) * duration), nil
+}
+
+// splitZoneName splits the IANA name of a zone off of the end of a dateTime,
+// zone is nil when it doesn't have one
+func splitZoneName(dateTime string) (rest string, zone *time.Location, err error) {
+	var name string
+	switch {
+	case strings.HasSuffix(dateTime, "]") && strings.Contains(dateTime, "["):
+		index := strings.LastIndex(dateTime, "[")
+		rest, name = dateTime[:index], dateTime[index+1:len(dateTime)-1]
+	case strings.Contains(dateTime, " "):
+		index := strings.LastIndex(dateTime, " ")
+		rest, name = strings.TrimSpace(dateTime[:index]), dateTime[index+1:]
+		if _, err := time.LoadLocation(name); err != nil && !strings.Contains(name, "/") {
+			return dateTime, nil, nil // <= not a zone name, the dateTime just has a space in it
+		}
+	default:
+		return dateTime, nil, nil
+	}
+
+	zone, err = loadLocation(name)
+	if err != nil {
+		return "", nil, err
+	}
+	return rest, zone, nil
+}
+
+// loadLocation loads the zone with an IANA name like `America/New_York`, or
+// UTC for an empty name
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	output, err := time.LoadLocation(name)
+	if err != nil {
+		err = fmt.Errorf("unknown time zone (%s), time zones are IANA names like UTC or America/New_York", name)
+		return nil, err
+	}
+	return output, nil
+}
+
+// parseWallClock parses a dateTime without a timezone as the wall clock time
+// in location.
+//
+// The wall clock skips an hour when the clocks go forward, and repeats one
+// when they go back, which time.ParseInLocation doesn't make any promises
+// about. A time in the repeated hour is the first of the two, and a time in
+// the skipped hour is moved forward by the length of the gap, so that
+// 02:30 on the day that New York goes from 02:00 to 03:00 is 03:30.
+func parseWallClock(layout string, dateTime string, location *time.Location) (time.Time, error) {
+	wallClock, err := time.Parse(layout, dateTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return wallClockIn(wallClock, location), nil
+}
+
+// wallClockIn returns the time in location that shows the same wall clock
+// time as wallClock, which is in UTC, see parseWallClock
+func wallClockIn(wallClock time.Time, location *time.Location) time.Time {
+	if location == time.UTC {
+		return wallClock
+	}
+
+	// the offset that the zone has a day either side of the time covers both
+	// sides of a change of the clocks
+	var output time.Time
+	for _, probe := range []time.Duration{-24 * time.Hour, 24 * time.Hour} {
+		_, offset := wallClock.Add(probe).In(location).Zone()
+		candidate := wallClock.Add(-time.Duration(offset) * time.Second).In(location)
+		if sameWallClock(candidate, wallClock) && (output.IsZero() || candidate.Before(output)) {
+			output = candidate
+		}
+	}
+	if output.IsZero() {
+		// skipped, so it's read with the offset from before the clocks changed
+		_, offset := wallClock.Add(-24 * time.Hour).In(location).Zone()
+		output = wallClock.Add(-time.Duration(offset) * time.Second).In(location)
+	}
+	return output
+}
+
+// sameWallClock returns true if t shows the same wall clock time as wallClock,
+// which is in UTC
+func sameWallClock(t time.Time, wallClock time.Time) bool {
+	year, month, day := t.Date()
+	hour, minute, second := t.Clock()
+	return time.Date(year, month, day, hour, minute, second, t.Nanosecond(), time.UTC).Equal(wallClock)
+}
+
+// formatTime formats t with layout in the zone that it's in. Times that
+// aren't in UTC have their utc offset added to the end, ex:
+// `2016-01-01T03:00:00-05:00`, since the wall clock time on its own is
+// ambiguous.
+func formatTime(t time.Time, layout string) string {
+	if t.Location() == time.UTC {
+		return t.Format(layout)
+	}
+	return t.Format(layout + "Z07:00")
 }
@@ -2,6 +2,7 @@ package replay
 
 import (
 	"testing"
+	"time"
 )
 
 func TestStringToTime(t *testing.T) {
@@ -52,3 +53,210 @@ func TestStringToTime(t *testing.T) {
 		})
 	}
 }
+
+func TestStringToTimeIn(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tdata := []struct {
+		testCase        string
+		input           string
+		location        *time.Location
+		expectedUTC     string
+		expectedOutput  string
+		expectedAnError bool
+	}{
+		{
+			testCase:       "no_zone",
+			input:          "2016-01-01T03:00",
+			location:       newYork,
+			expectedUTC:    "2016-01-01T08:00:00Z",
+			expectedOutput: "2016-01-01T03:00:00-05:00",
+		},
+		{
+			testCase:       "nil_location",
+			input:          "2016-01-01T03:00",
+			expectedUTC:    "2016-01-01T03:00:00Z",
+			expectedOutput: "2016-01-01T03:00:00",
+		},
+		{
+			testCase:       "utc_offset",
+			input:          "2016-01-01T23:30-05:00",
+			location:       time.UTC,
+			expectedUTC:    "2016-01-02T04:30:00Z",
+			expectedOutput: "2016-01-01T23:30:00-05:00",
+		},
+		{
+			testCase:       "zone_name",
+			input:          "2016-07-01T03:00 America/New_York",
+			location:       time.UTC,
+			expectedUTC:    "2016-07-01T07:00:00Z",
+			expectedOutput: "2016-07-01T03:00:00-04:00",
+		},
+		{
+			testCase:       "bracketed_zone_name",
+			input:          "2016-01-01T08:00:00Z[America/New_York]",
+			location:       time.UTC,
+			expectedUTC:    "2016-01-01T08:00:00Z",
+			expectedOutput: "2016-01-01T03:00:00-05:00",
+		},
+		{
+			testCase:       "clocks_go_forward",
+			input:          "2016-03-13T02:30",
+			location:       newYork,
+			expectedUTC:    "2016-03-13T07:30:00Z",
+			expectedOutput: "2016-03-13T03:30:00-04:00",
+		},
+		{
+			testCase:       "clocks_go_back",
+			input:          "2016-11-06T01:30",
+			location:       newYork,
+			expectedUTC:    "2016-11-06T05:30:00Z",
+			expectedOutput: "2016-11-06T01:30:00-04:00",
+		},
+		{
+			testCase:       "epoch_seconds",
+			input:          "1451617200",
+			location:       newYork,
+			expectedUTC:    "2016-01-01T03:00:00Z",
+			expectedOutput: "2015-12-31T22:00:00-05:00",
+		},
+		{
+			testCase:       "epoch_milliseconds",
+			input:          "1451617200123",
+			location:       time.UTC,
+			expectedUTC:    "2016-01-01T03:00:00.123Z",
+			expectedOutput: "2016-01-01T03:00:00",
+		},
+		{
+			testCase:       "epoch_max_seconds",
+			input:          "99999999999", // <= 11 digits
+			location:       time.UTC,
+			expectedUTC:    "5138-11-16T09:46:39Z",
+			expectedOutput: "5138-11-16T09:46:39",
+		},
+		{
+			testCase:       "epoch_microseconds",
+			input:          "1451617200123456",
+			location:       time.UTC,
+			expectedUTC:    "2016-01-01T03:00:00.123456Z",
+			expectedOutput: "2016-01-01T03:00:00",
+		},
+		{
+			testCase:       "epoch_nanoseconds",
+			input:          "1451617200123456789",
+			location:       time.UTC,
+			expectedUTC:    "2016-01-01T03:00:00.123456789Z",
+			expectedOutput: "2016-01-01T03:00:00",
+		},
+		{
+			testCase:       "epoch_milliseconds_fraction",
+			input:          "1451617200123.5",
+			location:       time.UTC,
+			expectedUTC:    "2016-01-01T03:00:00.1235Z",
+			expectedOutput: "2016-01-01T03:00:00",
+		},
+		{
+			testCase:       "epoch_negative_milliseconds",
+			input:          "-1451617200123",
+			location:       time.UTC,
+			expectedUTC:    "1924-01-01T20:59:59.877Z",
+			expectedOutput: "1924-01-01T20:59:59",
+		},
+		{
+			testCase:        "epoch_out_of_range",
+			input:           "99999999999999999999",
+			location:        time.UTC,
+			expectedAnError: true,
+		},
+		{
+			testCase:       "epoch_fraction",
+			input:          "1451617200.25",
+			location:       time.UTC,
+			expectedUTC:    "2016-01-01T03:00:00.25Z",
+			expectedOutput: "2016-01-01T03:00:00",
+		},
+		{
+			testCase:        "unknown_zone_name",
+			input:           "2016-01-01T03:00 America/Nowhere",
+			location:        time.UTC,
+			expectedAnError: true,
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			output, err := stringToTimeIn(test.input, test.location)
+
+			// assertions
+			if test.expectedAnError != (err != nil) {
+				t.Fatalf("expected an error: %v, but the error was: %v", test.expectedAnError, err)
+			}
+			if err != nil {
+				return
+			}
+			if utc := output.UTC().Format(time.RFC3339Nano); utc != test.expectedUTC {
+				t.Errorf("expected %s to equal %s", utc, test.expectedUTC)
+			}
+			if formatted := formatTime(output, "2006-01-02T15:04:05"); formatted != test.expectedOutput {
+				t.Errorf("expected %s to equal %s", formatted, test.expectedOutput)
+			}
+		})
+	}
+}
+
+func TestParseTimeExpression(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(original func() time.Time) { timeNow = original }(timeNow)
+	timeNow = func() time.Time { return time.Date(2016, 3, 14, 15, 4, 5, 0, time.UTC) }
+
+	tdata := []struct {
+		testCase        string
+		input           string
+		location        *time.Location
+		expectedUTC     string
+		expectedAnError bool
+	}{
+		{testCase: "absolute", input: "2016-01-01T03:00", expectedUTC: "2016-01-01T03:00:00Z"},
+		{testCase: "now", input: "now", expectedUTC: "2016-03-14T15:04:05Z"},
+		{testCase: "now_minus_hours", input: "now-2h", expectedUTC: "2016-03-14T13:04:05Z"},
+		{testCase: "today", input: "today", expectedUTC: "2016-03-14T00:00:00Z"},
+		{testCase: "yesterday_time_of_day", input: "yesterday 14:00", expectedUTC: "2016-03-13T14:00:00Z"},
+		{testCase: "tomorrow_seconds", input: "tomorrow 01:02:03", expectedUTC: "2016-03-15T01:02:03Z"},
+		{testCase: "absolute_plus_offset", input: "2016-01-01T03:00+1h30m", expectedUTC: "2016-01-01T04:30:00Z"},
+		{testCase: "utc_offset_and_offset", input: "2016-01-01T03:00-05:00-30m", expectedUTC: "2016-01-01T07:30:00Z"},
+		{testCase: "several_offsets", input: "today+1w-1d+12h", expectedUTC: "2016-03-20T12:00:00Z"},
+		{testCase: "epoch_plus_offset", input: "1451617200+1h", expectedUTC: "2016-01-01T04:00:00Z"},
+		{testCase: "yesterday_in_zone", input: "yesterday 14:00", location: newYork, expectedUTC: "2016-03-13T18:00:00Z"},
+		{testCase: "yesterday_zone_name", input: "yesterday 14:00 America/New_York", expectedUTC: "2016-03-13T18:00:00Z"},
+		{testCase: "days_across_the_clocks_changing", input: "2016-03-12T12:00+1d", location: newYork, expectedUTC: "2016-03-13T16:00:00Z"},
+		{testCase: "hours_across_the_clocks_changing", input: "2016-03-12T12:00+24h", location: newYork, expectedUTC: "2016-03-13T17:00:00Z"},
+		{testCase: "fraction_of_a_day", input: "now-1.5d", expectedAnError: true},
+		{testCase: "now_time_of_day", input: "now 14:00", expectedAnError: true},
+		{testCase: "bad_time_of_day", input: "yesterday 25:00", expectedAnError: true},
+		{testCase: "offset_only", input: "-2h", expectedAnError: true},
+		{testCase: "empty", input: " ", expectedAnError: true},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			// logic under test
+			output, err := parseTimeExpression(test.input, test.location)
+
+			// assertions
+			if test.expectedAnError != (err != nil) {
+				t.Fatalf("expected an error: %v, but the error was: %v", test.expectedAnError, err)
+			}
+			if err != nil {
+				return
+			}
+			if utc := output.UTC().Format(time.RFC3339Nano); utc != test.expectedUTC {
+				t.Errorf("expected %s to equal %s", utc, test.expectedUTC)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The kinds of breaks in a change log
+const (
+	BreakMismatch   = "mismatch"      // the before of a field isn't the after of the change before it
+	BreakOutOfOrder = "outOfOrder"    // the changeTime is before the changeTime of the line before it
+	BreakDuplicate  = "duplicateTime" // the changeTime is the same as the changeTime of the line before it
+	BreakBadLine    = "badLine"       // the line isn't an event that can be read
+)
+
+type getVerifyInput struct {
+	dataSource string
+	layout     layout
+	readerFunc readerFunc
+	from       time.Time
+	to         time.Time
+}
+
+// VerifyResult is every break in the change log that was found by
+// Client.Verify
+type VerifyResult struct {
+	From   string        `json:"from"`
+	To     string        `json:"to"`
+	Files  int           `json:"files"`  // the number of files that were read
+	Lines  int           `json:"lines"`  // the number of events that were checked
+	Breaks []VerifyBreak `json:"breaks"` // in the order of the events
+}
+
+// VerifyBreak is a single break in the change log
+type VerifyBreak struct {
+	Kind       string      `json:"kind"` // one of BreakMismatch, BreakOutOfOrder, BreakDuplicate or BreakBadLine
+	Path       string      `json:"path"`
+	Line       int         `json:"line"`                 // starting from 1
+	ChangeTime string      `json:"changeTime,omitempty"` // formatted like Change.Ts, empty for a BreakBadLine
+	Field      string      `json:"field,omitempty"`      // only for a BreakMismatch
+	Before     interface{} `json:"before,omitempty"`     // the before of the field, only for a BreakMismatch
+	After      interface{} `json:"after,omitempty"`      // the after of the previous change to the field, only for a BreakMismatch
+	Previous   *VerifyLine `json:"previous,omitempty"`   // the line this one was compared with, if any
+	Message    string      `json:"message"`
+}
+
+// VerifyLine is where a line is in the change log
+type VerifyLine struct {
+	Path       string `json:"path"`
+	Line       int    `json:"line"`
+	ChangeTime string `json:"changeTime"`
+}
+
+// verifiedField is the state of a field after the last change to it
+type verifiedField struct {
+	value interface{}
+	line  dayFileLine
+}
+
+// getVerify checks the change log of every partition between from and to
+// (inclusive). The before of each field should be the after of the change
+// before it, across partitions as well, and the changeTimes should only go
+// up. Lines that can't be read are reported instead of stopping the check.
+//
+// Objects are partial updates (see mergePatch), so the before of an object is
+// only compared with the keys that have been seen so far. A field is only
+// checked once it has changed at least once in the range.
+func getVerify(ctx context.Context, input getVerifyInput) (output VerifyResult, err error) {
+	err = checkTimeRange(input.from, input.to)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+
+	output = VerifyResult{
+		From:   formatTime(input.from, "2006-01-02T15:04:05"),
+		To:     formatTime(input.to, "2006-01-02T15:04:05"),
+		Breaks: []VerifyBreak{},
+	}
+	location := input.from.Location()
+	state := map[string]verifiedField{}
+	var previous *dayFileLine
+	previousPath := ""
+	for partition := input.layout.partitionStart(input.from); !partition.After(input.to); partition = input.layout.addPartitions(partition, 1) {
+		path := input.layout.path(input.dataSource, partition)
+		if path == previousPath {
+			continue // <= the hour that is repeated when the clocks go back is in the same file
+		}
+		previousPath = path
+		fileData, found, err := openDayFile(ctx, path, input.readerFunc)
+		if err != nil {
+			return VerifyResult{}, err
+		}
+		if !found {
+			continue
+		}
+
+		scanners := newLineScanners(path, input.layout.timeZone(), nil, fileData)
+		for _, scanner := range scanners {
+			scanner.onBadLine = func(line badLineError) error {
+				output.Breaks = append(output.Breaks, VerifyBreak{
+					Kind:    BreakBadLine,
+					Path:    line.path,
+					Line:    line.lineNumber,
+					Message: line.message(),
+				})
+				return nil
+			}
+		}
+		output.Files += len(scanners)
+
+		err = mergeChunks(scanners, func(line dayFileLine) error {
+			output.Lines++
+			output.Breaks = verifyOrder(output.Breaks, line, previous, location)
+			output.Breaks = verifyFields(output.Breaks, line, state, location)
+			previous = &line
+			return nil
+		})
+		fileData.Close()
+		if err != nil {
+			return VerifyResult{}, err
+		}
+	}
+
+	return output, nil
+}
+
+// verifyOrder adds a break when a line's changeTime isn't after the one
+// before it
+func verifyOrder(output []VerifyBreak, line dayFileLine, previous *dayFileLine, location *time.Location) []VerifyBreak {
+	if previous == nil || line.changeTime.After(previous.changeTime) {
+		return output
+	}
+	kind, message := BreakOutOfOrder, "the changeTime is before the changeTime of the line before it"
+	if line.changeTime.Equal(previous.changeTime) {
+		kind, message = BreakDuplicate, "the changeTime is the same as the changeTime of the line before it"
+	}
+	return append(output, VerifyBreak{
+		Kind:       kind,
+		Path:       line.path,
+		Line:       line.lineNumber,
+		ChangeTime: formatTime(line.changeTime.In(location), changeTimeLayout),
+		Previous:   verifyLine(*previous, location),
+		Message:    message,
+	})
+}
+
+// verifyFields adds a break for every field whose before isn't the after of
+// the previous change to it, and then applies the line's after to the state
+func verifyFields(output []VerifyBreak, line dayFileLine, state map[string]verifiedField, location *time.Location) []VerifyBreak {
+	for _, field := range stateColumns(line.data.Before) { // <= sorted, so the breaks are in a stable order
+		before := line.data.Before[field]
+		existing, found := state[field]
+		if !found || !valuesConflict(before, existing.value) {
+			continue
+		}
+		output = append(output, VerifyBreak{
+			Kind:       BreakMismatch,
+			Path:       line.path,
+			Line:       line.lineNumber,
+			ChangeTime: formatTime(line.changeTime.In(location), changeTimeLayout),
+			Field:      field,
+			Before:     before,
+			After:      existing.value,
+			Previous:   verifyLine(existing.line, location),
+			Message:    fmt.Sprintf("the before of the field %s doesn't match the after of the change before it", field),
+		})
+	}
+
+	for field, after := range line.data.After {
+		state[field] = verifiedField{
+			value: mergePatch(state[field].value, after),
+			line:  line,
+		}
+	}
+	return output
+}
+
+// verifyLine returns where a line is, for a VerifyBreak
+func verifyLine(line dayFileLine, location *time.Location) *VerifyLine {
+	return &VerifyLine{
+		Path:       line.path,
+		Line:       line.lineNumber,
+		ChangeTime: formatTime(line.changeTime.In(location), changeTimeLayout),
+	}
+}
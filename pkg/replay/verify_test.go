@@ -0,0 +1,175 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestClientVerify(t *testing.T) {
+	tdata := []struct {
+		testCase       string
+		files          map[string]string
+		options        []Option
+		expectedLines  int
+		expectedBreaks []VerifyBreak
+	}{
+		{
+			testCase: "consistent",
+			files: map[string]string{
+				"2016/01/01.jsonl": `
+					{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0, "setpoint": {"heatTemp": 68.0, "coolTemp": 76.0}}, "before": {"ambientTemp": 77.0, "setpoint": {"heatTemp": 67.0}}}
+					{"changeTime": "2016-01-01T02:00:00", "after": {"setpoint": {"coolTemp": 75.0}}, "before": {"setpoint": {"coolTemp": 76.0}}}
+				`,
+				"2016/01/02.jsonl": `
+					{"changeTime": "2016-01-02T01:00:00", "after": {"ambientTemp": 79.0, "fan": "auto"}, "before": {"ambientTemp": 78.0, "fan": null}}
+				`,
+			},
+			expectedLines:  3,
+			expectedBreaks: []VerifyBreak{},
+		},
+		{
+			testCase: "mismatch_across_days",
+			files: map[string]string{
+				"2016/01/01.jsonl": `{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}`,
+				"2016/01/03.jsonl": `{"changeTime": "2016-01-03T01:00:00", "after": {"ambientTemp": 80.0}, "before": {"ambientTemp": 79.0}}`,
+			},
+			expectedLines: 2,
+			expectedBreaks: []VerifyBreak{
+				{
+					Kind:       BreakMismatch,
+					Path:       "mem://audit-data/2016/01/03.jsonl",
+					Line:       1,
+					ChangeTime: "2016-01-03T01:00:00",
+					Field:      "ambientTemp",
+					Before:     79.0,
+					After:      78.0,
+					Previous:   &VerifyLine{Path: "mem://audit-data/2016/01/01.jsonl", Line: 1, ChangeTime: "2016-01-01T01:00:00"},
+					Message:    "the before of the field ambientTemp doesn't match the after of the change before it",
+				},
+			},
+		},
+		{
+			testCase: "mismatch_in_an_object",
+			files: map[string]string{
+				"2016/01/01.jsonl": `
+					{"changeTime": "2016-01-01T01:00:00", "after": {"setpoint": {"heatTemp": 68.0}}, "before": {"setpoint": {"heatTemp": 67.0}}}
+					{"changeTime": "2016-01-01T02:00:00", "after": {"setpoint": {"heatTemp": 70.0, "coolTemp": 75.0}}, "before": {"setpoint": {"heatTemp": 69.0, "coolTemp": 76.0}}}
+				`,
+			},
+			expectedLines: 2,
+			expectedBreaks: []VerifyBreak{
+				{
+					Kind:       BreakMismatch,
+					Path:       "mem://audit-data/2016/01/01.jsonl",
+					Line:       3,
+					ChangeTime: "2016-01-01T02:00:00",
+					Field:      "setpoint",
+					Before:     map[string]interface{}{"heatTemp": 69.0, "coolTemp": 76.0},
+					After:      map[string]interface{}{"heatTemp": 68.0},
+					Previous:   &VerifyLine{Path: "mem://audit-data/2016/01/01.jsonl", Line: 2, ChangeTime: "2016-01-01T01:00:00"},
+					Message:    "the before of the field setpoint doesn't match the after of the change before it",
+				},
+			},
+		},
+		{
+			testCase: "out_of_order_and_duplicate_times",
+			files: map[string]string{
+				"2016/01/01.jsonl": `{"changeTime": "2016-01-01T02:00:00", "after": {"schedule": true}, "before": {"schedule": false}}
+{"changeTime": "2016-01-01T01:00:00.5", "after": {"fan": "on"}, "before": {"fan": "auto"}}
+{"changeTime": "2016-01-01T01:00:00.5", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}`,
+			},
+			expectedLines: 3,
+			expectedBreaks: []VerifyBreak{
+				{
+					Kind:       BreakOutOfOrder,
+					Path:       "mem://audit-data/2016/01/01.jsonl",
+					Line:       2,
+					ChangeTime: "2016-01-01T01:00:00.5",
+					Previous:   &VerifyLine{Path: "mem://audit-data/2016/01/01.jsonl", Line: 1, ChangeTime: "2016-01-01T02:00:00"},
+					Message:    "the changeTime is before the changeTime of the line before it",
+				},
+				{
+					Kind:       BreakDuplicate,
+					Path:       "mem://audit-data/2016/01/01.jsonl",
+					Line:       3,
+					ChangeTime: "2016-01-01T01:00:00.5",
+					Previous:   &VerifyLine{Path: "mem://audit-data/2016/01/01.jsonl", Line: 2, ChangeTime: "2016-01-01T01:00:00.5"},
+					Message:    "the changeTime is the same as the changeTime of the line before it",
+				},
+			},
+		},
+		{
+			testCase: "bad_lines",
+			files: map[string]string{
+				"2016/01/01.jsonl": `{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+
+{"after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 78.0}}
+{"changeTime": "2016-01-01T03:00:00", "after": {"ambientTemp": 80.0}, "before": {"ambientTemp": 78.0}}`,
+			},
+			expectedLines: 2,
+			expectedBreaks: []VerifyBreak{
+				{
+					Kind:    BreakBadLine,
+					Path:    "mem://audit-data/2016/01/01.jsonl",
+					Line:    3,
+					Message: "error parsing the changeTime: the dateTime argument was empty",
+				},
+			},
+		},
+		{
+			testCase: "chunks",
+			files: map[string]string{
+				"2016/01/01.0000.jsonl": `
+					{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+					{"changeTime": "2016-01-01T03:00:00", "after": {"ambientTemp": 80.0}, "before": {"ambientTemp": 79.0}}
+				`,
+				"2016/01/01.0001.jsonl": `
+					{"changeTime": "2016-01-01T01:00:00", "after": {"ambientTemp": 78.0}, "before": {"ambientTemp": 77.0}}
+					{"changeTime": "2016-01-01T02:00:00", "after": {"ambientTemp": 79.0}, "before": {"ambientTemp": 78.0}}
+				`,
+			},
+			options:        []Option{WithChunks()},
+			expectedLines:  3, // <= the duplicate from the 2nd chunk is only checked once
+			expectedBreaks: []VerifyBreak{},
+		},
+	}
+	for _, test := range tdata {
+		t.Run(test.testCase, func(t *testing.T) {
+			source := NewMemSource()
+			for path, dayFile := range test.files {
+				source.WriteFile("mem://audit-data/"+path, []byte(dayFile))
+			}
+			client, err := NewClient("mem://audit-data", append(test.options, WithSource("mem", source))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// logic under test
+			output, err := client.Verify(context.Background(), testTime("2016-01-01T00:00"), testTime("2016-01-03T00:00"))
+
+			// assertions
+			if err != nil {
+				t.Fatal(err)
+			}
+			if output.Lines != test.expectedLines {
+				t.Errorf("expected %d lines to equal %d", output.Lines, test.expectedLines)
+			}
+			if !reflect.DeepEqual(test.expectedBreaks, output.Breaks) {
+				t.Errorf("expected %+v to equal %+v", output.Breaks, test.expectedBreaks)
+			}
+		})
+	}
+}
+
+func TestClientVerifyBadRange(t *testing.T) {
+	client, err := NewClient("mem://audit-data", WithSource("mem", NewMemSource()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Verify(context.Background(), testTime("2016-01-03T00:00"), testTime("2016-01-01T00:00"))
+	if !errors.Is(err, ErrBadInput) {
+		t.Errorf("expected the error (%v) to be a %v error", err, ErrBadInput)
+	}
+}